@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/keys"
+	"github.com/HarshithRajesh/PixelForge/internal/migrate"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/internal/scrub"
+	"github.com/HarshithRajesh/PixelForge/storage"
+	"gorm.io/gorm"
+)
+
+const usage = `usage: pixelforge [command]
+
+With no command, pixelforge serves the HTTP API.
+
+commands:
+  storage scrub   cross-check stored files against the images table; exits
+                  3 when it finds problems it did not repair
+  storage migrate copy every stored file to another backend and switch the
+                  images over; safe to re-run after an interruption
+  keys rewrap     re-wrap every data key with ENCRYPTION_ACTIVE_KEY; run it
+                  after rotating the master key, before removing the old one.
+                  The server re-wraps JWT signing keys itself
+  quota           manage quota plans, per-user quotas and usage counters;
+                  run "pixelforge quota" for details
+  role            manage custom roles and grant roles to users; run
+                  "pixelforge role" for details
+`
+
+// runCommand runs a maintenance subcommand and returns the exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch {
+	case len(args) >= 2 && args[0] == "storage" && args[1] == "scrub":
+		return storageScrub(args[2:], stdout, stderr)
+	case len(args) >= 2 && args[0] == "storage" && args[1] == "migrate":
+		return storageMigrate(args[2:], stdout, stderr)
+	case len(args) >= 2 && args[0] == "keys" && args[1] == "rewrap":
+		return keysRewrap(args[2:], stdout, stderr)
+	case len(args) >= 1 && args[0] == "quota":
+		return quotaCommand(args[1:], stdout, stderr)
+	case len(args) >= 1 && args[0] == "role":
+		return roleCommand(args[1:], stdout, stderr)
+	}
+	fmt.Fprint(stderr, usage)
+	return 2
+}
+
+// openStorage returns the configured backend, wrapped so files are encrypted
+// at rest when master keys are configured.
+func openStorage(ctx context.Context, db *gorm.DB) (storage.StorageRepository, error) {
+	store, err := storage.New(ctx, config.LoadStorage())
+	if err != nil {
+		return nil, err
+	}
+	return withEncryption(db, store)
+}
+
+// withEncryption wraps each store in place so files are encrypted at rest
+// when master keys are configured, and returns the first. The stores share
+// one keyring.
+func withEncryption(db *gorm.DB, stores ...storage.StorageRepository) (storage.StorageRepository, error) {
+	enc, err := config.LoadEncryption()
+	if err != nil {
+		return nil, err
+	}
+	if enc.Enabled() {
+		ring := keys.NewKeyring(repository.NewKeyRepository(db), enc)
+		for n := range stores {
+			stores[n] = storage.NewEncryptedStorage(stores[n], ring)
+		}
+	}
+	return stores[0], nil
+}
+
+func storageScrub(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("storage scrub", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	repair := fs.Bool("repair", false, "delete orphaned files and flag rows whose file is missing or corrupt")
+	dryRun := fs.Bool("dry-run", false, "report the repairs -repair would make without making them")
+	minAge := fs.Duration("min-age", time.Hour, "leave orphaned files younger than this alone")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := config.ConnectDB()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	store, err := openStorage(ctx, db)
+	if err != nil {
+		fmt.Fprintln(stderr, "storage:", err)
+		return 1
+	}
+
+	scrubber := scrub.New(repository.NewScrubRepository(db), store, scrub.Options{
+		Repair: *repair || *dryRun,
+		DryRun: *dryRun,
+		MinAge: *minAge,
+	})
+	report, err := scrubber.Run(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "scrub:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printScrubReport(stdout, report)
+	}
+	// Repair cannot fix files it was unable to read.
+	if !report.Clean() && (!*repair || *dryRun || len(report.Unreadable) > 0) {
+		return 3
+	}
+	return 0
+}
+
+func storageMigrate(args []string, stdout, stderr io.Writer) int {
+	cfg := config.LoadStorage()
+	fs := flag.NewFlagSet("storage migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	from := fs.String("from", cfg.Backend, "backend to copy from")
+	to := fs.String("to", "", "backend to copy to: local or s3")
+	workers := fs.Int("workers", 4, "files copied at once")
+	dryRun := fs.Bool("dry-run", false, "list what would be copied without copying")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *to == "" {
+		fmt.Fprintln(stderr, "storage migrate: -to is required")
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := config.ConnectDB()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	src, err := storage.Open(ctx, cfg, *from)
+	if err != nil {
+		fmt.Fprintln(stderr, "storage:", err)
+		return 1
+	}
+	dst, err := storage.Open(ctx, cfg, *to)
+	if err != nil {
+		fmt.Fprintln(stderr, "storage:", err)
+		return 1
+	}
+	stores := []storage.StorageRepository{src, dst}
+	if _, err := withEncryption(db, stores...); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	migrator, err := migrate.New(repository.NewMigrationRepository(db), stores[0], stores[1], migrate.Options{
+		Workers: *workers,
+		DryRun:  *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 2
+	}
+	report, err := migrator.Run(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		verb := "copied"
+		if report.DryRun {
+			verb = "would copy"
+		}
+		fmt.Fprintf(stdout, "%s -> %s: %d images in %d files\n", report.From, report.To, report.Images, report.Files)
+		fmt.Fprintf(stdout, "%s %d files (%d bytes), %d already present\n", verb, report.Copied, report.Bytes, report.Present)
+		fmt.Fprintf(stdout, "failed: %d\n", len(report.Failed))
+		for _, f := range report.Failed {
+			fmt.Fprintf(stdout, "  user %d %s: %s\n", f.UserID, f.Path, f.Error)
+		}
+	}
+	if len(report.Failed) > 0 {
+		return 3
+	}
+	return 0
+}
+
+func keysRewrap(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("keys rewrap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	enc, err := config.LoadEncryption()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if !enc.Enabled() {
+		fmt.Fprintln(stderr, "encryption is not configured; set ENCRYPTION_MASTER_KEYS")
+		return 1
+	}
+	db, err := config.ConnectDB()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	n, err := keys.NewKeyring(repository.NewKeyRepository(db), enc).Rewrap(context.Background())
+	fmt.Fprintf(stdout, "re-wrapped %d data keys with %q\n", n, enc.ActiveKeyID)
+	if err != nil {
+		fmt.Fprintln(stderr, "rewrap:", err)
+		return 1
+	}
+	return 0
+}
+
+func printScrubReport(w io.Writer, r *scrub.Report) {
+	fmt.Fprintf(w, "scanned %d users, %d files, %d images\n", r.Users, r.Files, r.Images)
+	sections := []struct {
+		name     string
+		findings []scrub.Finding
+	}{
+		{"orphaned files", r.Orphans},
+		{"missing files", r.Missing},
+		{"checksum mismatches", r.Corrupt},
+		{"restored files", r.Restored},
+		{"unreadable files", r.Unreadable},
+	}
+	for _, sec := range sections {
+		fmt.Fprintf(w, "%s: %d\n", sec.name, len(sec.findings))
+		for _, f := range sec.findings {
+			line := fmt.Sprintf("  user %d %s", f.UserID, f.Path)
+			if f.ImageID != 0 {
+				line += fmt.Sprintf(" (image %d)", f.ImageID)
+			}
+			if f.Action != "" {
+				line += " -> " + f.Action
+			}
+			if f.Error != "" {
+				line += ": " + f.Error
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
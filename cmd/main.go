@@ -1,35 +1,100 @@
 package main
 
 import (
+	"context"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
+	"github.com/HarshithRajesh/PixelForge/internal/admin"
+	"github.com/HarshithRajesh/PixelForge/internal/apikey"
 	"github.com/HarshithRajesh/PixelForge/internal/config"
 	"github.com/HarshithRajesh/PixelForge/internal/handler"
+	"github.com/HarshithRajesh/PixelForge/internal/mailer"
 	"github.com/HarshithRajesh/PixelForge/internal/middleware"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/oidc"
 	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
 	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/internal/signing"
 	"github.com/HarshithRajesh/PixelForge/internal/user"
-	"github.com/HarshithRajesh/PixelForge/storage"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	gin.SetMode(gin.DebugMode)
 
 	rds := config.NewRedis()
 
 	db, _ := config.ConnectDB()
+
+	signingCfg, err := config.LoadSigning()
+	if err != nil {
+		log.Fatalf("signing: %v", err)
+	}
+	enc, err := config.LoadEncryption()
+	if err != nil {
+		log.Fatalf("encryption: %v", err)
+	}
+	keySet := signing.NewKeySet(repository.NewSigningKeyRepository(db), signingCfg, enc)
+	if err := keySet.Rotate(context.Background()); err != nil {
+		log.Fatalf("signing keys: %v", err)
+	}
+	go keySet.Run(context.Background(), time.Minute)
+	middleware.UseKeySet(keySet)
+	jwksHandler := handler.NewJWKSHandler(keySet)
+
+	// Users who uploaded before usage was tracked get their usage counted
+	// from their images, not started at zero.
+	if n, err := repository.NewQuotaRepository(db).BackfillUsage(context.Background()); err != nil {
+		log.Fatalf("usage backfill: %v", err)
+	} else if n > 0 {
+		log.Printf("counted usage of %d users", n)
+	}
+
 	userRepo := repository.NewUserRepository(db)
-	userService := user.NewUserService(userRepo, rds)
+	mail, err := mailer.New(config.LoadMail())
+	if err != nil {
+		log.Fatalf("mail: %v", err)
+	}
+	authCfg, err := config.LoadAuth()
+	if err != nil {
+		log.Fatalf("auth: %v", err)
+	}
+	auditRepo := repository.NewAuditRepository(db)
+	userService := user.NewUserService(userRepo, rds, mail, authCfg, auditRepo)
 	userHandler := handler.NewUserHandler(userService, rds)
 
-	store := storage.NewStorageRepository("storage")
+	store, err := openStorage(context.Background(), db)
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
 	proc := processor.NewImageTransformation()
-	imageService := processor.NewImageManagement(userRepo, store, proc)
+	limits := config.LoadImageLimits()
+	scheduler := processor.NewScheduler(config.LoadProcessingLimits())
+	timeouts := config.LoadTimeouts()
+	imageService := processor.NewImageManagement(userRepo, store, proc, limits, scheduler, timeouts)
 	imageHandler := handler.NewImageManagementHandler(imageService)
+	templateRepo := repository.NewTemplateRepository(db)
+	templateService := processor.NewTemplateService(templateRepo, userRepo, store, limits, scheduler, timeouts)
+	templateHandler := handler.NewTemplateHandler(templateService)
+	apiKeyService := apikey.NewService(repository.NewAPIKeyRepository(db))
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+	roles := rbac.NewRoles(repository.NewRoleRepository(db))
+	adminService := admin.NewService(repository.NewAdminRepository(db), userRepo, roles, auditRepo, rds)
+	adminHandler := handler.NewAdminHandler(adminService)
 	r := gin.Default()
+	if err := r.SetTrustedProxies(config.LoadTrustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
 
 	// r.Use(cors.New(cors.Config{
 	// 	// AllowOrigins:     []string{os.Getenv("FRONTEND_ORIGIN")},
@@ -41,18 +106,70 @@ func main() {
 	r.GET("/health", processor.Health)
 	r.POST("/signup", userHandler.SignUp)
 	r.POST("/login", userHandler.Login)
+	r.POST("/login/mfa", userHandler.LoginMFA)
+	if oidcCfg := config.LoadOIDC(); oidcCfg.Enabled() {
+		provider, err := oidc.Discover(context.Background(), oidcCfg, &http.Client{Timeout: 10 * time.Second})
+		if err != nil {
+			log.Fatalf("oidc: %v", err)
+		}
+		oidcHandler := handler.NewOIDCHandler(provider, userService, rds)
+		r.GET("/auth/oidc/login", oidcHandler.Login)
+		r.GET("/auth/oidc/callback", oidcHandler.Callback)
+	}
+	r.POST("/auth/refresh", userHandler.Refresh)
+	r.POST("/auth/forgot-password", userHandler.ForgotPassword)
+	r.POST("/auth/reset-password", userHandler.ResetPassword)
+	r.POST("/auth/verify-email", userHandler.VerifyEmail)
+	r.POST("/auth/resend-verification", userHandler.ResendVerification)
+	r.GET("/.well-known/jwks.json", jwksHandler.JWKS)
 
 	protected := r.Group("/")
 	protected.Use(middleware.AuthMiddleware(rds))
 	{
 		protected.GET("/logout", userHandler.Logout)
+		protected.GET("/sessions", userHandler.ListSessions)
+		protected.DELETE("/sessions", userHandler.RevokeAllSessions)
+		protected.DELETE("/sessions/:id", userHandler.RevokeSession)
+		protected.POST("/mfa/totp", userHandler.EnrollTOTP)
+		protected.POST("/mfa/totp/confirm", userHandler.ConfirmTOTP)
+		protected.DELETE("/mfa/totp", userHandler.DisableTOTP)
+		protected.POST("/api_keys", apiKeyHandler.CreateAPIKey)
+		protected.GET("/api_keys", apiKeyHandler.ListAPIKeys)
+		protected.DELETE("/api_keys/:id", apiKeyHandler.RevokeAPIKey)
 		protected.GET("/profile", processor.Profile)
-		protected.POST("/upload_image", imageHandler.ImageUpload)
-		protected.GET("/images", imageHandler.ListImages)
-		protected.POST("/transform/:id", imageHandler.Transform)
+		protected.PUT("/metadata_policy", imageHandler.SetMetadataPolicy)
+		protected.POST("/templates", templateHandler.CreateTemplate)
+		protected.GET("/templates", templateHandler.ListTemplates)
+	}
+
+	// Admin routes need a login session whose role grants the permission.
+	// Only admins hand out roles, so a custom role cannot raise itself.
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(middleware.AuthMiddleware(rds))
+	{
+		adminRoutes.GET("/users", middleware.RequirePermission(roles, models.PermUsersRead), adminHandler.ListUsers)
+		adminRoutes.GET("/users/:id/images", middleware.RequirePermission(roles, models.PermImagesRead), adminHandler.UserImages)
+		adminRoutes.POST("/users/:id/suspend", middleware.RequirePermission(roles, models.PermUsersManage), adminHandler.SuspendUser)
+		adminRoutes.DELETE("/users/:id/suspend", middleware.RequirePermission(roles, models.PermUsersManage), adminHandler.UnsuspendUser)
+		adminRoutes.PUT("/users/:id/role", middleware.RequireRole(models.RoleAdmin), adminHandler.SetUserRole)
+		adminRoutes.GET("/processing/status", middleware.RequireRole(models.RoleAdmin), imageHandler.ProcessingStatus)
+	}
+
+	// Routes API keys may reach, each with the scope it needs. Every route
+	// that stores a new image is subject to the verification policy.
+	storesImages := middleware.RequireAllowed(userService.UploadAllowed)
+	scoped := r.Group("/")
+	scoped.Use(middleware.APIKeyMiddleware(rds, apiKeyService))
+	{
+		scoped.POST("/upload_image", middleware.RequireScope(models.ScopeImagesWrite), storesImages, imageHandler.ImageUpload)
+		scoped.GET("/images", middleware.RequireScope(models.ScopeImagesRead), imageHandler.ListImages)
+		scoped.DELETE("/images/:id", middleware.RequireScope(models.ScopeImagesWrite), imageHandler.DeleteImage)
+		scoped.GET("/usage", middleware.RequireScope(models.ScopeImagesRead), imageHandler.Usage)
+		scoped.POST("/transform/:id", middleware.RequireScope(models.ScopeTransform), storesImages, imageHandler.Transform)
+		scoped.POST("/templates/:id/render", middleware.RequireScope(models.ScopeTransform), storesImages, templateHandler.RenderTemplate)
 	}
 
-	err := r.Run()
+	err = r.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
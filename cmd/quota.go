@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+const quotaUsage = `usage:
+  pixelforge quota plans
+  pixelforge quota plan NAME [-max-bytes SIZE] [-max-images N] [-delete]
+  pixelforge quota user ID
+  pixelforge quota user ID -set [-plan NAME] [-max-bytes SIZE] [-max-images N]
+  pixelforge quota recompute
+
+A limit of 0 means unlimited. SIZE takes a unit: 500MB, 10GiB.
+Users without a plan get the "default" plan. "quota user ID -set" replaces
+the user's settings; limits left out come from the plan.
+`
+
+func quotaCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, quotaUsage)
+		return 2
+	}
+	db, err := config.ConnectDB()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	repo := repository.NewQuotaRepository(db)
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "plans" && len(args) == 1:
+		plans, err := repo.ListPlans(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		for _, p := range plans {
+			fmt.Fprintf(stdout, "%-16s %s\n", p.Name, formatQuota(p.Quota))
+		}
+		return 0
+	case args[0] == "plan" && len(args) >= 2:
+		return quotaPlan(ctx, repo, args[1], args[2:], stdout, stderr)
+	case args[0] == "user" && len(args) >= 2:
+		uid, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid user ID %q\n", args[1])
+			return 2
+		}
+		return quotaUser(ctx, repo, uint(uid), args[2:], stdout, stderr)
+	case args[0] == "recompute" && len(args) == 1:
+		n, err := repo.RecomputeUsage(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "recomputed usage of %d users\n", n)
+		return 0
+	}
+	fmt.Fprint(stderr, quotaUsage)
+	return 2
+}
+
+func quotaPlan(ctx context.Context, repo repository.QuotaRepository, name string, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("quota plan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	maxBytes := fs.String("max-bytes", "0", "total bytes a user on the plan may store")
+	maxImages := fs.Int64("max-images", 0, "number of images a user on the plan may store")
+	del := fs.Bool("delete", false, "delete the plan")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *del {
+		if err := repo.DeletePlan(ctx, name); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "deleted plan %s\n", name)
+		return 0
+	}
+	bytes, err := parseSize(*maxBytes)
+	if err != nil || *maxImages < 0 {
+		fmt.Fprintln(stderr, "limits must be sizes and counts of 0 or more")
+		return 2
+	}
+	plan := &models.Plan{Name: name, Quota: models.Quota{MaxBytes: bytes, MaxImages: *maxImages}}
+	if err := repo.SavePlan(ctx, plan); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%-16s %s\n", plan.Name, formatQuota(plan.Quota))
+	return 0
+}
+
+func quotaUser(ctx context.Context, repo repository.QuotaRepository, userID uint, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("quota user", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	set := fs.Bool("set", false, "replace the user's plan and overrides")
+	plan := fs.String("plan", "", "plan to assign; empty for the default plan")
+	maxBytes := fs.String("max-bytes", "", "override the plan's byte limit")
+	maxImages := fs.String("max-images", "", "override the plan's image limit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *set {
+		var bytesOverride, imagesOverride *int64
+		if *maxBytes != "" {
+			n, err := parseSize(*maxBytes)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 2
+			}
+			bytesOverride = &n
+		}
+		if *maxImages != "" {
+			n, err := strconv.ParseInt(*maxImages, 10, 64)
+			if err != nil || n < 0 {
+				fmt.Fprintf(stderr, "invalid image limit %q\n", *maxImages)
+				return 2
+			}
+			imagesOverride = &n
+		}
+		if err := repo.SetUserQuota(ctx, userID, *plan, bytesOverride, imagesOverride); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	report, err := repo.GetUsage(ctx, userID)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "user %d on plan %s: %s\n", userID, report.Plan, formatQuota(report.Quota))
+	fmt.Fprintf(stdout, "using %s in %d images\n", formatSize(report.Bytes), report.Images)
+	return 0
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize reads a byte count such as "1048576", "500MB" or "10GiB".
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSpace(size)
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+func formatSize(n int64) string {
+	for i := 3; i >= 0; i-- {
+		if u := sizeUnits[i]; n >= u.factor {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.factor), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func formatQuota(q models.Quota) string {
+	bytes, images := "unlimited", "unlimited"
+	if q.MaxBytes > 0 {
+		bytes = formatSize(q.MaxBytes)
+	}
+	if q.MaxImages > 0 {
+		images = strconv.FormatInt(q.MaxImages, 10)
+	}
+	return fmt.Sprintf("max bytes %s, max images %s", bytes, images)
+}
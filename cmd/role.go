@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/HarshithRajesh/PixelForge/internal/admin"
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+const roleUsage = `usage:
+  pixelforge role list
+  pixelforge role set NAME -permissions PERM,...
+  pixelforge role delete NAME
+  pixelforge role grant USER_ID ROLE
+
+The built-in roles are "user", which every account starts with, and
+"admin", which has every permission. Custom roles grant some of:
+  users:read       list users
+  users:manage     suspend and unsuspend users
+  images:read_any  view any user's images
+Only admins change roles over the API; use "role grant" for the first one.
+`
+
+func roleCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, roleUsage)
+		return 2
+	}
+	db, err := config.ConnectDB()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	roles := rbac.NewRoles(repository.NewRoleRepository(db))
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		list, err := roles.List(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		for _, r := range list {
+			fmt.Fprintf(stdout, "%-16s %s\n", r.Name, strings.Join(r.Permissions, ","))
+		}
+		return 0
+	case args[0] == "set" && len(args) >= 2:
+		fs := flag.NewFlagSet("role set", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		perms := fs.String("permissions", "", "comma-separated permissions the role grants")
+		if err := fs.Parse(args[2:]); err != nil {
+			return 2
+		}
+		role := &models.Role{Name: args[1], Permissions: []string{}}
+		for _, p := range strings.Split(*perms, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				role.Permissions = append(role.Permissions, p)
+			}
+		}
+		if err := roles.Save(ctx, role); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "%-16s %s\n", role.Name, strings.Join(role.Permissions, ","))
+		return 0
+	case args[0] == "delete" && len(args) == 2:
+		if err := roles.Delete(ctx, args[1]); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "deleted role %s; its users are back to %s\n", args[1], models.RoleUser)
+		return 0
+	case args[0] == "grant" && len(args) == 3:
+		uid, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid user ID %q\n", args[1])
+			return 2
+		}
+		svc := admin.NewService(repository.NewAdminRepository(db), repository.NewUserRepository(db), roles, repository.NewAuditRepository(db), config.NewRedis())
+		if err := svc.SetRole(ctx, admin.Actor{}, uint(uid), args[2]); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "user %d is now %s; they must log in again\n", uid, args[2])
+		return 0
+	}
+	fmt.Fprint(stderr, roleUsage)
+	return 2
+}
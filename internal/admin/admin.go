@@ -0,0 +1,213 @@
+// Package admin is what admins can do to other accounts. Every action is
+// written to the audit log, and one whose entry cannot be written fails.
+// Changes are recorded before they are made, so none goes unrecorded.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+// MaxPageSize caps how many users ListUsers returns at once.
+const MaxPageSize = 100
+
+// ErrSelf keeps an admin from locking themselves out by accident.
+var ErrSelf = errors.New("you cannot suspend yourself or change your own role")
+
+// ErrOutranked keeps a role from acting on users whose role grants more.
+var ErrOutranked = errors.New("the user's role has permissions yours does not")
+
+// Actor is the admin making a request. The zero Actor is the command line,
+// which may act on anyone.
+type Actor struct {
+	UserID string
+	Role   string
+	IP     string
+}
+
+type Service interface {
+	ListUsers(ctx context.Context, actor Actor, offset, limit int) ([]*models.AdminUser, int64, error)
+	UserImages(ctx context.Context, actor Actor, userID uint) ([]*models.Image, error)
+	// Suspend locks the user out at once: their sessions end and their API
+	// keys stop working until Unsuspend.
+	Suspend(ctx context.Context, actor Actor, userID uint) error
+	Unsuspend(ctx context.Context, actor Actor, userID uint) error
+	// SetRole changes the user's role. Tokens carry the role, so the
+	// user's sessions end and they pick it up when they log in again.
+	SetRole(ctx context.Context, actor Actor, userID uint, role string) error
+}
+
+type service struct {
+	repo  repository.AdminRepository
+	users repository.UserRepository
+	roles rbac.Roles
+	audit repository.AuditRepository
+	rds   *config.Redis
+	now   func() time.Time
+}
+
+func NewService(repo repository.AdminRepository, users repository.UserRepository, roles rbac.Roles, audit repository.AuditRepository, rds *config.Redis) Service {
+	return &service{repo: repo, users: users, roles: roles, audit: audit, rds: rds, now: time.Now}
+}
+
+func (s *service) ListUsers(ctx context.Context, actor Actor, offset, limit int) ([]*models.AdminUser, int64, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	users, total, err := s.repo.ListUsers(ctx, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.record(ctx, actor, models.AuditAdminListUsers, "users", fmt.Sprintf("offset %d limit %d", offset, limit)); err != nil {
+		return nil, 0, err
+	}
+	list := make([]*models.AdminUser, len(users))
+	for n, u := range users {
+		list[n] = adminUser(u)
+	}
+	return list, total, nil
+}
+
+func (s *service) UserImages(ctx context.Context, actor Actor, userID uint) ([]*models.Image, error) {
+	if _, err := s.repo.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	images, err := s.users.GetAllImageData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.record(ctx, actor, models.AuditAdminViewImages, target(userID), ""); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (s *service) Suspend(ctx context.Context, actor Actor, userID uint) error {
+	if target(userID) == "user:"+actor.UserID {
+		return ErrSelf
+	}
+	if err := s.checkRank(ctx, actor, userID); err != nil {
+		return err
+	}
+	if err := s.record(ctx, actor, models.AuditAdminSuspend, target(userID), ""); err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	if err := s.repo.SetUserSuspended(ctx, userID, &now); err != nil {
+		return err
+	}
+	_, err := s.rds.RevokeAllSessions(ctx, strconv.FormatUint(uint64(userID), 10))
+	return err
+}
+
+func (s *service) Unsuspend(ctx context.Context, actor Actor, userID uint) error {
+	if err := s.checkRank(ctx, actor, userID); err != nil {
+		return err
+	}
+	if err := s.record(ctx, actor, models.AuditAdminUnsuspend, target(userID), ""); err != nil {
+		return err
+	}
+	return s.repo.SetUserSuspended(ctx, userID, nil)
+}
+
+func (s *service) SetRole(ctx context.Context, actor Actor, userID uint, role string) error {
+	if target(userID) == "user:"+actor.UserID {
+		return ErrSelf
+	}
+	if err := s.roles.Check(ctx, role); err != nil {
+		return err
+	}
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("%s -> %s", roleOf(user.Role), roleOf(role))
+	if role == models.RoleUser {
+		role = ""
+	}
+	if err := s.record(ctx, actor, models.AuditAdminSetRole, target(userID), detail); err != nil {
+		return err
+	}
+	if err := s.repo.SetUserRole(ctx, userID, role); err != nil {
+		return err
+	}
+	_, err = s.rds.RevokeAllSessions(ctx, strconv.FormatUint(uint64(userID), 10))
+	return err
+}
+
+// checkRank returns ErrOutranked if the user's role grants a permission the
+// actor's does not, so that a custom role with users:manage cannot lock
+// admins out.
+func (s *service) checkRank(ctx context.Context, actor Actor, userID uint) error {
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if actor.UserID == "" {
+		return nil
+	}
+	for _, perm := range models.Permissions {
+		theirs, err := s.roles.Can(ctx, user.Role, perm)
+		if err != nil {
+			return err
+		}
+		if !theirs {
+			continue
+		}
+		ours, err := s.roles.Can(ctx, actor.Role, perm)
+		if err != nil {
+			return err
+		}
+		if !ours {
+			return ErrOutranked
+		}
+	}
+	return nil
+}
+
+func (s *service) record(ctx context.Context, actor Actor, action, target, detail string) error {
+	entry := &models.AuditLog{Action: action, Target: target, IP: actor.IP, Detail: detail}
+	if uid, err := strconv.ParseUint(actor.UserID, 10, 64); err == nil {
+		id := uint(uid)
+		entry.UserID = &id
+	}
+	if err := s.audit.Record(ctx, entry); err != nil {
+		return fmt.Errorf("recording %s: %w", action, err)
+	}
+	return nil
+}
+
+func target(userID uint) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func roleOf(role string) string {
+	if role == "" {
+		return models.RoleUser
+	}
+	return role
+}
+
+func adminUser(u *models.User) *models.AdminUser {
+	return &models.AdminUser{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		Role:          roleOf(u.Role),
+		Plan:          u.Plan,
+		EmailVerified: u.EmailVerifiedAt != nil,
+		TOTPEnabled:   u.TOTPEnabledAt != nil,
+		SuspendedAt:   u.SuspendedAt,
+	}
+}
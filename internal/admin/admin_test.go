@@ -0,0 +1,211 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/admin"
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryUsers is an AdminRepository over a map.
+type memoryUsers struct {
+	users map[uint]*models.User
+}
+
+func (m *memoryUsers) ListUsers(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
+	var list []*models.User
+	for id := uint(1); id <= uint(len(m.users)); id++ {
+		list = append(list, m.users[id])
+	}
+	list = list[min(offset, len(list)):]
+	return list[:min(limit, len(list))], int64(len(m.users)), nil
+}
+
+func (m *memoryUsers) GetUser(ctx context.Context, userID uint) (*models.User, error) {
+	if u, ok := m.users[userID]; ok {
+		return u, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memoryUsers) SetUserRole(ctx context.Context, userID uint, role string) error {
+	u, err := m.GetUser(ctx, userID)
+	if err == nil {
+		u.Role = role
+	}
+	return err
+}
+
+func (m *memoryUsers) SetUserSuspended(ctx context.Context, userID uint, at *time.Time) error {
+	u, err := m.GetUser(ctx, userID)
+	if err == nil {
+		u.SuspendedAt = at
+	}
+	return err
+}
+
+// memoryImages is the UserRepository the service reads images from; only
+// GetAllImageData is implemented.
+type memoryImages struct {
+	repository.UserRepository
+	images map[uint][]*models.Image
+}
+
+func (m *memoryImages) GetAllImageData(ctx context.Context, userID uint) ([]*models.Image, error) {
+	return m.images[userID], nil
+}
+
+// moderatorRole is a RoleRepository with one custom role that may manage
+// users but has nothing else admins have.
+type moderatorRole struct{ repository.RoleRepository }
+
+func (moderatorRole) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	if name == "moderator" {
+		return &models.Role{Name: name, Permissions: []string{models.PermUsersRead, models.PermUsersManage}}, nil
+	}
+	return nil, nil
+}
+
+// memoryAudit is an AuditRepository over a slice that fails while err is
+// set.
+type memoryAudit struct {
+	entries []*models.AuditLog
+	err     error
+}
+
+func (m *memoryAudit) Record(ctx context.Context, entry *models.AuditLog) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func setup(t *testing.T) (admin.Service, *memoryUsers, *memoryAudit, *config.Redis) {
+	mr := miniredis.RunT(t)
+	rds := &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	users := &memoryUsers{
+		users: map[uint]*models.User{
+			1: {ID: 1, Name: "Root", Email: "root@example.com", Role: models.RoleAdmin},
+			2: {ID: 2, Name: "Alice", Email: "alice@example.com", Password: "hash"},
+			3: {ID: 3, Name: "Bob", Email: "bob@example.com"},
+			4: {ID: 4, Name: "Mod", Email: "mod@example.com", Role: "moderator"},
+		},
+	}
+	images := &memoryImages{images: map[uint][]*models.Image{2: {{UserID: 2}}}}
+	audit := &memoryAudit{}
+	return admin.NewService(users, images, rbac.NewRoles(moderatorRole{}), audit, rds), users, audit, rds
+}
+
+var (
+	root      = admin.Actor{UserID: "1", Role: models.RoleAdmin, IP: "192.0.2.1"}
+	moderator = admin.Actor{UserID: "4", Role: "moderator", IP: "192.0.2.4"}
+)
+
+func TestListUsers(t *testing.T) {
+	svc, _, audit, _ := setup(t)
+
+	page, total, err := svc.ListUsers(context.Background(), root, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	require.Len(t, page, 1)
+	assert.Equal(t, "alice@example.com", page[0].Email)
+	assert.Equal(t, models.RoleUser, page[0].Role)
+
+	require.Len(t, audit.entries, 1)
+	assert.Equal(t, models.AuditAdminListUsers, audit.entries[0].Action)
+	assert.Equal(t, uint(1), *audit.entries[0].UserID)
+	assert.Equal(t, "192.0.2.1", audit.entries[0].IP)
+
+	audit.err = errors.New("database is down")
+	_, _, err = svc.ListUsers(context.Background(), root, 0, 10)
+	assert.Error(t, err, "nothing is shown without an audit entry")
+}
+
+func TestUserImages(t *testing.T) {
+	svc, _, audit, _ := setup(t)
+
+	images, err := svc.UserImages(context.Background(), root, 2)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	assert.Equal(t, "user:2", audit.entries[0].Target)
+
+	_, err = svc.UserImages(context.Background(), root, 9)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func TestSuspend(t *testing.T) {
+	ctx := context.Background()
+	svc, users, audit, rds := setup(t)
+	require.NoError(t, rds.SaveSession(ctx, &models.Session{ID: "fam", UserID: "2"}, time.Now().Add(time.Hour)))
+
+	require.NoError(t, svc.Suspend(ctx, root, 2))
+	assert.NotNil(t, users.users[2].SuspendedAt)
+	sessions, err := rds.ListSessions(ctx, "2")
+	require.NoError(t, err)
+	assert.Empty(t, sessions, "suspension ends every session")
+	assert.Equal(t, models.AuditAdminSuspend, audit.entries[0].Action)
+
+	require.NoError(t, svc.Unsuspend(ctx, root, 2))
+	assert.Nil(t, users.users[2].SuspendedAt)
+
+	assert.ErrorIs(t, svc.Suspend(ctx, root, 1), admin.ErrSelf)
+	assert.ErrorIs(t, svc.Suspend(ctx, root, 9), repository.ErrUserNotFound)
+	assert.Len(t, audit.entries, 2, "failed actions are not recorded")
+
+	audit.err = errors.New("database is down")
+	assert.Error(t, svc.Suspend(ctx, root, 3))
+	assert.Nil(t, users.users[3].SuspendedAt, "nothing changes without an audit entry")
+}
+
+func TestSuspendOutranked(t *testing.T) {
+	ctx := context.Background()
+	svc, users, audit, _ := setup(t)
+
+	assert.ErrorIs(t, svc.Suspend(ctx, moderator, 1), admin.ErrOutranked)
+	assert.Nil(t, users.users[1].SuspendedAt)
+	assert.Empty(t, audit.entries)
+
+	require.NoError(t, svc.Suspend(ctx, moderator, 2), "a moderator may suspend plain users")
+	require.NoError(t, svc.Suspend(ctx, admin.Actor{}, 1), "the command line may suspend anyone")
+	assert.ErrorIs(t, svc.Unsuspend(ctx, moderator, 1), admin.ErrOutranked)
+	assert.NotNil(t, users.users[1].SuspendedAt)
+}
+
+func TestSetRole(t *testing.T) {
+	ctx := context.Background()
+	svc, users, audit, rds := setup(t)
+	require.NoError(t, rds.SaveSession(ctx, &models.Session{ID: "fam", UserID: "3"}, time.Now().Add(time.Hour)))
+
+	require.NoError(t, svc.SetRole(ctx, root, 3, models.RoleAdmin))
+	assert.Equal(t, models.RoleAdmin, users.users[3].Role)
+	sessions, err := rds.ListSessions(ctx, "3")
+	require.NoError(t, err)
+	assert.Empty(t, sessions, "tokens with the old role are revoked")
+	assert.Equal(t, "user -> admin", audit.entries[0].Detail)
+
+	require.NoError(t, svc.SetRole(ctx, root, 3, models.RoleUser))
+	assert.Empty(t, users.users[3].Role, "the default role is stored empty")
+
+	assert.ErrorIs(t, svc.SetRole(ctx, root, 3, "wizard"), rbac.ErrUnknownRole)
+	assert.ErrorIs(t, svc.SetRole(ctx, root, 1, models.RoleUser), admin.ErrSelf)
+
+	audit.err = errors.New("database is down")
+	assert.Error(t, svc.SetRole(ctx, root, 3, models.RoleAdmin))
+	assert.Empty(t, users.users[3].Role, "nothing changes without an audit entry")
+	audit.err = nil
+
+	require.NoError(t, svc.SetRole(ctx, admin.Actor{}, 2, models.RoleAdmin))
+	last := audit.entries[len(audit.entries)-1]
+	assert.Nil(t, last.UserID, "the command line has no user")
+}
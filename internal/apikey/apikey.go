@@ -0,0 +1,212 @@
+// Package apikey issues and checks the API keys scripts and CI use in place
+// of a login.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+// Keys look like "pf_<prefix>_<secret>".
+const keyPrefix = "pf_"
+
+var (
+	// ErrInvalidKey covers malformed, unknown, expired and revoked keys
+	// alike, so a caller cannot probe which prefixes exist.
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrIPNotAllowed means the key is valid but not from this address.
+	ErrIPNotAllowed = errors.New("API key is not allowed from this address")
+	// ErrKeyNotFound means the user has no key with that ID.
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// IsKey reports whether s has the shape of an API key rather than a JWT.
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, keyPrefix)
+}
+
+type Service interface {
+	// Create stores a new key and returns it with its secret, which is not
+	// retrievable afterwards.
+	Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error)
+	List(ctx context.Context, userID string) ([]*models.APIKey, error)
+	Revoke(ctx context.Context, userID string, keyID uint) error
+	// Authenticate checks a key presented from ip.
+	Authenticate(ctx context.Context, key, ip string) (*models.APIKey, error)
+}
+
+type service struct {
+	repo repository.APIKeyRepository
+	now  func() time.Time
+}
+
+func NewService(repo repository.APIKeyRepository) Service {
+	return &service{repo: repo, now: time.Now}
+}
+
+func (s *service) Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required, one of %s", strings.Join(models.Scopes, ", "))
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return nil, "", fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(models.Scopes, ", "))
+		}
+	}
+	allowed := make([]string, 0, len(req.AllowedIPs))
+	for _, entry := range req.AllowedIPs {
+		network, err := parseAllowed(entry)
+		if err != nil {
+			return nil, "", err
+		}
+		allowed = append(allowed, network.String())
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(s.now()) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+
+	prefix, err := randomHex(4)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := &models.APIKey{
+		UserID:     uint(uid),
+		Name:       strings.TrimSpace(req.Name),
+		Prefix:     prefix,
+		Hash:       hash(secret),
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		AllowedIPs: allowed,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, keyPrefix + prefix + "_" + secret, nil
+}
+
+func (s *service) List(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListAPIKeys(ctx, uint(uid))
+}
+
+func (s *service) Revoke(ctx context.Context, userID string, keyID uint) error {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+	ok, err := s.repo.DeleteAPIKey(ctx, uint(uid), keyID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *service) Authenticate(ctx context.Context, raw, ip string) (*models.APIKey, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(raw, keyPrefix), "_")
+	if !ok || !IsKey(raw) {
+		return nil, ErrInvalidKey
+	}
+	key, err := s.repo.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := s.now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrInvalidKey
+	}
+	if !allowedFrom(key.AllowedIPs, ip) {
+		return nil, ErrIPNotAllowed
+	}
+	// Recording every request would turn reads into writes; a minute's
+	// precision is plenty for spotting unused keys.
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		if err := s.repo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+func allowedFrom(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if network, err := parseAllowed(entry); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowed reads an allow-list entry: a CIDR range, or a single address.
+func parseAllowed(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR range %q", entry)
+	}
+	return network, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,126 @@
+package apikey_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/apikey"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeys is an APIKeyRepository over a map.
+type memoryKeys struct {
+	keys    map[uint]*models.APIKey
+	touches int
+}
+
+func (m *memoryKeys) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	key.ID = uint(len(m.keys) + 1)
+	m.keys[key.ID] = key
+	return nil
+}
+
+func (m *memoryKeys) ListAPIKeys(ctx context.Context, userID uint) ([]*models.APIKey, error) {
+	var out []*models.APIKey
+	for id := uint(1); id <= uint(len(m.keys)); id++ {
+		if key, ok := m.keys[id]; ok && key.UserID == userID {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryKeys) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	for _, key := range m.keys {
+		if key.Prefix == prefix {
+			copy := *key
+			return &copy, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryKeys) DeleteAPIKey(ctx context.Context, userID, keyID uint) (bool, error) {
+	key, ok := m.keys[keyID]
+	if !ok || key.UserID != userID {
+		return false, nil
+	}
+	delete(m.keys, keyID)
+	return true, nil
+}
+
+func (m *memoryKeys) TouchAPIKey(ctx context.Context, keyID uint, at time.Time) error {
+	m.touches++
+	m.keys[keyID].LastUsedAt = &at
+	return nil
+}
+
+func TestCreateValidates(t *testing.T) {
+	svc := apikey.NewService(&memoryKeys{keys: map[uint]*models.APIKey{}})
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		req  models.CreateAPIKeyRequest
+		want string
+	}{
+		{"no name", models.CreateAPIKeyRequest{Scopes: []string{models.ScopeTransform}}, "name is required"},
+		{"no scopes", models.CreateAPIKeyRequest{Name: "ci"}, "at least one scope"},
+		{"unknown scope", models.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"admin"}}, `unknown scope "admin"`},
+		{"bad address", models.CreateAPIKeyRequest{Name: "ci", Scopes: []string{models.ScopeTransform}, AllowedIPs: []string{"nope"}}, `invalid IP address "nope"`},
+		{"bad range", models.CreateAPIKeyRequest{Name: "ci", Scopes: []string{models.ScopeTransform}, AllowedIPs: []string{"10.0.0.0/99"}}, "invalid CIDR range"},
+		{"expired", models.CreateAPIKeyRequest{Name: "ci", Scopes: []string{models.ScopeTransform}, ExpiresAt: &past}, "expires_at must be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := svc.Create(context.Background(), "1", &tt.req)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryKeys{keys: map[uint]*models.APIKey{}}
+	svc := apikey.NewService(repo)
+
+	key, secret, err := svc.Create(ctx, "7", &models.CreateAPIKeyRequest{
+		Name:       "ci",
+		Scopes:     []string{models.ScopeTransform, models.ScopeImagesRead, models.ScopeTransform},
+		AllowedIPs: []string{"10.1.0.0/16", "192.0.2.7"},
+	})
+	require.NoError(t, err)
+	assert.True(t, apikey.IsKey(secret))
+	assert.Contains(t, secret, key.Prefix)
+	assert.NotContains(t, key.Hash, secret[len(secret)-16:], "only the hash is stored")
+	assert.Equal(t, []string{models.ScopeImagesRead, models.ScopeTransform}, key.Scopes)
+	assert.Equal(t, []string{"10.1.0.0/16", "192.0.2.7/32"}, key.AllowedIPs)
+
+	got, err := svc.Authenticate(ctx, secret, "10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), got.UserID)
+	_, err = svc.Authenticate(ctx, secret, "192.0.2.7")
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.touches, "last use is recorded at most once a minute")
+
+	_, err = svc.Authenticate(ctx, secret, "10.2.0.1")
+	assert.ErrorIs(t, err, apikey.ErrIPNotAllowed)
+	_, err = svc.Authenticate(ctx, secret[:len(secret)-1]+"x", "10.1.2.3")
+	assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	_, err = svc.Authenticate(ctx, "pf_nothing", "10.1.2.3")
+	assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+
+	expired := time.Now().Add(-time.Second)
+	repo.keys[key.ID].ExpiresAt = &expired
+	_, err = svc.Authenticate(ctx, secret, "10.1.2.3")
+	assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+
+	assert.ErrorIs(t, svc.Revoke(ctx, "8", key.ID), apikey.ErrKeyNotFound, "only the owner can revoke")
+	require.NoError(t, svc.Revoke(ctx, "7", key.ID))
+	_, err = svc.Authenticate(ctx, secret, "10.1.2.3")
+	assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+}
@@ -16,6 +16,8 @@ import (
 
 type Redis struct {
 	Client *redis.Client
+	// Timeout bounds every call, so a stalled Redis cannot hang a request.
+	Timeout time.Duration
 }
 
 func init() {
@@ -53,18 +55,90 @@ func NewRedis() *Redis {
 		addr = "localhost:6379"
 	}
 
-	rdb := redis.NewClient(&redis.Options{Addr: addr})
-	return &Redis{Client: rdb}
+	timeout := LoadTimeouts().Redis
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	})
+	return &Redis{Client: rdb, Timeout: timeout}
 }
 
 func (r *Redis) SetJTI(ctx context.Context, key, userID string, exp time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	return r.Client.Set(ctx, key, userID, time.Until(exp)).Err()
 }
 
 func (r *Redis) DelJTI(ctx context.Context, key string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	return r.Client.Del(ctx, key).Err()
 }
 
 func (r *Redis) GetUserByJTI(ctx context.Context, key string) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	return r.Client.Get(ctx, key).Result()
 }
+
+// rotateScript renames KEYS[1] to KEYS[2] if KEYS[1] exists, in one step.
+var rotateScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("RENAME", KEYS[1], KEYS[2])
+return 1
+`)
+
+// RotateJTI atomically renames key to rotatedKey, keeping its expiry. It
+// reports false if key does not exist, so of two concurrent rotations of
+// the same token only one succeeds.
+func (r *Redis) RotateJTI(ctx context.Context, key, rotatedKey string) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	n, err := rotateScript.Run(ctx, r.Client, []string{key, rotatedKey}).Int()
+	return n == 1, err
+}
+
+func (r *Redis) ExistsJTI(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	n, err := r.Client.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+// AddToFamily records the token keys issued to one login, so they can be
+// revoked together. The family lives as long as its newest token.
+func (r *Redis) AddToFamily(ctx context.Context, family string, exp time.Time, keys ...string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	members := make([]interface{}, len(keys))
+	for n, key := range keys {
+		members[n] = key
+	}
+	pipe := r.Client.TxPipeline()
+	pipe.SAdd(ctx, "family:"+family, members...)
+	pipe.ExpireAt(ctx, "family:"+family, exp)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeFamily deletes every token key issued to the family.
+func (r *Redis) RevokeFamily(ctx context.Context, family string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	keys, err := r.Client.SMembers(ctx, "family:"+family).Result()
+	if err != nil {
+		return err
+	}
+	return r.Client.Del(ctx, append(keys, "family:"+family)...).Err()
+}
+
+func (r *Redis) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.Timeout)
+}
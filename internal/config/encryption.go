@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encryption holds the master keys that wrap per-user data keys. New data
+// keys are wrapped with ActiveKeyID; the others stay so keys wrapped before
+// a rotation can still be unwrapped until they are re-wrapped.
+type Encryption struct {
+	MasterKeys  map[string][]byte
+	ActiveKeyID string
+}
+
+// Enabled reports whether stored files should be encrypted.
+func (e Encryption) Enabled() bool {
+	return len(e.MasterKeys) > 0
+}
+
+// LoadEncryption reads ENCRYPTION_MASTER_KEYS as comma-separated
+// "id:base64key" pairs of 32-byte AES keys, and ENCRYPTION_ACTIVE_KEY, which
+// defaults to the only key when there is just one.
+func LoadEncryption() (Encryption, error) {
+	enc := Encryption{MasterKeys: map[string][]byte{}, ActiveKeyID: os.Getenv("ENCRYPTION_ACTIVE_KEY")}
+	raw := os.Getenv("ENCRYPTION_MASTER_KEYS")
+	if raw == "" {
+		return enc, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		id, b64, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return Encryption{}, fmt.Errorf("ENCRYPTION_MASTER_KEYS: expected id:base64key, got %q", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil || len(key) != 32 {
+			return Encryption{}, fmt.Errorf("ENCRYPTION_MASTER_KEYS: key %q must be 32 bytes of base64", id)
+		}
+		enc.MasterKeys[id] = key
+	}
+	if enc.ActiveKeyID == "" && len(enc.MasterKeys) == 1 {
+		for id := range enc.MasterKeys {
+			enc.ActiveKeyID = id
+		}
+	}
+	if _, ok := enc.MasterKeys[enc.ActiveKeyID]; !ok {
+		return Encryption{}, fmt.Errorf("ENCRYPTION_ACTIVE_KEY %q is not one of ENCRYPTION_MASTER_KEYS", enc.ActiveKeyID)
+	}
+	return enc, nil
+}
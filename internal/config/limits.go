@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// ImageLimits bounds the dimensions of images PixelForge will decode. They
+// are checked against the image header before any pixels are allocated.
+type ImageLimits struct {
+	MaxWidth      int
+	MaxHeight     int
+	MaxMegapixels float64
+}
+
+func LoadImageLimits() ImageLimits {
+	return ImageLimits{
+		MaxWidth:      envInt("MAX_IMAGE_WIDTH", 10000),
+		MaxHeight:     envInt("MAX_IMAGE_HEIGHT", 10000),
+		MaxMegapixels: envFloat("MAX_IMAGE_MEGAPIXELS", 50),
+	}
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// ProcessingLimits bounds how much image work runs at once across the process.
+type ProcessingLimits struct {
+	MaxJobs      int
+	MemoryBudget int64 // bytes
+	MaxQueue     int
+	QueueTimeout time.Duration
+}
+
+func LoadProcessingLimits() ProcessingLimits {
+	return ProcessingLimits{
+		MaxJobs:      envInt("PROCESSING_MAX_JOBS", runtime.NumCPU()),
+		MemoryBudget: int64(envInt("PROCESSING_MEMORY_MB", 1024)) << 20,
+		MaxQueue:     envInt("PROCESSING_MAX_QUEUE", 32),
+		QueueTimeout: time.Duration(envInt("PROCESSING_QUEUE_TIMEOUT_SECONDS", 30)) * time.Second,
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed logins are counted in the hash "login_fail:<key>", holding the
+// count and the time of the last failure, which expires a window after the
+// first. A locked key has "login_lock:<key>" until the lockout ends.
+
+// AttemptLimit is how many failed logins a key may have within Window, and
+// after how many of them each further attempt has to wait: a second, then
+// two, four and so on up to MaxDelay. A negative DelayAfter never delays.
+type AttemptLimit struct {
+	Limit      int
+	Window     time.Duration
+	DelayAfter int
+	MaxDelay   time.Duration
+}
+
+// LoginAttempt is an attempt BeginLoginAttempt let through. It is already
+// counted as a failure: FailLoginAttempt dates the failure, and
+// EndLoginAttempt takes it back if the attempt succeeds.
+type LoginAttempt struct {
+	Key string
+	// Count is the failures of Key including this attempt, out of Limit.
+	Count int
+	Limit int
+	at    int64
+	prev  int64
+}
+
+// attemptScript lets an attempt on KEYS[1] through at ARGV[1] (unix
+// milliseconds) unless KEYS[2] locks it, ARGV[3] failures have been
+// counted, or the delay after the last one has not passed; see
+// AttemptLimit. It returns the new count, the time to wait when refused,
+// and the time of the previous failure.
+var attemptScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[2])
+if ttl > 0 then
+	return {0, ttl, 0}
+end
+local now = tonumber(ARGV[1])
+local count = tonumber(redis.call("HGET", KEYS[1], "count") or "0")
+local last = tonumber(redis.call("HGET", KEYS[1], "last") or "0")
+if count >= tonumber(ARGV[3]) then
+	return {0, 1000, 0}
+end
+local after = tonumber(ARGV[4])
+if after >= 0 and count > after then
+	local delay = math.min(1000 * 2 ^ (count - after - 1), tonumber(ARGV[5]))
+	local wait = delay - (now - last)
+	if wait > 0 then
+		return {0, wait, 0}
+	end
+end
+local n = redis.call("HINCRBY", KEYS[1], "count", 1)
+redis.call("HSET", KEYS[1], "last", ARGV[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return {n, 0, last}
+`)
+
+// BeginLoginAttempt counts an attempt on key as a failure before its
+// password or code is checked, in the same step that checks the lockout
+// and the delay, so parallel attempts cannot all slip through. If the
+// attempt has to wait, it returns how long instead.
+func (r *Redis) BeginLoginAttempt(ctx context.Context, key string, now time.Time, limit AttemptLimit) (*LoginAttempt, time.Duration, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	res, err := attemptScript.Run(ctx, r.Client, []string{"login_fail:" + key, "login_lock:" + key},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Limit, limit.DelayAfter, limit.MaxDelay.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, 0, err
+	}
+	if res[0] == 0 {
+		return nil, time.Duration(res[1]) * time.Millisecond, nil
+	}
+	return &LoginAttempt{Key: key, Count: int(res[0]), Limit: limit.Limit, at: now.UnixMilli(), prev: res[2]}, 0, nil
+}
+
+// failScript moves the last failure of KEYS[1] from ARGV[1] to ARGV[2],
+// unless a later attempt has already moved it on.
+var failScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "last") == ARGV[1] then
+	redis.call("HSET", KEYS[1], "last", ARGV[2])
+end
+return 0
+`)
+
+// FailLoginAttempt dates the failure of a to now, when it was found to be
+// one, so the delay after it is not shortened by the time the check took.
+func (r *Redis) FailLoginAttempt(ctx context.Context, a *LoginAttempt, now time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return failScript.Run(ctx, r.Client, []string{"login_fail:" + a.Key}, a.at, now.UnixMilli()).Err()
+}
+
+// endScript takes one failure off KEYS[1] and, unless a later attempt has
+// moved it on, puts the time of the last one back to ARGV[2].
+var endScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "last") == ARGV[1] then
+	redis.call("HSET", KEYS[1], "last", ARGV[2])
+end
+if redis.call("HINCRBY", KEYS[1], "count", -1) <= 0 then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// EndLoginAttempt takes back the failure BeginLoginAttempt counted for a.
+func (r *Redis) EndLoginAttempt(ctx context.Context, a *LoginAttempt) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return endScript.Run(ctx, r.Client, []string{"login_fail:" + a.Key}, a.at, a.prev).Err()
+}
+
+// LoginFailures returns the failures counted against key and when the last
+// one was.
+func (r *Redis) LoginFailures(ctx context.Context, key string) (int, time.Time, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	fields, err := r.Client.HGetAll(ctx, "login_fail:"+key).Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	count, _ := strconv.Atoi(fields["count"])
+	last, _ := strconv.ParseInt(fields["last"], 10, 64)
+	return count, time.UnixMilli(last), nil
+}
+
+// LockLogin blocks logins for key for d and resets its failure count.
+func (r *Redis) LockLogin(ctx context.Context, key string, d time.Duration) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	pipe := r.Client.TxPipeline()
+	pipe.Set(ctx, "login_lock:"+key, 1, d)
+	pipe.Del(ctx, "login_fail:"+key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) ClearLoginFailures(ctx context.Context, key string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.Client.Del(ctx, "login_fail:"+key).Err()
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mail selects how email is sent. Backend is "smtp", "file" or "memory";
+// "file" writes each message to Dir as an .eml file, a stand-in for a mail
+// server in development.
+type Mail struct {
+	Backend string
+	From    string
+	Dir     string
+	SMTP    SMTP
+}
+
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func LoadMail() Mail {
+	backend := os.Getenv("MAIL_BACKEND")
+	if backend == "" {
+		backend = "file"
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "PixelForge <no-reply@localhost>"
+	}
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = "mail"
+	}
+	return Mail{
+		Backend: backend,
+		From:    from,
+		Dir:     dir,
+		SMTP: SMTP{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		},
+	}
+}
+
+// Email verification policies: what an unverified account may not do.
+const (
+	VerifyNone    = "none"
+	VerifyUploads = "uploads"
+	VerifyLogin   = "login"
+)
+
+// Auth configures the account flows that send email. AppURL is where links
+// in those emails point. ResendLimit caps verification emails per address
+// per hour; ResetLimit and ResetIPLimit cap password reset requests per
+// address and per client address per hour.
+type Auth struct {
+	AppURL       string
+	ResetTTL     time.Duration
+	VerifyPolicy string
+	VerifyTTL    time.Duration
+	ResendLimit  int
+	ResetLimit   int
+	ResetIPLimit int
+	Lockout      Lockout
+}
+
+// Lockout limits failed logins. An account or client address with
+// AccountLimit or IPLimit failures within Window is locked for Duration; a
+// limit of 0 turns that check off.
+type Lockout struct {
+	AccountLimit int
+	IPLimit      int
+	Window       time.Duration
+	Duration     time.Duration
+}
+
+func LoadAuth() (Auth, error) {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+	policy := os.Getenv("EMAIL_VERIFICATION")
+	if policy == "" {
+		policy = VerifyNone
+	}
+	if policy != VerifyNone && policy != VerifyUploads && policy != VerifyLogin {
+		return Auth{}, fmt.Errorf("EMAIL_VERIFICATION must be none, uploads or login, got %q", policy)
+	}
+	return Auth{
+		AppURL:       strings.TrimRight(appURL, "/"),
+		ResetTTL:     time.Duration(envInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute,
+		VerifyPolicy: policy,
+		VerifyTTL:    time.Duration(envInt("EMAIL_VERIFY_TTL_HOURS", 24)) * time.Hour,
+		ResendLimit:  envInt("EMAIL_VERIFY_RESENDS_PER_HOUR", 3),
+		ResetLimit:   envInt("PASSWORD_RESETS_PER_HOUR", 3),
+		ResetIPLimit: envInt("PASSWORD_RESETS_PER_IP_PER_HOUR", 20),
+		Lockout: Lockout{
+			AccountLimit: envInt("LOGIN_MAX_FAILURES", 5),
+			IPLimit:      envInt("LOGIN_MAX_IP_FAILURES", 50),
+			Window:       time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+			Duration:     time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		},
+	}, nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// OIDC configures sign-in through an OpenID Connect provider. It is off
+// unless Issuer is set. RedirectURL must be registered with the provider
+// and point at /auth/oidc/callback.
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+func (c OIDC) Enabled() bool {
+	return c.Issuer != ""
+}
+
+func LoadOIDC() OIDC {
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return OIDC{
+		Issuer:       strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       scopes,
+	}
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// LoadTrustedProxies reads TRUSTED_PROXIES, the comma-separated addresses or
+// CIDR ranges of the reverse proxies in front of the API. Only requests
+// arriving from one of them may name the client with X-Forwarded-For; with
+// none set, the client address is always the connection's peer, so nobody
+// can pass an API key's IP allow-list by sending the header.
+func LoadTrustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allowScript counts a hit on KEYS[1], starting a window of ARGV[1]
+// milliseconds on the first, and returns the count and the window's
+// remaining milliseconds.
+var allowScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {n, redis.call("PTTL", KEYS[1])}
+`)
+
+// Allow counts an attempt against key in a fixed window and reports whether
+// it is within limit. When it is not, it also returns how long until the
+// window ends.
+func (r *Redis) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	res, err := allowScript.Run(ctx, r.Client, []string{"ratelimit:" + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if res[0] <= int64(limit) {
+		return true, 0, nil
+	}
+	return false, time.Duration(res[1]) * time.Millisecond, nil
+}
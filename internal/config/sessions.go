@@ -0,0 +1,132 @@
+package config
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sessions live in the hash "session:<id>" and are indexed per user in the
+// set "sessions:<userID>". Both expire with the session's refresh token.
+
+// lastSeenEvery throttles last_seen writes made while authenticating.
+const lastSeenEvery = time.Minute
+
+// SaveSession creates or updates a session and pushes its expiry to exp.
+// The creation time of an existing session is kept.
+func (r *Redis) SaveSession(ctx context.Context, s *models.Session, exp time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	key := "session:" + s.ID
+	pipe := r.Client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"user_id", s.UserID,
+		"user_agent", s.UserAgent,
+		"ip", s.IP,
+		"last_seen", s.LastSeen.Unix(),
+	)
+	pipe.HSetNX(ctx, key, "created_at", s.CreatedAt.Unix())
+	pipe.ExpireAt(ctx, key, exp)
+	pipe.SAdd(ctx, "sessions:"+s.UserID, s.ID)
+	pipe.ExpireAt(ctx, "sessions:"+s.UserID, exp)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// touchScript reports whether session KEYS[1] exists and, if it was last
+// seen at least ARGV[2] seconds before ARGV[1], moves last_seen to ARGV[1].
+var touchScript = redis.NewScript(`
+local last = redis.call("HGET", KEYS[1], "last_seen")
+if not last then
+	return 0
+end
+if tonumber(ARGV[1]) - tonumber(last) >= tonumber(ARGV[2]) then
+	redis.call("HSET", KEYS[1], "last_seen", ARGV[1])
+end
+return 1
+`)
+
+// TouchSession reports whether the session is still active and records
+// that it was seen now.
+func (r *Redis) TouchSession(ctx context.Context, id string, now time.Time) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	n, err := touchScript.Run(ctx, r.Client, []string{"session:" + id}, now.Unix(), int64(lastSeenEvery.Seconds())).Int()
+	return n == 1, err
+}
+
+// ListSessions returns the user's active sessions, forgetting index entries
+// whose session has expired.
+func (r *Redis) ListSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	ids, err := r.Client.SMembers(ctx, "sessions:"+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*models.Session{}
+	for _, id := range ids {
+		fields, err := r.Client.HGetAll(ctx, "session:"+id).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			r.Client.SRem(ctx, "sessions:"+userID, id)
+			continue
+		}
+		sessions = append(sessions, &models.Session{
+			ID:        id,
+			UserID:    fields["user_id"],
+			UserAgent: fields["user_agent"],
+			IP:        fields["ip"],
+			CreatedAt: unixField(fields["created_at"]),
+			LastSeen:  unixField(fields["last_seen"]),
+		})
+	}
+	return sessions, nil
+}
+
+func unixField(v string) time.Time {
+	sec, _ := strconv.ParseInt(v, 10, 64)
+	return time.Unix(sec, 0).UTC()
+}
+
+// RevokeSession ends the session and revokes its tokens. It reports false,
+// and does nothing, if the user has no such session.
+func (r *Redis) RevokeSession(ctx context.Context, userID, id string) (bool, error) {
+	tctx, cancel := r.withTimeout(ctx)
+	n, err := r.Client.SRem(tctx, "sessions:"+userID, id).Result()
+	cancel()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if err := r.RevokeFamily(ctx, id); err != nil {
+		return false, err
+	}
+	return true, r.DelJTI(ctx, "session:"+id)
+}
+
+// RevokeAllSessions ends every session of the user and returns how many
+// there were.
+func (r *Redis) RevokeAllSessions(ctx context.Context, userID string) (int, error) {
+	tctx, cancel := r.withTimeout(ctx)
+	ids, err := r.Client.SMembers(tctx, "sessions:"+userID).Result()
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, id := range ids {
+		ok, err := r.RevokeSession(ctx, userID, id)
+		if err != nil {
+			return revoked, err
+		}
+		if ok {
+			revoked++
+		}
+	}
+	return revoked, nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Signing configures the keys access and refresh tokens are signed with.
+// A new key is generated every RotateEvery and published PublishLead before
+// it starts signing, so verifiers that cache the JWKS pick it up in time.
+// A retired key keeps verifying until every token it signed has expired.
+type Signing struct {
+	Alg         string // "EdDSA" or "RS256"
+	RotateEvery time.Duration
+	PublishLead time.Duration
+	// TokenLifetime is the longest a signed token stays valid: the refresh
+	// token lifetime.
+	TokenLifetime time.Duration
+}
+
+func LoadSigning() (Signing, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = "EdDSA"
+	}
+	if alg != "EdDSA" && alg != "RS256" {
+		return Signing{}, fmt.Errorf("JWT_SIGNING_ALG must be EdDSA or RS256, got %q", alg)
+	}
+	return Signing{
+		Alg:           alg,
+		RotateEvery:   time.Duration(envInt("JWT_ROTATE_DAYS", 30)) * 24 * time.Hour,
+		PublishLead:   time.Duration(envInt("JWT_PUBLISH_LEAD_MINUTES", 60)) * time.Minute,
+		TokenLifetime: 7 * 24 * time.Hour,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Storage selects where image bytes live. Backend is "local" or "s3".
+// Fallbacks are backends still read from, but never written to, while their
+// files are migrated to Backend.
+type Storage struct {
+	Backend   string
+	Fallbacks []string
+	RootDir   string
+	S3        S3
+}
+
+// S3 configures an S3-compatible object store such as AWS S3 or MinIO.
+type S3 struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	PartSize        uint64 // bytes per multipart upload part
+}
+
+func LoadStorage() Storage {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = "local"
+	}
+	root := os.Getenv("STORAGE_ROOT")
+	if root == "" {
+		root = "storage"
+	}
+	var fallbacks []string
+	for _, name := range strings.Split(os.Getenv("STORAGE_FALLBACK_BACKENDS"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name != backend {
+			fallbacks = append(fallbacks, name)
+		}
+	}
+	useSSL, err := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+	if err != nil {
+		useSSL = true
+	}
+	return Storage{
+		Backend:   backend,
+		Fallbacks: fallbacks,
+		RootDir:   root,
+		S3: S3{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			UseSSL:          useSSL,
+			PartSize:        uint64(envInt("S3_PART_SIZE_MB", 16)) << 20,
+		},
+	}
+}
@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+// Timeouts caps how long each kind of operation may run before its context
+// is cancelled.
+type Timeouts struct {
+	Upload    time.Duration
+	Transform time.Duration
+	Redis     time.Duration
+}
+
+func LoadTimeouts() Timeouts {
+	return Timeouts{
+		Upload:    time.Duration(envInt("UPLOAD_TIMEOUT_SECONDS", 30)) * time.Second,
+		Transform: time.Duration(envInt("TRANSFORM_TIMEOUT_SECONDS", 60)) * time.Second,
+		Redis:     time.Duration(envInt("REDIS_TIMEOUT_MS", 2000)) * time.Millisecond,
+	}
+}
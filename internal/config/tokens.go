@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// One-time tokens, such as password reset tokens, live in "<kind>:<hash>"
+// holding the user ID. "<kind>_user:<userID>" points at the user's latest
+// token of that kind, so issuing a new one invalidates the last.
+
+// saveTokenScript stores token KEYS[1] for user ARGV[1] with a TTL of ARGV[2]
+// milliseconds, deleting the token KEYS[2] pointed at. ARGV[3] is the key
+// prefix of KEYS[1] and ARGV[4] the new token's hash.
+var saveTokenScript = redis.NewScript(`
+local old = redis.call("GET", KEYS[2])
+if old then
+	redis.call("DEL", ARGV[3] .. old)
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("SET", KEYS[2], ARGV[4], "PX", ARGV[2])
+return 1
+`)
+
+// SaveOneTimeToken stores the hash of a token of kind for the user, valid
+// for ttl, and revokes the user's previous token of that kind.
+func (r *Redis) SaveOneTimeToken(ctx context.Context, kind, hash, userID string, ttl time.Duration) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	keys := []string{kind + ":" + hash, kind + "_user:" + userID}
+	return saveTokenScript.Run(ctx, r.Client, keys, userID, ttl.Milliseconds(), kind+":", hash).Err()
+}
+
+// TakeOneTimeToken consumes a token of kind by its hash and returns the user
+// it was issued to, or "" if it does not exist, expired or was used.
+func (r *Redis) TakeOneTimeToken(ctx context.Context, kind, hash string) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	userID, err := r.Client.GetDel(ctx, kind+":"+hash).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return userID, err
+}
+
+// SaveOneTimeValue stores value under a key of kind for ttl, to be taken
+// once with TakeOneTimeToken. Unlike SaveOneTimeToken it leaves other keys
+// of the kind alone.
+func (r *Redis) SaveOneTimeValue(ctx context.Context, kind, key, value string, ttl time.Duration) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.Client.Set(ctx, kind+":"+key, value, ttl).Err()
+}
+
+// PeekOneTimeToken returns the user a token of kind was issued to, like
+// TakeOneTimeToken but leaving the token in place.
+func (r *Redis) PeekOneTimeToken(ctx context.Context, kind, hash string) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	userID, err := r.Client.Get(ctx, kind+":"+hash).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return userID, err
+}
+
+// ClaimOnce reports whether key was unclaimed, claiming it for ttl. It
+// keeps a one-time code from being accepted twice.
+func (r *Redis) ClaimOnce(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.Client.SetNX(ctx, "claimed:"+key, 1, ttl).Result()
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/HarshithRajesh/PixelForge/internal/admin"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	admin admin.Service
+}
+
+func NewAdminHandler(admin admin.Service) *AdminHandler {
+	return &AdminHandler{admin: admin}
+}
+
+// ListUsers pages through all users with ?offset= and ?limit=.
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(admin.MaxPageSize)))
+	users, total, err := h.admin.ListUsers(c.Request.Context(), actor(c), offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total})
+}
+
+func (h *AdminHandler) UserImages(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	images, err := h.admin.UserImages(c.Request.Context(), actor(c), userID)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	if err := h.admin.Suspend(c.Request.Context(), actor(c), userID); err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User suspended"})
+}
+
+func (h *AdminHandler) UnsuspendUser(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	if err := h.admin.Unsuspend(c.Request.Context(), actor(c), userID); err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unsuspended"})
+}
+
+func (h *AdminHandler) SetUserRole(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	var req models.SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body: " + err.Error()})
+		return
+	}
+	if err := h.admin.SetRole(c.Request.Context(), actor(c), userID, req.Role); err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role changed; the user must log in again"})
+}
+
+func actor(c *gin.Context) admin.Actor {
+	return admin.Actor{UserID: c.MustGet("userID").(string), Role: c.GetString("role"), IP: c.ClientIP()}
+}
+
+func userParam(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, admin.ErrSelf):
+		return http.StatusConflict
+	case errors.Is(err, admin.ErrOutranked):
+		return http.StatusForbidden
+	case errors.Is(err, rbac.ErrUnknownRole):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/HarshithRajesh/PixelForge/internal/apikey"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	apiKeys apikey.Service
+}
+
+func NewAPIKeyHandler(apiKeys apikey.Service) *APIKeyHandler {
+	return &APIKeyHandler{apiKeys: apiKeys}
+}
+
+// CreateAPIKey returns the new key's secret. It is shown this once.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body: " + err.Error()})
+		return
+	}
+	key, secret, err := h.apiKeys.Create(c.Request.Context(), userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created; store it now, it will not be shown again",
+		"api_key": key,
+		"key":     secret,
+	})
+}
+
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	keys, err := h.apiKeys.List(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key ID"})
+		return
+	}
+	if err := h.apiKeys.Revoke(c.Request.Context(), userID, uint(keyID)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, apikey.ErrKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/HarshithRajesh/PixelForge/internal/models"
 	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,15 +29,72 @@ func (h *ImageManagementHandler) ImageUpload(c *gin.Context) {
 	}
 
 	userID := c.MustGet("userID").(string)
-	err = h.imgService.UploadImage(c.Request.Context(), file, userID)
+	image, duplicate, err := h.imgService.UploadImage(c.Request.Context(), file, userID, uploadPolicy(c))
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "file was not sent to processor", "err": err.Error()})
+		c.JSON(imageErrorStatus(c, err, http.StatusConflict), gin.H{"error": "file was not sent to processor", "err": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "image sent to save"})
+	c.JSON(http.StatusOK, gin.H{"message": "image sent to save", "image": image, "duplicate": duplicate})
 	return
 }
 
+// imageErrorStatus maps the processor's size, quota, decoding, capacity,
+// timeout and integrity errors to their HTTP status, falling back to def for anything
+// else. A busy scheduler also sets Retry-After on the response.
+func imageErrorStatus(c *gin.Context, err error, def int) int {
+	var busy *processor.BusyError
+	if errors.As(err, &busy) {
+		c.Header("Retry-After", strconv.Itoa(int(busy.RetryAfter.Seconds())))
+		return http.StatusServiceUnavailable
+	}
+	switch {
+	case errors.Is(err, processor.ErrFileTooLarge), errors.Is(err, processor.ErrImageTooLarge),
+		errors.Is(err, repository.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, processor.ErrUndecodableImage):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		return http.StatusInternalServerError
+	}
+	return def
+}
+
+// uploadPolicy reads an optional per-request metadata policy from the form.
+// It returns nil when neither field is sent so the user's default applies.
+func uploadPolicy(c *gin.Context) *models.MetadataPolicy {
+	copyright, hasCopyright := c.GetPostForm("keep_copyright")
+	orientation, hasOrientation := c.GetPostForm("keep_orientation")
+	if !hasCopyright && !hasOrientation {
+		return nil
+	}
+	keepCopyright, _ := strconv.ParseBool(copyright)
+	keepOrientation, _ := strconv.ParseBool(orientation)
+	return &models.MetadataPolicy{KeepCopyright: keepCopyright, KeepOrientation: keepOrientation}
+}
+
+// ProcessingStatus shows the scheduler's queue and memory budget. It is for
+// admins only, since it tells callers how close the server is to its limits.
+func (h *ImageManagementHandler) ProcessingStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.imgService.ProcessingStatus())
+}
+
+func (h *ImageManagementHandler) SetMetadataPolicy(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	var policy models.MetadataPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body: " + err.Error()})
+		return
+	}
+	if err := h.imgService.SetMetadataPolicy(c.Request.Context(), userID, &policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Metadata policy updated", "policy": policy})
+}
+
 func (h *ImageManagementHandler) ListImages(c *gin.Context) {
 	userIDStr := c.MustGet("userID").(string)
 	userID, err := strconv.Atoi(userIDStr)
@@ -42,7 +103,7 @@ func (h *ImageManagementHandler) ListImages(c *gin.Context) {
 		return
 	}
 	var images []*models.Image
-	images, err = h.imgService.ListImages(uint(userID))
+	images, err = h.imgService.ListImages(c.Request.Context(), uint(userID))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -65,10 +126,40 @@ func (h *ImageManagementHandler) Transform(c *gin.Context) {
 		return
 	}
 
-	if err := h.imgService.Transform(&uri, userIDStr, &req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.imgService.Transform(c.Request.Context(), &uri, userIDStr, &req); err != nil {
+		c.JSON(imageErrorStatus(c, err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Image Transform Successful!!!"})
 }
+
+func (h *ImageManagementHandler) DeleteImage(c *gin.Context) {
+	userIDStr := c.MustGet("userID").(string)
+
+	var uri models.URIParam
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URI parameters: " + err.Error()})
+		return
+	}
+	if err := h.imgService.DeleteImage(c.Request.Context(), &uri, userIDStr); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrImageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted"})
+}
+
+func (h *ImageManagementHandler) Usage(c *gin.Context) {
+	userIDStr := c.MustGet("userID").(string)
+
+	report, err := h.imgService.Usage(c.Request.Context(), userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/HarshithRajesh/PixelForge/internal/signing"
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct {
+	keys *signing.KeySet
+}
+
+func NewJWKSHandler(keys *signing.KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// JWKS publishes the token verification keys. The cache lifetime must stay
+// well under JWT_PUBLISH_LEAD_MINUTES so verifiers see a new key before it
+// signs anything.
+func (h *JWKSHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
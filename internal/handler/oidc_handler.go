@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/oidc"
+	"github.com/HarshithRajesh/PixelForge/internal/user"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcFlowTTL is how long a sign-in at the provider may take.
+const oidcFlowTTL = 10 * time.Minute
+
+type OIDCHandler struct {
+	provider    *oidc.Provider
+	userService user.UserService
+	rds         *config.Redis
+}
+
+func NewOIDCHandler(provider *oidc.Provider, userService user.UserService, rds *config.Redis) *OIDCHandler {
+	return &OIDCHandler{provider: provider, userService: userService, rds: rds}
+}
+
+// Login sends the browser to the provider. The flow's secrets stay in
+// Redis; the state also goes into a cookie, so the callback only completes
+// in the browser that started it.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	value, _ := json.Marshal(flow)
+	if err := h.rds.SaveOneTimeValue(c.Request.Context(), "oidc", flow.State, string(value), oidcFlowTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("oidc_state", flow.State, int(oidcFlowTTL.Seconds()), "/auth/oidc", "", true, true)
+	c.Redirect(http.StatusFound, h.provider.AuthURL(flow))
+}
+
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in failed at the provider: " + e})
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie("oidc_state")
+	if state == "" || cookie != state {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in state does not match, please try again"})
+		return
+	}
+	c.SetCookie("oidc_state", "", -1, "/auth/oidc", "", true, true)
+
+	ctx := c.Request.Context()
+	value, err := h.rds.TakeOneTimeToken(ctx, "oidc", state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var flow oidc.Flow
+	if value == "" || json.Unmarshal([]byte(value), &flow) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in expired, please try again"})
+		return
+	}
+
+	claims, err := h.provider.Exchange(ctx, c.Query("code"), &flow)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in failed: " + err.Error()})
+		return
+	}
+	token, challenge, err := h.userService.ExternalLogin(ctx, &models.ExternalLogin{
+		Issuer:        h.provider.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Client:        clientInfo(c),
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrProviderEmailNotVerified) || errors.Is(err, user.ErrAccountEmailNotVerified) ||
+			errors.Is(err, user.ErrAccountSuspended) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	respondLogin(c, token, challenge)
+}
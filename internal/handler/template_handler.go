@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/gin-gonic/gin"
+)
+
+type TemplateHandler struct {
+	templateService processor.TemplateService
+}
+
+func NewTemplateHandler(templateService processor.TemplateService) *TemplateHandler {
+	return &TemplateHandler{templateService: templateService}
+}
+
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	var template models.Template
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body: " + err.Error()})
+		return
+	}
+	if err := h.templateService.CreateTemplate(c.Request.Context(), userID, &template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template created", "template": template})
+}
+
+func (h *TemplateHandler) ListTemplates(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	templates, err := h.templateService.ListTemplates(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (h *TemplateHandler) RenderTemplate(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+
+	var uri models.URIParam
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URI parameters: " + err.Error()})
+		return
+	}
+	var req models.RenderTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body: " + err.Error()})
+		return
+	}
+
+	image, err := h.templateService.RenderTemplate(c.Request.Context(), uri.ID, userID, req.Variables)
+	if err != nil {
+		c.JSON(imageErrorStatus(c, err, http.StatusUnprocessableEntity), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template rendered", "image": image})
+}
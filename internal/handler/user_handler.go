@@ -2,7 +2,7 @@
 package handler
 
 import (
-	"context"
+	"errors"
 	"net/http"
 
 	"github.com/HarshithRajesh/PixelForge/internal/config"
@@ -22,15 +22,21 @@ func NewUserHandler(userService user.UserService, rds *config.Redis) *UserHandle
 }
 
 func (h *UserHandler) SignUp(c *gin.Context) {
-	var user models.User
-	err := c.ShouldBindBodyWithJSON(&user)
+	var signUp models.SignUp
+	err := c.ShouldBindBodyWithJSON(&signUp)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	err = h.userService.SignUp(&user)
+	user := models.User{
+		Name:            signUp.Name,
+		Email:           signUp.Email,
+		Password:        signUp.Password,
+		ConfirmPassword: signUp.ConfirmPassword,
+	}
+	err = h.userService.SignUp(c.Request.Context(), &user)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
@@ -42,33 +48,250 @@ func (h *UserHandler) SignUp(c *gin.Context) {
 }
 
 func (h *UserHandler) Login(c *gin.Context) {
-	var user models.Login
-	err := c.ShouldBindBodyWithJSON(&user)
+	var login models.Login
+	err := c.ShouldBindBodyWithJSON(&login)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	token, err := h.userService.Login(c.Request.Context(), &user)
+	login.Client = clientInfo(c)
+	token, challenge, err := h.userService.Login(c.Request.Context(), &login)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			status = http.StatusUnauthorized
+		case errors.Is(err, user.ErrEmailNotVerified), errors.Is(err, user.ErrAccountSuspended):
+			status = http.StatusForbidden
+		case errors.Is(err, user.ErrTooManyRequests):
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
+	respondLogin(c, token, challenge)
+}
+
+// respondLogin sets the cookies of a completed login, or asks for the
+// second factor.
+func respondLogin(c *gin.Context, token *middleware.Tokens, challenge *models.MFAChallenge) {
+	if challenge != nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":       "Enter the code from your authenticator app",
+			"mfa_challenge": challenge.Challenge,
+			"expires_at":    challenge.ExpiresAt,
+		})
+		return
+	}
 	middleware.SetAuthCookies(c, token)
 	c.JSON(http.StatusOK, gin.H{"message": "Login Successfull"})
 }
 
+// LoginMFA completes a login that answered with an MFA challenge.
+func (h *UserHandler) LoginMFA(c *gin.Context) {
+	var req models.MFALogin
+	if err := c.ShouldBindJSON(&req); err != nil || req.Challenge == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_challenge and code are required"})
+		return
+	}
+	req.Client = clientInfo(c)
+	token, err := h.userService.CompleteMFA(c.Request.Context(), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrInvalidMFAChallenge) || errors.Is(err, user.ErrInvalidMFACode) {
+			status = http.StatusUnauthorized
+		} else if errors.Is(err, user.ErrAccountSuspended) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, user.ErrTooManyRequests) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuthCookies(c, token)
+	c.JSON(http.StatusOK, gin.H{"message": "Login Successfull"})
+}
+
+func (h *UserHandler) EnrollTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	enrollment, err := h.userService.EnrollTOTP(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Add the account to your authenticator app, then confirm with a code",
+		"totp":    enrollment,
+	})
+}
+
+func (h *UserHandler) ConfirmTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	var req models.MFACode
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	codes, err := h.userService.ConfirmTOTP(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled; store the recovery codes now, they will not be shown again",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *UserHandler) DisableTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	var req models.MFACode
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	if err := h.userService.DisableTOTP(c.Request.Context(), userID, req.Code); err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, user.ErrInvalidMFACode):
+		return http.StatusUnauthorized
+	case errors.Is(err, user.ErrTOTPEnabled), errors.Is(err, user.ErrTOTPNotEnrolled):
+		return http.StatusConflict
+	case errors.Is(err, user.ErrTooManyRequests):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh rotates the refresh token from the refresh_token cookie, or from
+// the JSON body for clients that do not keep cookies.
+func (h *UserHandler) Refresh(c *gin.Context) {
+	token, _ := c.Cookie("refresh_token")
+	if token == "" {
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err == nil {
+			token = req.RefreshToken
+		}
+	}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing refresh token"})
+		return
+	}
+
+	tokens, err := h.userService.Refresh(c.Request.Context(), token, clientInfo(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrInvalidRefreshToken) || errors.Is(err, user.ErrRefreshTokenReused) {
+			status = http.StatusUnauthorized
+			middleware.ClearAuthCookies(c)
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuthCookies(c, tokens)
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Token refreshed",
+		"access_token":  tokens.Access,
+		"refresh_token": tokens.Refresh,
+		"expires_at":    tokens.ExpAcc,
+	})
+}
+
+// ForgotPassword answers the same whether or not the address has an account.
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPassword
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	if err := h.userService.ForgotPassword(c.Request.Context(), req.Email, c.ClientIP()); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrTooManyRequests) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If that address has an account, a password reset link is on its way"})
+}
+
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPassword
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.userService.ResetPassword(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.ClearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated; please log in again"})
+}
+
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmail
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	if err := h.userService.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrInvalidVerifyToken) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	var req models.ForgotPassword
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	if err := h.userService.ResendVerification(c.Request.Context(), req.Email); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrTooManyRequests) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If that address has an unverified account, a new link is on its way"})
+}
+
+func clientInfo(c *gin.Context) models.Client {
+	return models.Client{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
 func (h *UserHandler) Logout(c *gin.Context) {
 	acc, _ := c.Cookie("access_token")
 	ref, _ := c.Cookie("refresh_token")
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	if acc != "" {
 		if claims, err := middleware.ParseAccess(acc); err == nil {
 			_ = h.rds.DelJTI(ctx, "access:"+claims.ID)
+			if claims.Family != "" {
+				_, _ = h.rds.RevokeSession(ctx, claims.Subject, claims.Family)
+			}
 		}
 	}
 	if ref != "" {
@@ -79,3 +302,42 @@ func (h *UserHandler) Logout(c *gin.Context) {
 	middleware.ClearAuthCookies(c)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
+
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	sessions, err := h.userService.ListSessions(c.Request.Context(), userID, c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	sessionID := c.Param("id")
+	if err := h.userService.RevokeSession(c.Request.Context(), userID, sessionID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, user.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if sessionID == c.GetString("sessionID") {
+		middleware.ClearAuthCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeAllSessions logs the user out everywhere, including this session.
+func (h *UserHandler) RevokeAllSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(string)
+	n, err := h.userService.RevokeAllSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.ClearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere", "revoked": n})
+}
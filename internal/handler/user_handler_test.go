@@ -2,14 +2,18 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/HarshithRajesh/PixelForge/internal/handler"
+	"github.com/HarshithRajesh/PixelForge/internal/middleware"
 	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/user"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,14 +23,87 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) SignUp(user *models.User) error {
+func (m *MockUserService) SignUp(ctx context.Context, user *models.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockUserService) Login(user *models.Login) (string, error) {
+func (m *MockUserService) Login(ctx context.Context, user *models.Login) (*middleware.Tokens, *models.MFAChallenge, error) {
 	args := m.Called(user)
-	return args.String(0), args.Error(1)
+	tokens, _ := args.Get(0).(*middleware.Tokens)
+	challenge, _ := args.Get(1).(*models.MFAChallenge)
+	return tokens, challenge, args.Error(2)
+}
+
+func (m *MockUserService) CompleteMFA(ctx context.Context, req *models.MFALogin) (*middleware.Tokens, error) {
+	args := m.Called(req)
+	tokens, _ := args.Get(0).(*middleware.Tokens)
+	return tokens, args.Error(1)
+}
+
+func (m *MockUserService) ExternalLogin(ctx context.Context, login *models.ExternalLogin) (*middleware.Tokens, *models.MFAChallenge, error) {
+	args := m.Called(login)
+	tokens, _ := args.Get(0).(*middleware.Tokens)
+	challenge, _ := args.Get(1).(*models.MFAChallenge)
+	return tokens, challenge, args.Error(2)
+}
+
+func (m *MockUserService) EnrollTOTP(ctx context.Context, userID string) (*models.TOTPEnrollment, error) {
+	args := m.Called(userID)
+	enrollment, _ := args.Get(0).(*models.TOTPEnrollment)
+	return enrollment, args.Error(1)
+}
+
+func (m *MockUserService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockUserService) DisableTOTP(ctx context.Context, userID, code string) error {
+	return m.Called(userID, code).Error(0)
+}
+
+func (m *MockUserService) Refresh(ctx context.Context, refreshToken string, client models.Client) (*middleware.Tokens, error) {
+	args := m.Called(refreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*middleware.Tokens), args.Error(1)
+}
+
+func (m *MockUserService) ListSessions(ctx context.Context, userID, currentID string) ([]*models.Session, error) {
+	args := m.Called(userID, currentID)
+	return args.Get(0).([]*models.Session), args.Error(1)
+}
+
+func (m *MockUserService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return m.Called(userID, sessionID).Error(0)
+}
+
+func (m *MockUserService) RevokeAllSessions(ctx context.Context, userID string) (int, error) {
+	args := m.Called(userID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockUserService) ForgotPassword(ctx context.Context, email, ip string) error {
+	return m.Called(email, ip).Error(0)
+}
+
+func (m *MockUserService) ResetPassword(ctx context.Context, req *models.ResetPassword) error {
+	return m.Called(req).Error(0)
+}
+
+func (m *MockUserService) VerifyEmail(ctx context.Context, token string) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *MockUserService) ResendVerification(ctx context.Context, email string) error {
+	return m.Called(email).Error(0)
+}
+
+func (m *MockUserService) UploadAllowed(ctx context.Context, userID string) error {
+	return m.Called(userID).Error(0)
 }
 
 // func (m *MockUserService)Logout()error{
@@ -38,6 +115,7 @@ func setupRouter(h *handler.UserHandler) *gin.Engine {
 	r := gin.New()
 	r.POST("/signup", h.SignUp)
 	r.POST("/login", h.Login)
+	r.POST("/auth/refresh", h.Refresh)
 	return r
 }
 
@@ -94,13 +172,35 @@ func TestSignUpHandler(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   map[string]string{"message": "User Create Successfully"},
 		},
+		{
+			name: "privileged fields are ignored",
+			body: map[string]any{
+				"Email":           "mallory@example.com",
+				"Password":        "abc123",
+				"ConfirmPassword": "abc123",
+				"Role":            models.RoleAdmin,
+				"SuspendedAt":     "2020-01-01T00:00:00Z",
+				"EmailVerifiedAt": "2020-01-01T00:00:00Z",
+				"Plan":            "unlimited",
+				"QuotaMaxBytes":   0,
+				"QuotaMaxImages":  0,
+			},
+			mockSetup: func(m *MockUserService) {
+				m.On("SignUp", mock.MatchedBy(func(u *models.User) bool {
+					return u.Email == "mallory@example.com" && u.Role == "" && u.SuspendedAt == nil &&
+						u.EmailVerifiedAt == nil && u.Plan == "" && u.QuotaMaxBytes == nil && u.QuotaMaxImages == nil
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "User Create Successfully"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockService := new(MockUserService)
 			tt.mockSetup(mockService)
-			h := handler.NewUserHandler(mockService)
+			h := handler.NewUserHandler(mockService, nil)
 			router := setupRouter(h)
 
 			req := httptest.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(buildBody(tt.body)))
@@ -150,7 +250,7 @@ func TestLoginHandler(t *testing.T) {
 				Password: "abc123",
 			},
 			mockSetup: func(m *MockUserService) {
-				m.On("Login", mock.Anything).Return("", errors.New("user doesnt exist"))
+				m.On("Login", mock.Anything).Return(nil, nil, errors.New("user doesnt exist"))
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   map[string]string{"error": "user doesnt exist"},
@@ -163,11 +263,16 @@ func TestLoginHandler(t *testing.T) {
 				Password: "123",
 			},
 			mockSetup: func(m *MockUserService) {
-				m.On("Login", mock.Anything).Return("mocked.jwt.token", nil)
+				m.On("Login", mock.Anything).Return(&middleware.Tokens{
+					Access:  "mocked.access.token",
+					Refresh: "mocked.refresh.token",
+					ExpAcc:  time.Now().Add(time.Minute),
+					ExpRef:  time.Now().Add(time.Hour),
+				}, nil, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody:   map[string]string{"message": "Login Successfull"},
-			expectedCookie: "token",
+			expectedCookie: "access_token",
 		},
 	}
 
@@ -176,7 +281,7 @@ func TestLoginHandler(t *testing.T) {
 			// ARRANGE
 			mockService := new(MockUserService)
 			tt.mockSetup(mockService)
-			h := handler.NewUserHandler(mockService)
+			h := handler.NewUserHandler(mockService, nil)
 			router := setupRouter(h)
 
 			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(buildBody(tt.body)))
@@ -214,3 +319,112 @@ func TestLoginHandler(t *testing.T) {
 		})
 	}
 }
+
+// REFRESH TESTS
+func TestRefreshHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		cookie         string
+		body           interface{}
+		mockSetup      func(m *MockUserService)
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "no refresh token",
+			body:           `{}`,
+			mockSetup:      func(m *MockUserService) {},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "missing refresh token",
+		},
+		{
+			name:   "reused token",
+			cookie: "old.refresh.token",
+			mockSetup: func(m *MockUserService) {
+				m.On("Refresh", "old.refresh.token").Return(nil, user.ErrRefreshTokenReused)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  user.ErrRefreshTokenReused.Error(),
+		},
+		{
+			name: "token in body",
+			body: map[string]string{"refresh_token": "body.refresh.token"},
+			mockSetup: func(m *MockUserService) {
+				m.On("Refresh", "body.refresh.token").Return(&middleware.Tokens{
+					Access:  "new.access.token",
+					Refresh: "new.refresh.token",
+					ExpAcc:  time.Now().Add(time.Minute),
+					ExpRef:  time.Now().Add(time.Hour),
+				}, nil, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockUserService)
+			tt.mockSetup(mockService)
+			router := setupRouter(handler.NewUserHandler(mockService, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(buildBody(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "refresh_token", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var response map[string]interface{}
+			json.NewDecoder(w.Body).Decode(&response)
+			if tt.expectedError != "" {
+				assert.Equal(t, tt.expectedError, response["error"])
+			} else {
+				assert.Equal(t, "new.refresh.token", response["refresh_token"])
+				cookies := map[string]string{}
+				for _, cookie := range w.Result().Cookies() {
+					cookies[cookie.Name] = cookie.Value
+				}
+				assert.Equal(t, "new.access.token", cookies["access_token"])
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+// SESSION TESTS
+func TestRevokeSessionHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		sessionID      string
+		err            error
+		expectedStatus int
+		clearsCookies  bool
+	}{
+		{name: "other session", sessionID: "other", expectedStatus: http.StatusOK},
+		{name: "current session", sessionID: "current", expectedStatus: http.StatusOK, clearsCookies: true},
+		{name: "unknown session", sessionID: "nope", err: user.ErrSessionNotFound, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockUserService)
+			mockService.On("RevokeSession", "1", tt.sessionID).Return(tt.err)
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.DELETE("/sessions/:id", func(c *gin.Context) {
+				c.Set("userID", "1")
+				c.Set("sessionID", "current")
+			}, handler.NewUserHandler(mockService, nil).RevokeSession)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/sessions/"+tt.sessionID, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.clearsCookies, len(w.Result().Cookies()) > 0)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Package keys manages the per-user data keys that encrypt stored files, and
+// wraps other secrets kept in the database with the same master keys.
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+// Keyring hands out each user's data key, creating it on first use, and
+// keeps unwrapped keys in memory so the database is hit once per user.
+type Keyring struct {
+	repo repository.KeyRepository
+	enc  config.Encryption
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func NewKeyring(repo repository.KeyRepository, enc config.Encryption) *Keyring {
+	return &Keyring{repo: repo, enc: enc, cache: map[string][]byte{}}
+}
+
+func (k *Keyring) DataKey(ctx context.Context, userID string) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.cache[userID]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, errors.New("failed to convert userid from string to int")
+	}
+	row, err := k.repo.GetDataKey(ctx, uint(uid))
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		row, err = k.create(ctx, uint(uid))
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err = k.unwrap(row)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	k.cache[userID] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+func (k *Keyring) create(ctx context.Context, userID uint) (*models.DataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	row := &models.DataKey{UserID: userID}
+	if err := k.wrap(row, key); err != nil {
+		return nil, err
+	}
+	return k.repo.CreateDataKey(ctx, row)
+}
+
+// Rewrap re-encrypts every data key not yet wrapped with the active master
+// key. Stored files are untouched because the data keys themselves do not
+// change. It returns how many keys were re-wrapped.
+func (k *Keyring) Rewrap(ctx context.Context) (int, error) {
+	rows, err := k.repo.ListDataKeysNotWrappedWith(ctx, k.enc.ActiveKeyID)
+	if err != nil {
+		return 0, err
+	}
+	for n, row := range rows {
+		key, err := k.unwrap(row)
+		if err != nil {
+			return n, err
+		}
+		oldKeyID := row.KeyID
+		if err := k.wrap(row, key); err != nil {
+			return n, err
+		}
+		if err := k.repo.UpdateWrappedKey(ctx, row, oldKeyID); err != nil {
+			return n, fmt.Errorf("user %d: %w", row.UserID, err)
+		}
+	}
+	return len(rows), nil
+}
+
+// wrap seals key with the active master key. The user ID is bound in as
+// additional data, so a wrapped key copied to another user will not open.
+func (k *Keyring) wrap(row *models.DataKey, key []byte) error {
+	keyID, wrapped, err := Wrap(k.enc, key, userAD(row.UserID))
+	if err != nil {
+		return err
+	}
+	row.KeyID, row.WrappedKey = keyID, wrapped
+	return nil
+}
+
+func (k *Keyring) unwrap(row *models.DataKey) ([]byte, error) {
+	key, err := Unwrap(k.enc, row.KeyID, row.WrappedKey, userAD(row.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("data key of user %d: %w", row.UserID, err)
+	}
+	return key, nil
+}
+
+// Wrap seals secret with the active master key of enc and returns that
+// key's ID along with the result. ad says what the secret is for; Unwrap
+// needs the same ad, so a wrapped secret moved to another row will not open.
+func Wrap(enc config.Encryption, secret, ad []byte) (string, []byte, error) {
+	aead, err := masterAEAD(enc.MasterKeys[enc.ActiveKeyID])
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return enc.ActiveKeyID, aead.Seal(nonce, nonce, secret, ad), nil
+}
+
+// Unwrap opens a secret Wrap sealed with the master key keyID.
+func Unwrap(enc config.Encryption, keyID string, wrapped, ad []byte) ([]byte, error) {
+	master, ok := enc.MasterKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("wrapped with unknown master key %q", keyID)
+	}
+	aead, err := masterAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("malformed wrapped key")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, sealed, ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap with master key %q", keyID)
+	}
+	return secret, nil
+}
+
+func masterAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func userAD(userID uint) []byte {
+	return []byte("pixelforge-data-key:" + strconv.FormatUint(uint64(userID), 10))
+}
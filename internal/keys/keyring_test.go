@@ -0,0 +1,123 @@
+package keys_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/keys"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeys is a KeyRepository over a map.
+type memoryKeys map[uint]models.DataKey
+
+func (m memoryKeys) GetDataKey(ctx context.Context, userID uint) (*models.DataKey, error) {
+	key, ok := m[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (m memoryKeys) CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error) {
+	if _, ok := m[key.UserID]; !ok {
+		m[key.UserID] = *key
+	}
+	return m.GetDataKey(ctx, key.UserID)
+}
+
+func (m memoryKeys) ListDataKeysNotWrappedWith(ctx context.Context, keyID string) ([]*models.DataKey, error) {
+	var out []*models.DataKey
+	for _, key := range m {
+		if key.KeyID != keyID {
+			key := key
+			out = append(out, &key)
+		}
+	}
+	return out, nil
+}
+
+func (m memoryKeys) UpdateWrappedKey(ctx context.Context, key *models.DataKey, oldKeyID string) error {
+	if m[key.UserID].KeyID == oldKeyID {
+		m[key.UserID] = *key
+	}
+	return nil
+}
+
+func masterKeys(ids ...string) map[string][]byte {
+	out := map[string][]byte{}
+	for n, id := range ids {
+		out[id] = bytes.Repeat([]byte{byte(n + 1)}, 32)
+	}
+	return out
+}
+
+func TestDataKeyIsCreatedOnceAndWrapped(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryKeys{}
+	ring := keys.NewKeyring(repo, config.Encryption{MasterKeys: masterKeys("k1"), ActiveKeyID: "k1"})
+
+	key, err := ring.DataKey(ctx, "7")
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+	require.Contains(t, repo, uint(7))
+	assert.Equal(t, "k1", repo[7].KeyID)
+	assert.NotContains(t, string(repo[7].WrappedKey), string(key), "stored unwrapped")
+
+	// A fresh keyring has no cache and must unwrap the stored key.
+	again, err := keys.NewKeyring(repo, config.Encryption{MasterKeys: masterKeys("k1"), ActiveKeyID: "k1"}).DataKey(ctx, "7")
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	other, err := ring.DataKey(ctx, "8")
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	_, err = ring.DataKey(ctx, "abc")
+	assert.Error(t, err)
+}
+
+func TestRewrapKeepsDataKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryKeys{}
+	old := keys.NewKeyring(repo, config.Encryption{MasterKeys: masterKeys("k1"), ActiveKeyID: "k1"})
+	key1, err := old.DataKey(ctx, "1")
+	require.NoError(t, err)
+	key2, err := old.DataKey(ctx, "2")
+	require.NoError(t, err)
+
+	rotated := config.Encryption{MasterKeys: masterKeys("k1", "k2"), ActiveKeyID: "k2"}
+	n, err := keys.NewKeyring(repo, rotated).Rewrap(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "k2", repo[1].KeyID)
+	assert.Equal(t, "k2", repo[2].KeyID)
+
+	n, err = keys.NewKeyring(repo, rotated).Rewrap(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "nothing left to re-wrap")
+
+	// Once re-wrapped, the old master key is no longer needed.
+	onlyNew := config.Encryption{MasterKeys: map[string][]byte{"k2": rotated.MasterKeys["k2"]}, ActiveKeyID: "k2"}
+	ring := keys.NewKeyring(repo, onlyNew)
+	got, err := ring.DataKey(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, key1, got)
+	got, err = ring.DataKey(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, key2, got)
+}
+
+func TestDataKeyNeedsItsMasterKey(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryKeys{}
+	_, err := keys.NewKeyring(repo, config.Encryption{MasterKeys: masterKeys("k1"), ActiveKeyID: "k1"}).DataKey(ctx, "1")
+	require.NoError(t, err)
+
+	_, err = keys.NewKeyring(repo, config.Encryption{MasterKeys: map[string][]byte{"k2": bytes.Repeat([]byte{9}, 32)}, ActiveKeyID: "k2"}).DataKey(ctx, "1")
+	assert.EqualError(t, err, `data key of user 1: wrapped with unknown master key "k1"`)
+}
@@ -0,0 +1,47 @@
+package mailer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/mailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := mailer.New(config.Mail{Backend: "file", Dir: dir, From: "PixelForge <no-reply@pixelforge.test>"})
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(context.Background(), mailer.Message{
+		To:      "alice@example.com",
+		Subject: "Hello",
+		Body:    "line one\nline two\n",
+	}))
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	raw, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "From: PixelForge <no-reply@pixelforge.test>\r\n")
+	assert.Contains(t, string(raw), "To: alice@example.com\r\n")
+	assert.Contains(t, string(raw), "Subject: Hello\r\n")
+	assert.Contains(t, string(raw), "\r\n\r\nline one\r\nline two\r\n")
+}
+
+func TestMessagesAreValidated(t *testing.T) {
+	m := mailer.NewMemoryMailer()
+	ctx := context.Background()
+	assert.Error(t, m.Send(ctx, mailer.Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"}))
+	assert.Error(t, m.Send(ctx, mailer.Message{To: "alice@example.com", Subject: "Hi\r\nBcc: eve@example.com"}))
+	assert.Empty(t, m.Sent())
+
+	_, err := mailer.New(config.Mail{Backend: "smtp"})
+	assert.Error(t, err, "smtp needs a host")
+	_, err = mailer.New(config.Mail{Backend: "pigeon"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,172 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// TIFF tag IDs we care about. Everything else in an EXIF block is treated as
+// non-essential and dropped.
+const (
+	tagOrientation = 0x0112
+	tagCopyright   = 0x8298
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// Exif is the subset of an EXIF block PixelForge understands.
+type Exif struct {
+	Orientation int
+	Copyright   string
+	HasGPS      bool
+	// Tags lists every tag ID found in IFD0 and the EXIF sub-IFD.
+	Tags []uint16
+}
+
+// Has reports whether the given tag ID was present.
+func (e *Exif) Has(tag uint16) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTIFF(data []byte) (*Exif, error) {
+	if len(data) < 8 {
+		return nil, errors.New("exif block too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid tiff byte order")
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, errors.New("invalid tiff magic")
+	}
+
+	ex := &Exif{}
+	ifd0 := order.Uint32(data[4:8])
+	exifIFD, err := parseIFD(data, order, ifd0, ex)
+	if err != nil {
+		return nil, err
+	}
+	if exifIFD != 0 {
+		if _, err := parseIFD(data, order, exifIFD, ex); err != nil {
+			return nil, err
+		}
+	}
+	return ex, nil
+}
+
+// parseIFD walks one IFD, recording tags on ex. It returns the offset of the
+// EXIF sub-IFD when IFD0 points at one.
+func parseIFD(data []byte, order binary.ByteOrder, offset uint32, ex *Exif) (uint32, error) {
+	if int(offset)+2 > len(data) {
+		return 0, errors.New("ifd offset out of range")
+	}
+	count := int(order.Uint16(data[offset:]))
+	var sub uint32
+	for n := 0; n < count; n++ {
+		start := int(offset) + 2 + n*12
+		if start+12 > len(data) {
+			return 0, errors.New("ifd entry out of range")
+		}
+		entry := data[start : start+12]
+		tag := order.Uint16(entry[0:2])
+		typ := order.Uint16(entry[2:4])
+		cnt := order.Uint32(entry[4:8])
+		ex.Tags = append(ex.Tags, tag)
+
+		switch tag {
+		case tagOrientation:
+			if typ == typeShort {
+				ex.Orientation = int(order.Uint16(entry[8:10]))
+			}
+		case tagCopyright:
+			if typ == typeASCII {
+				ex.Copyright = readASCII(data, order, entry[8:12], cnt)
+			}
+		case tagGPSIFD:
+			ex.HasGPS = true
+		case tagExifIFD:
+			sub = order.Uint32(entry[8:12])
+		}
+	}
+	return sub, nil
+}
+
+func readASCII(data []byte, order binary.ByteOrder, value []byte, count uint32) string {
+	var raw []byte
+	if count <= 4 {
+		raw = value[:count]
+	} else {
+		off := order.Uint32(value)
+		if uint64(off)+uint64(count) > uint64(len(data)) {
+			return ""
+		}
+		raw = data[off : off+count]
+	}
+	return string(bytes.TrimRight(raw, "\x00"))
+}
+
+// buildTIFF writes a minimal little-endian TIFF structure holding only the
+// preserved tags. It returns nil when there is nothing to write.
+func buildTIFF(t Tags) []byte {
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    []byte
+	}
+	var entries []entry
+	if t.Orientation > 0 {
+		v := make([]byte, 4)
+		binary.LittleEndian.PutUint16(v, uint16(t.Orientation))
+		entries = append(entries, entry{tagOrientation, typeShort, 1, v})
+	}
+	if t.Copyright != "" {
+		entries = append(entries, entry{tagCopyright, typeASCII, uint32(len(t.Copyright) + 1), append([]byte(t.Copyright), 0)})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	le := binary.LittleEndian
+	ifdSize := 2 + len(entries)*12 + 4
+	extra := 8 + ifdSize
+
+	buf := make([]byte, 8, extra)
+	copy(buf, "II")
+	le.PutUint16(buf[2:], 42)
+	le.PutUint32(buf[4:], 8)
+
+	ifd := make([]byte, ifdSize)
+	le.PutUint16(ifd, uint16(len(entries)))
+	var tail []byte
+	for n, e := range entries {
+		p := ifd[2+n*12:]
+		le.PutUint16(p[0:], e.tag)
+		le.PutUint16(p[2:], e.typ)
+		le.PutUint32(p[4:], e.count)
+		if len(e.value) <= 4 {
+			copy(p[8:12], e.value)
+			continue
+		}
+		le.PutUint32(p[8:], uint32(extra+len(tail)))
+		tail = append(tail, e.value...)
+	}
+	buf = append(buf, ifd...)
+	return append(buf, tail...)
+}
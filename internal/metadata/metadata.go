@@ -0,0 +1,265 @@
+// Package metadata strips embedded metadata (EXIF, GPS, XMP, IPTC, text
+// chunks) from encoded images so nothing identifying leaves PixelForge.
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+)
+
+// Tags holds the metadata values a policy allowed us to keep.
+type Tags struct {
+	Orientation int
+	Copyright   string
+}
+
+// Filter drops whatever the policy does not allow.
+func (t Tags) Filter(policy models.MetadataPolicy) Tags {
+	if !policy.KeepOrientation {
+		t.Orientation = 0
+	}
+	if !policy.KeepCopyright {
+		t.Copyright = ""
+	}
+	return t
+}
+
+// ErrCorruptExif means an image carries an EXIF block that cannot be
+// parsed. The image itself may still be fine.
+var ErrCorruptExif = errors.New("corrupt exif block")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG ancillary chunks that carry metadata rather than pixels or colour.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// Strip removes every metadata block from data and re-embeds only the tags
+// the policy preserves. Formats other than JPEG and PNG are returned as is.
+// A corrupt EXIF block is stripped like any other, keeping no tags.
+func Strip(data []byte, format string, policy models.MetadataPolicy) ([]byte, Tags, error) {
+	ex, err := Extract(data, format)
+	if errors.Is(err, ErrCorruptExif) {
+		ex, err = nil, nil
+	}
+	if err != nil {
+		return nil, Tags{}, err
+	}
+	var kept Tags
+	if ex != nil {
+		kept = Tags{Orientation: ex.Orientation, Copyright: ex.Copyright}.Filter(policy)
+	}
+
+	var clean []byte
+	switch format {
+	case "jpeg":
+		clean, err = stripJPEG(data)
+	case "png":
+		clean, err = stripPNG(data)
+	default:
+		return data, kept, nil
+	}
+	if err != nil {
+		return nil, Tags{}, err
+	}
+	out, err := Embed(clean, format, kept)
+	if err != nil {
+		return nil, Tags{}, err
+	}
+	return out, kept, nil
+}
+
+// Embed writes a minimal EXIF block holding only tags into data. Data is
+// expected to carry no EXIF already, which holds for anything produced by
+// the standard library encoders or by Strip.
+func Embed(data []byte, format string, tags Tags) ([]byte, error) {
+	tiff := buildTIFF(tags)
+	if tiff == nil {
+		return data, nil
+	}
+	switch format {
+	case "jpeg":
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return nil, errors.New("invalid jpeg")
+		}
+		payload := append(append([]byte{}, exifHeader...), tiff...)
+		seg := make([]byte, 4, 4+len(payload))
+		seg[0], seg[1] = 0xFF, 0xE1
+		binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+		seg = append(seg, payload...)
+
+		out := make([]byte, 0, len(data)+len(seg))
+		out = append(out, data[:2]...)
+		out = append(out, seg...)
+		return append(out, data[2:]...), nil
+	case "png":
+		// eXIf must come before IDAT; right after IHDR is always valid.
+		ihdrEnd := len(pngSignature) + 8 + 13 + 4
+		if len(data) < ihdrEnd || !bytes.Equal(data[:len(pngSignature)], pngSignature) {
+			return nil, errors.New("invalid png")
+		}
+		out := make([]byte, 0, len(data)+len(tiff)+12)
+		out = append(out, data[:ihdrEnd]...)
+		out = append(out, pngChunk("eXIf", tiff)...)
+		return append(out, data[ihdrEnd:]...), nil
+	default:
+		return data, nil
+	}
+}
+
+// Extract parses the EXIF block of data, if any. It returns nil when the image
+// carries no EXIF, and ErrCorruptExif when the block cannot be parsed.
+func Extract(data []byte, format string) (*Exif, error) {
+	var tiff []byte
+	switch format {
+	case "jpeg":
+		err := walkJPEG(data, func(marker byte, payload []byte) bool {
+			if marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) {
+				tiff = payload[len(exifHeader):]
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	case "png":
+		err := walkPNG(data, func(typ string, payload []byte) bool {
+			if typ == "eXIf" {
+				tiff = payload
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tiff == nil {
+		return nil, nil
+	}
+	ex, err := parseTIFF(tiff)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptExif, err)
+	}
+	return ex, nil
+}
+
+// keepJPEGSegment reports whether an APPn/COM segment is needed to render the
+// image correctly: JFIF, ICC profiles and the Adobe colour transform flag.
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	pos := 2
+	err := walkJPEG(data, func(marker byte, payload []byte) bool {
+		segLen := len(payload) + 4
+		if keepJPEGSegment(marker, payload) {
+			out = append(out, data[pos:pos+segLen]...)
+		}
+		pos += segLen
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// walkJPEG calls fn for every marker segment before the start of scan. fn
+// returns false to stop early.
+func walkJPEG(data []byte, fn func(marker byte, payload []byte) bool) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errors.New("invalid jpeg")
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return errors.New("corrupt jpeg marker")
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		segLen := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if segLen < 2 || pos+2+segLen > len(data) {
+			return errors.New("corrupt jpeg segment")
+		}
+		if !fn(marker, data[pos+4:pos+2+segLen]) {
+			return nil
+		}
+		pos += 2 + segLen
+	}
+	return nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	pos := len(pngSignature)
+	err := walkPNG(data, func(typ string, payload []byte) bool {
+		chunkLen := len(payload) + 12
+		if !pngMetadataChunks[typ] {
+			out = append(out, data[pos:pos+chunkLen]...)
+		}
+		pos += chunkLen
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// walkPNG calls fn for every chunk up to and including IEND. fn returns false
+// to stop early.
+func walkPNG(data []byte, fn func(typ string, payload []byte) bool) error {
+	if len(data) < len(pngSignature) || !bytes.Equal(data[:len(pngSignature)], pngSignature) {
+		return errors.New("invalid png")
+	}
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return errors.New("corrupt png chunk")
+		}
+		typ := string(data[pos+4 : pos+8])
+		if !fn(typ, data[pos+8:pos+8+n]) || typ == "IEND" {
+			return nil
+		}
+		pos += 12 + n
+	}
+	return nil
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, payload...)
+	crc := crc32.ChecksumIEEE(chunk[4:])
+	return binary.BigEndian.AppendUint32(chunk, crc)
+}
@@ -0,0 +1,241 @@
+package metadata_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/metadata"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	tagMake             = 0x010F
+	tagOrientation      = 0x0112
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagBodySerialNumber = 0xA431
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	value    interface{}
+}
+
+// camera EXIF: make, orientation, copyright, a body serial number in the EXIF
+// sub-IFD and a GPS IFD with a latitude reference.
+func cameraTIFF() []byte {
+	be := binary.BigEndian
+	buf := []byte("MM\x00\x2a\x00\x00\x00\x08")
+
+	writeIFD := func(entries []ifdEntry, offset int) []byte {
+		ifd := make([]byte, 2+len(entries)*12+4)
+		be.PutUint16(ifd, uint16(len(entries)))
+		var tail []byte
+		for n, e := range entries {
+			p := ifd[2+n*12:]
+			be.PutUint16(p[0:], e.tag)
+			be.PutUint16(p[2:], e.typ)
+			switch v := e.value.(type) {
+			case string:
+				raw := append([]byte(v), 0)
+				be.PutUint32(p[4:], uint32(len(raw)))
+				if len(raw) <= 4 {
+					copy(p[8:], raw)
+				} else {
+					be.PutUint32(p[8:], uint32(offset+len(ifd)+len(tail)))
+					tail = append(tail, raw...)
+				}
+			case uint16:
+				be.PutUint32(p[4:], 1)
+				be.PutUint16(p[8:], v)
+			case uint32:
+				be.PutUint32(p[4:], 1)
+				be.PutUint32(p[8:], v)
+			}
+		}
+		return append(ifd, tail...)
+	}
+
+	// Lay out the sub-IFDs after IFD0 at fixed offsets.
+	exifOffset, gpsOffset := 200, 300
+	ifd0 := writeIFD([]ifdEntry{
+		{tagMake, 2, "Canon"},
+		{tagOrientation, 3, uint16(6)},
+		{tagCopyright, 2, "(c) Alice Photo"},
+		{tagExifIFD, 4, uint32(exifOffset)},
+		{tagGPSIFD, 4, uint32(gpsOffset)},
+	}, 8)
+	buf = append(buf, ifd0...)
+	buf = append(buf, make([]byte, exifOffset-len(buf))...)
+	buf = append(buf, writeIFD([]ifdEntry{{tagBodySerialNumber, 2, "SN12345678"}}, exifOffset)...)
+	buf = append(buf, make([]byte, gpsOffset-len(buf))...)
+	buf = append(buf, writeIFD([]ifdEntry{{0x0001, 2, "N"}}, gpsOffset)...)
+	return buf
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 30), 100, 255})
+		}
+	}
+	return img
+}
+
+func jpegWithExif(t *testing.T) []byte {
+	return jpegWithTIFF(t, cameraTIFF())
+}
+
+// corruptTIFF is cameraTIFF cut off in the middle of IFD0.
+func corruptTIFF() []byte {
+	return cameraTIFF()[:20]
+}
+
+func jpegWithTIFF(t *testing.T, tiff []byte) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(), nil))
+	data := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	seg = append(seg, payload...)
+	xmp := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), []byte("<x:xmpmeta>GPS</x:xmpmeta>")...)
+	xseg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(xseg[2:], uint16(len(xmp)+2))
+	xseg = append(xseg, xmp...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, seg...)
+	out = append(out, xseg...)
+	return append(out, data[2:]...)
+}
+
+func pngWithExif(t *testing.T) []byte {
+	return pngWithTIFF(t, cameraTIFF())
+}
+
+func pngWithTIFF(t *testing.T, tiff []byte) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage()))
+	data := buf.Bytes()
+
+	chunk := func(typ string, payload []byte) []byte {
+		c := make([]byte, 8)
+		binary.BigEndian.PutUint32(c, uint32(len(payload)))
+		copy(c[4:], typ)
+		c = append(c, payload...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+	}
+	ihdrEnd := 8 + 25
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk("eXIf", tiff)...)
+	out = append(out, chunk("tEXt", []byte("Comment\x00shot at home"))...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestExtractFixture(t *testing.T) {
+	ex, err := metadata.Extract(jpegWithExif(t), "jpeg")
+	require.NoError(t, err)
+	require.NotNil(t, ex)
+	assert.True(t, ex.HasGPS)
+	assert.True(t, ex.Has(tagBodySerialNumber))
+	assert.Equal(t, 6, ex.Orientation)
+	assert.Equal(t, "(c) Alice Photo", ex.Copyright)
+}
+
+func TestExtractCorrupt(t *testing.T) {
+	_, err := metadata.Extract(jpegWithTIFF(t, corruptTIFF()), "jpeg")
+	assert.ErrorIs(t, err, metadata.ErrCorruptExif)
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   func(t *testing.T) []byte
+		policy models.MetadataPolicy
+		want   metadata.Tags
+	}{
+		{
+			name:   "jpeg strip everything",
+			format: "jpeg",
+			data:   jpegWithExif,
+			want:   metadata.Tags{},
+		},
+		{
+			name:   "jpeg keep copyright and orientation",
+			format: "jpeg",
+			data:   jpegWithExif,
+			policy: models.MetadataPolicy{KeepCopyright: true, KeepOrientation: true},
+			want:   metadata.Tags{Orientation: 6, Copyright: "(c) Alice Photo"},
+		},
+		{
+			name:   "png strip everything",
+			format: "png",
+			data:   pngWithExif,
+			want:   metadata.Tags{},
+		},
+		{
+			name:   "png keep orientation",
+			format: "png",
+			data:   pngWithExif,
+			policy: models.MetadataPolicy{KeepOrientation: true},
+			want:   metadata.Tags{Orientation: 6},
+		},
+		{
+			name:   "jpeg with corrupt exif",
+			format: "jpeg",
+			data:   func(t *testing.T) []byte { return jpegWithTIFF(t, corruptTIFF()) },
+			policy: models.MetadataPolicy{KeepCopyright: true, KeepOrientation: true},
+			want:   metadata.Tags{},
+		},
+		{
+			name:   "png with bad tiff magic",
+			format: "png",
+			data:   func(t *testing.T) []byte { return pngWithTIFF(t, []byte("II\x00\x00\x08\x00\x00\x00")) },
+			policy: models.MetadataPolicy{KeepOrientation: true},
+			want:   metadata.Tags{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, kept, err := metadata.Strip(tt.data(t), tt.format, tt.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, kept)
+
+			ex, err := metadata.Extract(out, tt.format)
+			require.NoError(t, err)
+			if tt.want == (metadata.Tags{}) {
+				assert.Nil(t, ex)
+			} else {
+				require.NotNil(t, ex)
+				assert.False(t, ex.HasGPS)
+				assert.False(t, ex.Has(tagGPSIFD))
+				assert.False(t, ex.Has(tagMake))
+				assert.False(t, ex.Has(tagBodySerialNumber))
+				assert.Equal(t, tt.want.Orientation, ex.Orientation)
+				assert.Equal(t, tt.want.Copyright, ex.Copyright)
+			}
+
+			assert.NotContains(t, string(out), "SN12345678")
+			assert.NotContains(t, string(out), "xmpmeta")
+			assert.NotContains(t, string(out), "shot at home")
+
+			_, format, err := image.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/signing"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -22,14 +24,51 @@ type Tokens struct {
 	ExpAcc   time.Time
 	ExpRef   time.Time
 	UserID   string
+	Role     string
 	Issuer   string
 	Audience string
+	// Family is shared by every token pair rotated from the same login.
+	Family string
 }
 
-func IssueTokens(userID string) (*Tokens, error) {
+// Claims are the JWT claims PixelForge signs. Family is empty on tokens
+// issued before refresh rotation, Type on tokens signed with the HS256
+// secrets, and Role for users with the default role.
+type Claims struct {
+	jwt.RegisteredClaims
+	Family string `json:"fam,omitempty"`
+	Type   string `json:"typ,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+const (
+	typeAccess  = "access"
+	typeRefresh = "refresh"
+)
+
+// keySet signs new tokens once UseKeySet is called. Until then tokens are
+// signed with ACCESS_SECRET and REFRESH_SECRET.
+var keySet atomic.Pointer[signing.KeySet]
+
+// UseKeySet switches token signing to ks. Tokens signed with the HS256
+// secrets are still accepted while those secrets are set, so logins made
+// before the switch survive until they expire.
+func UseKeySet(ks *signing.KeySet) {
+	keySet.Store(ks)
+}
+
+// IssueTokens mints the token pair for a fresh login, starting a new family.
+func IssueTokens(userID, role string) (*Tokens, error) {
+	return ReissueTokens(userID, role, uuid.NewString())
+}
+
+// ReissueTokens mints a token pair that continues family, for rotation.
+func ReissueTokens(userID, role, family string) (*Tokens, error) {
 	now := time.Now().UTC()
 	t := &Tokens{
 		UserID:   userID,
+		Role:     role,
+		Family:   family,
 		JTIAcc:   uuid.NewString(),
 		JTIRef:   uuid.NewString(),
 		ExpAcc:   now.Add(15 * time.Minute),
@@ -38,30 +77,49 @@ func IssueTokens(userID string) (*Tokens, error) {
 		Audience: "PixelForge-client",
 	}
 
-	acc := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   userID,
-		ID:        t.JTIAcc,
-		Issuer:    t.Issuer,
-		Audience:  jwt.ClaimStrings{t.Audience},
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(t.ExpAcc),
-	})
+	acc := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        t.JTIAcc,
+			Issuer:    t.Issuer,
+			Audience:  jwt.ClaimStrings{t.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(t.ExpAcc),
+		},
+		Family: family,
+		Role:   role,
+	}
 
-	ref := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   userID,
-		ID:        t.JTIRef,
-		Issuer:    t.Issuer,
-		Audience:  jwt.ClaimStrings{t.Audience},
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(t.ExpRef),
-	})
+	ref := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        t.JTIRef,
+			Issuer:    t.Issuer,
+			Audience:  jwt.ClaimStrings{t.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(t.ExpRef),
+		},
+		Family: family,
+		Role:   role,
+	}
 
 	var err error
-	t.Access, err = acc.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	if ks := keySet.Load(); ks != nil {
+		acc.Type, ref.Type = typeAccess, typeRefresh
+		if t.Access, err = ks.Sign(acc); err != nil {
+			return nil, err
+		}
+		if t.Refresh, err = ks.Sign(ref); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	t.Access, err = jwt.NewWithClaims(jwt.SigningMethodHS256, acc).SignedString([]byte(os.Getenv("ACCESS_SECRET")))
 	if err != nil {
 		return nil, err
 	}
-	t.Refresh, err = ref.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
+	t.Refresh, err = jwt.NewWithClaims(jwt.SigningMethodHS256, ref).SignedString([]byte(os.Getenv("REFRESH_SECRET")))
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +133,7 @@ func Persist(ctx context.Context, r *config.Redis, t *Tokens) error {
 	if err := r.SetJTI(ctx, "refresh:"+t.JTIRef, t.UserID, t.ExpRef); err != nil {
 		return err
 	}
-	return nil
+	return r.AddToFamily(ctx, t.Family, t.ExpRef, "access:"+t.JTIAcc, "refresh:"+t.JTIRef, "rotated:"+t.JTIRef)
 }
 
 func SetAuthCookies(c *gin.Context, t *Tokens) {
@@ -90,38 +148,50 @@ func ClearAuthCookies(c *gin.Context) {
 	c.SetCookie("refresh_token", "", -1, "/", "", true, true)
 }
 
-func ParseAccess(tokenStr string) (*jwt.RegisteredClaims, error) {
-	secret := os.Getenv("ACCESS_SECRET")
-	return parseWithSecret(tokenStr, secret)
+func ParseAccess(tokenStr string) (*Claims, error) {
+	return parse(tokenStr, typeAccess, os.Getenv("ACCESS_SECRET"))
 }
 
-func ParseRefresh(tokenStr string) (*jwt.RegisteredClaims, error) {
-	secret := os.Getenv("REFRESH_SECRET")
-	return parseWithSecret(tokenStr, secret)
+func ParseRefresh(tokenStr string) (*Claims, error) {
+	return parse(tokenStr, typeRefresh, os.Getenv("REFRESH_SECRET"))
 }
 
-func parseWithSecret(tokenStr, secret string) (*jwt.RegisteredClaims, error) {
-	if secret == "" {
+// parse verifies a token signed by the key set, or one signed with secret
+// when that is set. Only key-set tokens carry a type, so an access token
+// cannot be passed off as a refresh token or the other way round; the two
+// HS256 secrets keep that apart for the old tokens.
+func parse(tokenStr, typ, secret string) (*Claims, error) {
+	ks := keySet.Load()
+	methods := []string{}
+	if ks != nil {
+		methods = append(methods, signing.Methods...)
+	}
+	if secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if len(methods) == 0 {
 		return nil, errors.New("jwt secret not configured")
 	}
 
-	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	parser := jwt.NewParser(jwt.WithValidMethods(methods))
 
-	token, err := parser.ParseWithClaims(tokenStr, &jwt.RegisteredClaims{}, func(t *jwt.Token) (interface{}, error) {
-		// Extra safety: ensure HMAC family
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+	token, err := parser.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return []byte(secret), nil
 		}
-		return []byte(secret), nil
+		return ks.Keyfunc(t)
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	if _, hmac := token.Method.(*jwt.SigningMethodHMAC); !hmac && claims.Type != typ {
+		return nil, errors.New("invalid token")
+	}
 
 	if claims.ExpiresAt != nil && time.Now().After(claims.ExpiresAt.Time) {
 		return nil, errors.New("token expired")
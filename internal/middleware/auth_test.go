@@ -1,29 +1,40 @@
 package middleware_test
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
-	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/apikey"
+	"github.com/HarshithRajesh/PixelForge/internal/config"
 	"github.com/HarshithRajesh/PixelForge/internal/middleware"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/internal/signing"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // helper to setup a test router with middleware and a dummy protected route
-func setupMiddlewareRouter() *gin.Engine {
+func setupMiddlewareRouter(rds *config.Redis) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 
 	protected := r.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(rds))
 	{
 		// dummy protected route just returns 200 if middleware passes
 		protected.GET("/profile", func(c *gin.Context) {
-			email := c.MustGet("email").(string)
+			userID := c.MustGet("userID").(string)
 			c.JSON(http.StatusOK, gin.H{
-				"email": email,
+				"user_id": userID,
 			})
 		})
 	}
@@ -32,8 +43,26 @@ func setupMiddlewareRouter() *gin.Engine {
 }
 
 func TestAuthMiddleware(t *testing.T) {
+	t.Setenv("ACCESS_SECRET", "test-access-secret")
+	t.Setenv("REFRESH_SECRET", "test-refresh-secret")
+	mr := miniredis.RunT(t)
+	rds := &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
 	// generate a valid token to use in tests
-	validToken, _ := domain.GenerateToken("alice@example.com")
+	tokens, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	require.NoError(t, middleware.Persist(context.Background(), rds, tokens))
+	require.NoError(t, rds.SaveSession(context.Background(), &models.Session{ID: tokens.Family, UserID: "1"}, tokens.ExpRef))
+	validToken := tokens.Access
+
+	// stored tokens whose session was revoked or never recorded
+	sessionless, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	require.NoError(t, middleware.Persist(context.Background(), rds, sessionless))
+
+	// a correctly signed token whose jti was never stored, as after logout
+	revoked, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
 
 	tests := []struct {
 		name           string
@@ -65,19 +94,35 @@ func TestAuthMiddleware(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   nil,
 		},
-		// {
-		// 	name: "valid token in header",
-		// 	setupRequest: func(req *http.Request) {
-		// 		req.Header.Set("Authorization", "Bearer "+validToken)
-		// 	},
-		// 	expectedStatus: http.StatusOK,
-		// 	expectedBody:   nil,
-		// },
+		{
+			name: "valid token in header",
+			setupRequest: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+validToken)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   nil,
+		},
+		{
+			name: "revoked token",
+			setupRequest: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+revoked.Access)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   nil,
+		},
+		{
+			name: "revoked session",
+			setupRequest: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+sessionless.Access)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   nil,
+		},
 		{
 			name: "valid token in cookie",
 			setupRequest: func(req *http.Request) {
 				req.AddCookie(&http.Cookie{
-					Name:  "token",
+					Name:  "access_token",
 					Value: validToken,
 				})
 			},
@@ -88,7 +133,7 @@ func TestAuthMiddleware(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			router := setupMiddlewareRouter()
+			router := setupMiddlewareRouter(rds)
 
 			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
 			tt.setupRequest(req) // set up headers or cookies for this test
@@ -100,3 +145,241 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+// memoryKeys is a SigningKeyRepository over a slice.
+type memoryKeys []*models.SigningKey
+
+func (m *memoryKeys) ListSigningKeys(ctx context.Context, now time.Time) ([]*models.SigningKey, error) {
+	return *m, nil
+}
+
+func (m *memoryKeys) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	*m = append(*m, key)
+	return nil
+}
+
+func (m *memoryKeys) DeleteExpiredSigningKeys(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func (m *memoryKeys) RewrapSigningKey(ctx context.Context, key *models.SigningKey, oldKeyID string) error {
+	return nil
+}
+
+func TestKeySetTokens(t *testing.T) {
+	t.Setenv("ACCESS_SECRET", "test-access-secret")
+	t.Setenv("REFRESH_SECRET", "test-refresh-secret")
+	legacy, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+
+	ks := signing.NewKeySet(&memoryKeys{}, config.Signing{Alg: "EdDSA", RotateEvery: time.Hour, TokenLifetime: time.Hour}, config.Encryption{})
+	require.NoError(t, ks.Rotate(context.Background()))
+	middleware.UseKeySet(ks)
+	t.Cleanup(func() { middleware.UseKeySet(nil) })
+
+	tokens, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	claims, err := middleware.ParseAccess(tokens.Access)
+	require.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject)
+	_, err = middleware.ParseRefresh(tokens.Refresh)
+	require.NoError(t, err)
+
+	_, err = middleware.ParseAccess(tokens.Refresh)
+	assert.Error(t, err, "a refresh token is not an access token")
+	_, err = middleware.ParseRefresh(tokens.Access)
+	assert.Error(t, err, "an access token is not a refresh token")
+
+	_, err = middleware.ParseAccess(legacy.Access)
+	assert.NoError(t, err, "HS256 tokens stay valid while the secret is set")
+	t.Setenv("ACCESS_SECRET", "")
+	_, err = middleware.ParseAccess(legacy.Access)
+	assert.Error(t, err)
+	_, err = middleware.ParseAccess(tokens.Access)
+	assert.NoError(t, err)
+}
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	args := m.Called(userID, req)
+	return args.Get(0).(*models.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockAPIKeyService) List(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) Revoke(ctx context.Context, userID string, keyID uint) error {
+	return m.Called(userID, keyID).Error(0)
+}
+
+func (m *MockAPIKeyService) Authenticate(ctx context.Context, key, ip string) (*models.APIKey, error) {
+	args := m.Called(key, ip)
+	apiKey, _ := args.Get(0).(*models.APIKey)
+	return apiKey, args.Error(1)
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	t.Setenv("ACCESS_SECRET", "test-access-secret")
+	t.Setenv("REFRESH_SECRET", "test-refresh-secret")
+	mr := miniredis.RunT(t)
+	rds := &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+	tokens, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	require.NoError(t, middleware.Persist(context.Background(), rds, tokens))
+	require.NoError(t, rds.SaveSession(context.Background(), &models.Session{ID: tokens.Family, UserID: "1"}, tokens.ExpRef))
+
+	keys := new(MockAPIKeyService)
+	keys.On("Authenticate", "pf_read_secret", "192.0.2.1").Return(&models.APIKey{UserID: 2, Scopes: []string{models.ScopeImagesRead}}, nil)
+	keys.On("Authenticate", "pf_far_secret", "192.0.2.1").Return(nil, apikey.ErrIPNotAllowed)
+	keys.On("Authenticate", "pf_bad_secret", "192.0.2.1").Return(nil, apikey.ErrInvalidKey)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	scoped := r.Group("/")
+	scoped.Use(middleware.APIKeyMiddleware(rds, keys))
+	{
+		ok := func(c *gin.Context) { c.String(http.StatusOK, c.MustGet("userID").(string)) }
+		scoped.GET("/images", middleware.RequireScope(models.ScopeImagesRead), ok)
+		scoped.POST("/transform/1", middleware.RequireScope(models.ScopeTransform), ok)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		header   string
+		value    string
+		wantCode int
+		wantUser string
+	}{
+		{"key with scope", http.MethodGet, "/images", "Authorization", "Bearer pf_read_secret", http.StatusOK, "2"},
+		{"key in X-API-Key", http.MethodGet, "/images", "X-API-Key", "pf_read_secret", http.StatusOK, "2"},
+		{"key without scope", http.MethodPost, "/transform/1", "X-API-Key", "pf_read_secret", http.StatusForbidden, ""},
+		{"key from another address", http.MethodGet, "/images", "X-API-Key", "pf_far_secret", http.StatusForbidden, ""},
+		{"unknown key", http.MethodGet, "/images", "X-API-Key", "pf_bad_secret", http.StatusUnauthorized, ""},
+		{"login session has every scope", http.MethodPost, "/transform/1", "Authorization", "Bearer " + tokens.Access, http.StatusOK, "1"},
+		{"nothing", http.MethodGet, "/images", "", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantUser != "" {
+				assert.Equal(t, tt.wantUser, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddlewareClientIP(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+	// The key is only allowed from 192.0.2.1.
+	keys := new(MockAPIKeyService)
+	keys.On("Authenticate", "pf_secret", "192.0.2.1").Return(&models.APIKey{UserID: 2, Scopes: []string{models.ScopeImagesRead}}, nil)
+	keys.On("Authenticate", "pf_secret", mock.Anything).Return(nil, apikey.ErrIPNotAllowed)
+
+	tests := []struct {
+		name     string
+		proxies  string
+		peer     string
+		wantCode int
+	}{
+		{"spoofed header", "", "203.0.113.9:1234", http.StatusForbidden},
+		{"spoofed header from an untrusted proxy", "10.0.0.0/8", "203.0.113.9:1234", http.StatusForbidden},
+		{"header from a trusted proxy", "10.0.0.0/8", "10.0.0.5:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			require.NoError(t, r.SetTrustedProxies(config.LoadTrustedProxies()))
+			r.GET("/images", middleware.APIKeyMiddleware(rds, keys), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/images", nil)
+			req.RemoteAddr = tt.peer
+			req.Header.Set("X-API-Key", "pf_secret")
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+// supportRole is a RoleRepository with one custom role.
+type supportRole struct{ repository.RoleRepository }
+
+func (supportRole) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	if name == "support" {
+		return &models.Role{Name: name, Permissions: []string{models.PermUsersRead}}, nil
+	}
+	return nil, nil
+}
+
+func TestRoles(t *testing.T) {
+	t.Setenv("ACCESS_SECRET", "test-access-secret")
+	t.Setenv("REFRESH_SECRET", "test-refresh-secret")
+	mr := miniredis.RunT(t)
+	rds := &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	login := func(userID, role string) string {
+		tokens, err := middleware.IssueTokens(userID, role)
+		require.NoError(t, err)
+		require.NoError(t, middleware.Persist(context.Background(), rds, tokens))
+		require.NoError(t, rds.SaveSession(context.Background(), &models.Session{ID: tokens.Family, UserID: userID}, tokens.ExpRef))
+		return "Bearer " + tokens.Access
+	}
+	admin, support, user := login("1", models.RoleAdmin), login("2", "support"), login("3", "")
+
+	keys := new(MockAPIKeyService)
+	keys.On("Authenticate", "pf_key_secret", "192.0.2.1").Return(&models.APIKey{UserID: 1, Scopes: models.Scopes}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	roles := rbac.NewRoles(supportRole{})
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/users", middleware.AuthMiddleware(rds), middleware.RequirePermission(roles, models.PermUsersRead), ok)
+	r.POST("/users/2/suspend", middleware.AuthMiddleware(rds), middleware.RequirePermission(roles, models.PermUsersManage), ok)
+	r.PUT("/users/2/role", middleware.AuthMiddleware(rds), middleware.RequireRole(models.RoleAdmin), ok)
+	r.GET("/keyed", middleware.APIKeyMiddleware(rds, keys), middleware.RequirePermission(roles, models.PermUsersRead), ok)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		auth     string
+		wantCode int
+	}{
+		{"admin has every permission", http.MethodPost, "/users/2/suspend", admin, http.StatusOK},
+		{"admin role", http.MethodPut, "/users/2/role", admin, http.StatusOK},
+		{"custom role with the permission", http.MethodGet, "/users", support, http.StatusOK},
+		{"custom role without the permission", http.MethodPost, "/users/2/suspend", support, http.StatusForbidden},
+		{"custom role is not admin", http.MethodPut, "/users/2/role", support, http.StatusForbidden},
+		{"default role", http.MethodGet, "/users", user, http.StatusForbidden},
+		{"API keys carry no role", http.MethodGet, "/keyed", "Bearer pf_key_secret", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Authorization", tt.auth)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
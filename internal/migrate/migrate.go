@@ -0,0 +1,231 @@
+// Package migrate copies stored files from one storage backend to another.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+// pageSize is how many image rows are read from the database at a time.
+const pageSize = 500
+
+type Options struct {
+	// Workers is how many files are copied at once.
+	Workers int
+	// DryRun lists the files that would be copied without copying them.
+	DryRun bool
+}
+
+// Failure is a file that could not be migrated. Its rows stay on the old
+// backend, so running the migration again retries it.
+type Failure struct {
+	UserID uint   `json:"user_id"`
+	Path   string `json:"path"`
+	Error  string `json:"error"`
+}
+
+type Report struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dry_run"`
+	Images int    `json:"images"`
+	Files  int    `json:"files"`
+	// Copied counts files copied and switched over; Present those that were
+	// already on the destination from an interrupted run.
+	Copied  int       `json:"copied"`
+	Present int       `json:"present"`
+	Bytes   int64     `json:"bytes"`
+	Failed  []Failure `json:"failed"`
+}
+
+// Migrator copies every file that image rows on the source backend point
+// at. Each file is read back from the destination and checked before its
+// rows are switched over, and the source is never modified, so an
+// interrupted migration can simply be run again.
+type Migrator struct {
+	repo     repository.MigrationRepository
+	from, to storage.StorageRepository
+	opts     Options
+}
+
+func New(repo repository.MigrationRepository, from, to storage.StorageRepository, opts Options) (*Migrator, error) {
+	if storage.BackendOf(from) == "" || storage.BackendOf(to) == "" {
+		return nil, errors.New("both backends must be named")
+	}
+	if storage.BackendOf(from) == storage.BackendOf(to) {
+		return nil, fmt.Errorf("nothing to migrate from %q to itself", storage.BackendOf(from))
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	return &Migrator{repo: repo, from: from, to: to, opts: opts}, nil
+}
+
+// file is one stored file; images sharing a blob share a file.
+type file struct {
+	userID   uint
+	path     string
+	checksum string
+}
+
+type result struct {
+	file    file
+	present bool
+	size    int64
+	err     error
+}
+
+func (m *Migrator) Run(ctx context.Context) (*Report, error) {
+	report := &Report{
+		From:   storage.BackendOf(m.from),
+		To:     storage.BackendOf(m.to),
+		DryRun: m.opts.DryRun,
+		Failed: []Failure{},
+	}
+
+	jobs := make(chan file)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for n := 0; n < m.opts.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				results <- m.migrate(ctx, f)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		for res := range results {
+			report.add(res)
+		}
+		close(done)
+	}()
+
+	err := m.feed(ctx, jobs, report)
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// feed pages through the rows still on the source and queues each file
+// once. Rows switched over while paging drop out of later pages.
+func (m *Migrator) feed(ctx context.Context, jobs chan<- file, report *Report) error {
+	seen := map[file]bool{}
+	var afterID uint
+	for {
+		images, err := m.repo.ListImagesOn(ctx, report.From, afterID, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list images: %w", err)
+		}
+		if len(images) == 0 {
+			return nil
+		}
+		for _, img := range images {
+			afterID = img.ID
+			report.Images++
+			f := file{userID: img.UserID, path: img.Path}
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			report.Files++
+			f.checksum = img.Checksum
+			select {
+			case jobs <- f:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (r *Report) add(res result) {
+	switch {
+	case res.err != nil:
+		r.Failed = append(r.Failed, Failure{UserID: res.file.userID, Path: res.file.path, Error: res.err.Error()})
+	case res.present:
+		r.Present++
+	default:
+		r.Copied++
+		r.Bytes += res.size
+	}
+}
+
+func (m *Migrator) migrate(ctx context.Context, f file) result {
+	res := result{file: f}
+	if m.opts.DryRun {
+		info, err := m.from.Stat(ctx, f.path, strconv.FormatUint(uint64(f.userID), 10))
+		res.size, res.err = info.Size, err
+		return res
+	}
+	res.present, res.size, res.err = m.copy(ctx, f)
+	if res.err == nil {
+		res.err = m.repo.SetBackend(ctx, f.userID, f.path, storage.BackendOf(m.from), storage.BackendOf(m.to))
+	}
+	return res
+}
+
+// copy copies one file and verifies the copy. A file missing on the source
+// counts as done if an intact copy is already on the destination.
+func (m *Migrator) copy(ctx context.Context, f file) (present bool, size int64, err error) {
+	userID := strconv.FormatUint(uint64(f.userID), 10)
+	src, err := m.from.Open(ctx, f.path, userID)
+	if errors.Is(err, storage.ErrNotExist) && f.checksum != "" {
+		if verr := verify(ctx, m.to, f.path, userID, f.checksum); verr == nil {
+			return true, 0, nil
+		}
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to open the source: %w", err)
+	}
+	counter := &countingReader{r: src}
+	checksum, err := storage.Put(ctx, m.to, f.path, userID, counter)
+	src.Close()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to copy: %w", err)
+	}
+	if f.checksum != "" && checksum != f.checksum {
+		m.to.Delete(ctx, f.path, userID)
+		return false, 0, fmt.Errorf("%w: the source does not match the recorded checksum", storage.ErrChecksumMismatch)
+	}
+	if err := verify(ctx, m.to, f.path, userID, checksum); err != nil {
+		return false, 0, fmt.Errorf("failed to verify the copy: %w", err)
+	}
+	return false, counter.n, nil
+}
+
+// verify reads the file back in full against checksum.
+func verify(ctx context.Context, store storage.StorageRepository, path, userID, checksum string) error {
+	r, err := storage.OpenVerified(ctx, store, path, userID, checksum)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,119 @@
+package migrate_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/migrate"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockMigrationRepository struct {
+	mock.Mock
+}
+
+func (m *MockMigrationRepository) ListImagesOn(ctx context.Context, backend string, afterID uint, limit int) ([]*models.Image, error) {
+	args := m.Called(backend, afterID)
+	return args.Get(0).([]*models.Image), args.Error(1)
+}
+
+func (m *MockMigrationRepository) SetBackend(ctx context.Context, userID uint, path, from, to string) error {
+	return m.Called(userID, path, from, to).Error(0)
+}
+
+// named gives a local store another backend name, so two directories can
+// stand in for two backends.
+type named struct {
+	storage.StorageRepository
+	name string
+}
+
+func (n named) Backend() string { return n.name }
+
+func image(id uint, path, content string) *models.Image {
+	img := &models.Image{UserID: 1, Path: path, Checksum: storage.Checksum([]byte(content))}
+	img.ID = id
+	return img
+}
+
+func write(t *testing.T, root, path, content string) {
+	full := filepath.Join(root, "1", path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+	require.NoError(t, os.WriteFile(full, []byte(content), 0o644))
+}
+
+func TestMigrate(t *testing.T) {
+	srcRoot, dstRoot := t.TempDir(), t.TempDir()
+	write(t, srcRoot, "blobs/aa/shared", "shared")
+	write(t, srcRoot, "legacy.png", "legacy")
+	write(t, srcRoot, "blobs/bb/rotten", "rotten!")
+	write(t, dstRoot, "blobs/cc/moved", "moved")
+
+	repo := new(MockMigrationRepository)
+	repo.On("ListImagesOn", "local", uint(0)).Return([]*models.Image{
+		image(1, "blobs/aa/shared", "shared"),
+		image(2, "blobs/aa/shared", "shared"),
+		image(3, "legacy.png", "legacy"),
+		image(4, "blobs/bb/rotten", "rotten"),
+		image(5, "blobs/cc/moved", "moved"),
+	}, nil)
+	repo.On("ListImagesOn", "local", uint(5)).Return([]*models.Image{}, nil)
+	repo.On("SetBackend", uint(1), "blobs/aa/shared", "local", "s3").Return(nil).Once()
+	repo.On("SetBackend", uint(1), "legacy.png", "local", "s3").Return(nil).Once()
+	repo.On("SetBackend", uint(1), "blobs/cc/moved", "local", "s3").Return(nil).Once()
+
+	src := storage.NewStorageRepository(srcRoot)
+	dst := named{storage.NewStorageRepository(dstRoot), "s3"}
+	m, err := migrate.New(repo, src, dst, migrate.Options{Workers: 3})
+	require.NoError(t, err)
+	report, err := m.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, report.Images)
+	assert.Equal(t, 4, report.Files)
+	assert.Equal(t, 2, report.Copied)
+	assert.Equal(t, 1, report.Present, "copied by an earlier, interrupted run")
+	assert.Equal(t, int64(len("shared")+len("legacy")), report.Bytes)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, "blobs/bb/rotten", report.Failed[0].Path)
+	assert.Contains(t, report.Failed[0].Error, "checksum mismatch")
+
+	data, err := os.ReadFile(filepath.Join(dstRoot, "1", "blobs/aa/shared"))
+	require.NoError(t, err)
+	assert.Equal(t, "shared", string(data))
+	_, err = os.Stat(filepath.Join(dstRoot, "1", "blobs/bb/rotten"))
+	assert.True(t, os.IsNotExist(err), "a bad copy is not left behind")
+	_, err = os.Stat(filepath.Join(srcRoot, "1", "legacy.png"))
+	assert.NoError(t, err, "the source is left alone")
+	repo.AssertExpectations(t)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	srcRoot, dstRoot := t.TempDir(), t.TempDir()
+	write(t, srcRoot, "a.png", "a")
+
+	repo := new(MockMigrationRepository)
+	repo.On("ListImagesOn", "local", uint(0)).Return([]*models.Image{image(1, "a.png", "a")}, nil)
+	repo.On("ListImagesOn", "local", uint(1)).Return([]*models.Image{}, nil)
+
+	m, err := migrate.New(repo, storage.NewStorageRepository(srcRoot), named{storage.NewStorageRepository(dstRoot), "s3"}, migrate.Options{DryRun: true})
+	require.NoError(t, err)
+	report, err := m.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	entries, _ := os.ReadDir(dstRoot)
+	assert.Empty(t, entries)
+	repo.AssertNotCalled(t, "SetBackend", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMigrateRejectsSameBackend(t *testing.T) {
+	store := storage.NewStorageRepository(t.TempDir())
+	_, err := migrate.New(new(MockMigrationRepository), store, store, migrate.Options{})
+	assert.EqualError(t, err, `nothing to migrate from "local" to itself`)
+}
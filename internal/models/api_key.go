@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes an API key can be granted. A login session has all of them.
+const (
+	ScopeImagesRead  = "images:read"
+	ScopeImagesWrite = "images:write"
+	ScopeTransform   = "transform"
+)
+
+var Scopes = []string{ScopeImagesRead, ScopeImagesWrite, ScopeTransform}
+
+// APIKey is a long-lived credential for scripts and CI. The secret is shown
+// once when the key is created; only its SHA-256 is stored. Prefix is the
+// public part of the key, used to find it and to tell keys apart.
+type APIKey struct {
+	gorm.Model
+	UserID uint     `gorm:"column:user_id;index" json:"-"`
+	Name   string   `gorm:"column:name" json:"name"`
+	Prefix string   `gorm:"column:prefix;uniqueIndex;size:16" json:"prefix"`
+	Hash   string   `gorm:"column:hash;size:64" json:"-"`
+	Scopes []string `gorm:"column:scopes;type:jsonb;serializer:json" json:"scopes"`
+	// AllowedIPs holds addresses and CIDR ranges; empty allows any.
+	AllowedIPs []string   `gorm:"column:allowed_ips;type:jsonb;serializer:json" json:"allowed_ips"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Audit actions. For admin.* actions UserID is the admin who acted.
+const (
+	AuditLoginLockout    = "login.lockout"
+	AuditIdentityLinked  = "identity.link"
+	AuditAdminListUsers  = "admin.users.list"
+	AuditAdminViewImages = "admin.images.view"
+	AuditAdminSuspend    = "admin.user.suspend"
+	AuditAdminUnsuspend  = "admin.user.unsuspend"
+	AuditAdminSetRole    = "admin.user.role"
+)
+
+// AuditLog records a security-relevant event. UserID is the account it
+// concerns, when there is one; Target names what was acted on.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UserID    *uint     `gorm:"column:user_id;index" json:"user_id,omitempty"`
+	Action    string    `gorm:"column:action;size:64;index" json:"action"`
+	Target    string    `gorm:"column:target" json:"target"`
+	IP        string    `gorm:"column:ip;size:64" json:"ip"`
+	Detail    string    `gorm:"column:detail" json:"detail"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Blob is one stored file, addressed by the SHA-256 of its bytes. Images
+// whose Hash matches share the blob, and RefCount tracks how many image rows
+// point at it. Blobs are scoped per user, like the storage layout.
+type Blob struct {
+	UserID    uint   `gorm:"primaryKey;autoIncrement:false;column:user_id"`
+	Hash      string `gorm:"primaryKey;size:64;column:hash"`
+	Path      string `gorm:"column:path;not null"`
+	Backend   string `gorm:"column:backend;size:20"`
+	Size      uint64 `gorm:"column:size"`
+	Checksum  string `gorm:"column:checksum;size:71"`
+	RefCount  int    `gorm:"column:ref_count;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
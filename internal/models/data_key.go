@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// DataKey is a user's AES-256 data key, encrypted ("wrapped") with the
+// master key KeyID names. Rotating the master key re-wraps these rows; the
+// files encrypted with the data key are left alone.
+type DataKey struct {
+	UserID     uint   `gorm:"primaryKey;autoIncrement:false;column:user_id"`
+	KeyID      string `gorm:"column:key_id;not null;index"`
+	WrappedKey []byte `gorm:"column:wrapped_key;not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ExternalIdentity links an account at an OpenID Connect provider, named by
+// its issuer and subject, to a PixelForge user.
+type ExternalIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"column:user_id;index"`
+	Issuer    string `gorm:"column:issuer;uniqueIndex:idx_external_identity"`
+	Subject   string `gorm:"column:subject;uniqueIndex:idx_external_identity"`
+	Email     string `gorm:"column:email"`
+	CreatedAt time.Time
+}
+
+// ExternalLogin is a sign-in vouched for by a provider.
+type ExternalLogin struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Client        Client
+}
@@ -9,15 +9,35 @@ type Image struct {
 	UserID         uint   `gorm:"column:user_id"`
 	StoredFilename string `gorm:"column:stored_filename"`
 	Path           string `gorm:"column:path"`
-	Size           uint64 `gorm:"column:size"`
-	MimeType       string `gorm:"column:mime_type"`
-	Width          int    `gorm:"column:width"`
-	Height         int    `gorm:"column:height"`
+	// Backend is the storage backend holding the file. Rows from before it
+	// was recorded leave it empty.
+	Backend     string `gorm:"column:backend;size:20"`
+	Hash        string `gorm:"column:hash;size:64;index"`
+	Checksum    string `gorm:"column:checksum;size:71"`
+	Size        uint64 `gorm:"column:size"`
+	MimeType    string `gorm:"column:mime_type"`
+	Width       int    `gorm:"column:width"`
+	Height      int    `gorm:"column:height"`
+	Orientation int    `gorm:"column:orientation"`
+	Copyright   string `gorm:"column:copyright"`
+	Status      string `gorm:"column:status"`
 }
 
+// Image statuses set by the storage scrub. An empty status means the stored
+// file was last seen intact.
+const (
+	ImageStatusMissing = "missing"
+	ImageStatusCorrupt = "corrupt"
+)
+
 type TransformRequest struct {
 	Operation string
 	Params    map[string]int
+	// Options carries the non-numeric arguments of an operation, such as
+	// the watermark position.
+	Options map[string]string
+	// Metadata overrides the user's default metadata policy for this output.
+	Metadata *MetadataPolicy
 }
 
 type URIParam struct {
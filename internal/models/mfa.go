@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use stand-in for a TOTP code, for a user who lost
+// their authenticator. Only its bcrypt hash is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"column:user_id;index"`
+	Hash      string `gorm:"column:hash"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// TOTPEnrollment is what an authenticator app needs to add the account. URI
+// is the otpauth:// payload to show as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFAChallenge stands in for tokens when a login needs a second factor.
+type MFAChallenge struct {
+	Challenge string    `json:"mfa_challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MFALogin completes a login with a TOTP code or a recovery code.
+type MFALogin struct {
+	Challenge string `json:"mfa_challenge"`
+	Code      string `json:"code"`
+	// Client is filled in by the handler, never from the request body.
+	Client Client `json:"-"`
+}
+
+type MFACode struct {
+	Code string `json:"code"`
+}
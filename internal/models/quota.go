@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Quota limits what a user may store. A zero field means no limit.
+type Quota struct {
+	MaxBytes  int64 `gorm:"column:max_bytes" json:"max_bytes"`
+	MaxImages int64 `gorm:"column:max_images" json:"max_images"`
+}
+
+// DefaultPlan applies to users without a plan of their own. Without a row
+// for it, such users are unlimited.
+const DefaultPlan = "default"
+
+// Plan is a named quota that users are assigned to.
+type Plan struct {
+	Name      string `gorm:"primaryKey;size:50;column:name" json:"name"`
+	Quota     Quota  `gorm:"embedded" json:"quota"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Usage is what a user currently stores, counted per image row. Images that
+// share a blob each count in full, the same way they count against a quota.
+type Usage struct {
+	UserID    uint  `gorm:"primaryKey;autoIncrement:false;column:user_id"`
+	Bytes     int64 `gorm:"column:bytes;not null"`
+	Images    int64 `gorm:"column:images;not null"`
+	UpdatedAt time.Time
+}
+
+// UsageReport is a user's usage next to the quota that applies to them.
+type UsageReport struct {
+	Plan   string `json:"plan"`
+	Bytes  int64  `json:"bytes"`
+	Images int64  `json:"images"`
+	Quota  Quota  `json:"quota"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Built-in roles. Users without a role have RoleUser. Other roles are
+// defined in the roles table.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions a role can grant. RoleAdmin has all of them, RoleUser none.
+const (
+	PermUsersRead   = "users:read"      // list users
+	PermUsersManage = "users:manage"    // suspend and unsuspend users
+	PermImagesRead  = "images:read_any" // view any user's images
+)
+
+var Permissions = []string{PermUsersRead, PermUsersManage, PermImagesRead}
+
+// Role is a custom role: a name for a set of permissions.
+type Role struct {
+	Name        string   `gorm:"primaryKey;size:50;column:name" json:"name"`
+	Permissions []string `gorm:"column:permissions;type:jsonb;serializer:json" json:"permissions"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AdminUser is what admins see of an account.
+type AdminUser struct {
+	ID            uint       `json:"id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	Plan          string     `json:"plan"`
+	EmailVerified bool       `json:"email_verified"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	SuspendedAt   *time.Time `json:"suspended_at"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
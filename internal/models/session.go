@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is one login of a user, kept alive by refreshing it. Its ID is the
+// token family every access and refresh token of the login carries.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Current marks the session the listing request was made with.
+	Current bool `json:"current"`
+}
+
+// Client describes where a login or refresh request came from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
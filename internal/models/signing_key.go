@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SigningKey is one JWT signing key. The newest key whose ActivatesAt has
+// passed signs new tokens; every key not yet past ExpiresAt verifies them
+// and is published in the JWKS. PrivateKey is wrapped with the master key
+// KeyID names, like a DataKey; an empty KeyID means it is stored in the
+// clear because encryption was not configured when it was written.
+type SigningKey struct {
+	KID         string    `gorm:"primaryKey;size:64;column:kid"`
+	Alg         string    `gorm:"size:10;not null;column:alg"`
+	PrivateKey  []byte    `gorm:"not null;column:private_key"` // PKCS #8, DER
+	KeyID       string    `gorm:"size:64;not null;default:'';column:key_id"`
+	ActivatesAt time.Time `gorm:"not null;column:activates_at"`
+	ExpiresAt   time.Time `gorm:"not null;index;column:expires_at"`
+	CreatedAt   time.Time
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Template is a user's saved composite layout, e.g. an Open Graph card.
+type Template struct {
+	gorm.Model
+	UserID     uint               `gorm:"column:user_id;index"`
+	Name       string             `gorm:"column:name"`
+	Definition TemplateDefinition `gorm:"column:definition;type:jsonb;serializer:json"`
+}
+
+type TemplateDefinition struct {
+	Width      int                `json:"width"`
+	Height     int                `json:"height"`
+	Background TemplateBackground `json:"background"`
+	Layers     []TemplateLayer    `json:"layers"`
+}
+
+// TemplateBackground fills the canvas with Color, then covers it with the
+// image ImageID when one is set.
+type TemplateBackground struct {
+	Color   string `json:"color"`
+	ImageID uint   `json:"image_id"`
+}
+
+// TemplateLayer is one element drawn onto the canvas, in order. Type selects
+// which of the remaining fields apply:
+//
+//	image:        ImageID, Fit, Mask, Radius
+//	text:         Text (with {{placeholders}}), Font, Size, Color, Stroke,
+//	              StrokeColor, ShadowX, ShadowY, ShadowColor, Align,
+//	              Rotation, LineHeight
+//	rect:         Fill, Radius
+//	ellipse:      Fill
+type TemplateLayer struct {
+	Type   string `json:"type"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	ImageID uint   `json:"image_id,omitempty"`
+	Fit     string `json:"fit,omitempty"`
+	Mask    string `json:"mask,omitempty"`
+	Radius  int    `json:"radius,omitempty"`
+
+	Text        string `json:"text,omitempty"`
+	Font        string `json:"font,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	Color       string `json:"color,omitempty"`
+	Stroke      int    `json:"stroke,omitempty"`
+	StrokeColor string `json:"stroke_color,omitempty"`
+	ShadowX     int    `json:"shadow_x,omitempty"`
+	ShadowY     int    `json:"shadow_y,omitempty"`
+	ShadowColor string `json:"shadow_color,omitempty"`
+	Align       string `json:"align,omitempty"`
+	Rotation    int    `json:"rotation,omitempty"`
+	LineHeight  int    `json:"line_height,omitempty"`
+
+	Fill string `json:"fill,omitempty"`
+}
+
+type RenderTemplateRequest struct {
+	Variables map[string]string `json:"variables"`
+}
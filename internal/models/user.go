@@ -1,15 +1,66 @@
 // Package models is used to define user models
 package models
 
+import "time"
+
 type User struct {
-	ID              uint   `gorm:"primaryKey;autoIncrement"`
-	Name            string `gorm:"size:100;not null"`
-	Email           string `gorm:"unique;not null"`
-	Password        string `gorm:"not null"`
-	ConfirmPassword string `gorm:"not null"`
+	ID              uint           `gorm:"primaryKey;autoIncrement"`
+	Name            string         `gorm:"size:100;not null"`
+	Email           string         `gorm:"unique;not null"`
+	Password        string         `gorm:"not null"`
+	ConfirmPassword string         `gorm:"not null"`
+	Metadata        MetadataPolicy `gorm:"embedded;embeddedPrefix:metadata_"`
+	// Plan names the user's quota plan; empty means DefaultPlan. The quota
+	// fields override single limits of the plan when set.
+	Plan           string `gorm:"size:50" json:"-"`
+	QuotaMaxBytes  *int64 `gorm:"column:quota_max_bytes" json:"-"`
+	QuotaMaxImages *int64 `gorm:"column:quota_max_images" json:"-"`
+	// EmailVerifiedAt is when the user proved they own Email; nil until then.
+	// Only MarkEmailVerified sets it.
+	EmailVerifiedAt *time.Time `gorm:"column:email_verified_at" json:"-"`
+	// TOTPSecret is set on enrollment; TOTPEnabledAt once the user proved
+	// their app has it, and from then on logins ask for a code.
+	TOTPSecret    string     `gorm:"column:totp_secret"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at"`
+	// Role decides what else the user may do; empty means RoleUser.
+	Role string `gorm:"size:50" json:"-"`
+	// SuspendedAt is set while an admin has locked the account.
+	SuspendedAt *time.Time `gorm:"column:suspended_at" json:"-"`
+}
+
+// SignUp is what a client may choose about a new account; everything else
+// on User is set by the server.
+type SignUp struct {
+	Name            string
+	Email           string
+	Password        string
+	ConfirmPassword string
+}
+
+// MetadataPolicy controls which embedded image metadata survives stripping.
+// GPS, device serials and everything else are always removed.
+type MetadataPolicy struct {
+	KeepCopyright   bool `gorm:"column:keep_copyright" json:"keep_copyright"`
+	KeepOrientation bool `gorm:"column:keep_orientation" json:"keep_orientation"`
 }
 
 type Login struct {
 	Email    string
 	Password string
+	// Client is filled in by the handler, never from the request body.
+	Client Client `json:"-"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token           string `json:"token"`
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+type VerifyEmail struct {
+	Token string `json:"token"`
 }
@@ -0,0 +1,207 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockProvider is an in-process OpenID Connect provider that signs in
+// everyone as subject "alice".
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+	// issuer is what discovery and ID tokens claim, the server URL unless
+	// a test overrides it.
+	issuer string
+	// claims may change the ID token before it is signed.
+	claims func(jwt.MapClaims)
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockProvider{key: key, kid: "k1", codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": "k1",
+			"n": b64(m.key.N.Bytes()),
+			"e": b64(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	// authorize approves at once and redirects back with a code.
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := rand.Text()
+		m.mu.Lock()
+		m.codes[code] = q
+		m.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostFormValue("code")]
+		delete(m.codes, r.PostFormValue("code"))
+		m.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("client_secret") != "secret" ||
+			r.PostFormValue("redirect_uri") != auth.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":            m.issuer,
+			"sub":            "alice",
+			"aud":            auth.Get("client_id"),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          auth.Get("nonce"),
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+		if m.claims != nil {
+			m.claims(claims)
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = m.kid
+		signed, err := token.SignedString(m.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.Server = httptest.NewServer(mux)
+	m.issuer = m.URL
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) config() config.OIDC {
+	return config.OIDC{
+		Issuer:       m.URL,
+		ClientID:     "pixelforge",
+		ClientSecret: "secret",
+		RedirectURL:  "https://pixelforge.test/auth/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// signIn follows AuthURL to the provider and returns the code it
+// redirects back with.
+func signIn(t *testing.T, p *oidc.Provider, f *oidc.Flow) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(p.AuthURL(f))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	back, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, f.State, back.Query().Get("state"))
+	return back.Query().Get("code")
+}
+
+func TestDiscover(t *testing.T) {
+	ctx := context.Background()
+	m := newMockProvider(t)
+	p, err := oidc.Discover(ctx, m.config(), m.Client())
+	require.NoError(t, err)
+	assert.Equal(t, m.URL, p.Issuer())
+
+	m.issuer = "https://elsewhere.example.com"
+	_, err = oidc.Discover(ctx, m.config(), m.Client())
+	assert.ErrorContains(t, err, "issuer")
+
+	cfg := m.config()
+	cfg.RedirectURL = ""
+	_, err = oidc.Discover(ctx, cfg, m.Client())
+	assert.Error(t, err)
+}
+
+func TestExchange(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		claims  func(jwt.MapClaims)
+		kid     string
+		tamper  func(*oidc.Flow)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "wrong nonce", tamper: func(f *oidc.Flow) { f.Nonce = "other" }, wantErr: true},
+		{name: "wrong verifier", tamper: func(f *oidc.Flow) { f.Verifier = "other" }, wantErr: true},
+		{name: "another audience", claims: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, wantErr: true},
+		{name: "several audiences without azp", claims: func(c jwt.MapClaims) { c["aud"] = []string{"pixelforge", "someone-else"} }, wantErr: true},
+		{name: "several audiences naming us", claims: func(c jwt.MapClaims) {
+			c["aud"] = []string{"pixelforge", "someone-else"}
+			c["azp"] = "pixelforge"
+		}},
+		{name: "another issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://elsewhere.example.com" }, wantErr: true},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: true},
+		{name: "no expiry", claims: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{name: "unknown key", kid: "k2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.claims
+			if tt.kid != "" {
+				m.kid = tt.kid
+			}
+			p, err := oidc.Discover(ctx, m.config(), m.Client())
+			require.NoError(t, err)
+			flow, err := oidc.NewFlow()
+			require.NoError(t, err)
+			code := signIn(t, p, flow)
+			if tt.tamper != nil {
+				tt.tamper(flow)
+			}
+
+			claims, err := p.Exchange(ctx, code, flow)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Subject)
+			assert.Equal(t, "alice@example.com", claims.Email)
+			assert.True(t, claims.EmailVerified)
+
+			_, err = p.Exchange(ctx, code, flow)
+			assert.Error(t, err, "codes are single-use")
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"path"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+// blobPath is where a new blob with the given hash is stored in a user's
+// storage. The two-character fan-out keeps any one directory small. The
+// random suffix gives every blob its own file, so deleting the file of a
+// blob that lost its last reference never removes a file stored again for
+// the same content in the meantime.
+func blobPath(hash string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return path.Join("blobs", hash[:2], hash+"-"+hex.EncodeToString(suffix)), nil
+}
+
+// saveImage stores data as img's content and saves img, which needs
+// everything but its Hash, Path, Backend and Checksum filled in. Content the
+// user already has is not written again: img takes a reference on the
+// existing blob and duplicate is true.
+func saveImage(ctx context.Context, repo repository.UserRepository, store storage.StorageRepository, userID string, data []byte, img *models.Image) (bool, error) {
+	sum := sha256.Sum256(data)
+	img.Hash = hex.EncodeToString(sum[:])
+
+	existing, err := repo.GetBlob(ctx, img.UserID, img.Hash)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		// With no Path, SaveImageDB takes the reference under the blob's
+		// row lock and fills in where it lives, or reports that the last
+		// image using it was deleted since GetBlob.
+		err := repo.SaveImageDB(ctx, img)
+		if !errors.Is(err, repository.ErrBlobGone) {
+			return err == nil, err
+		}
+	}
+
+	img.Path, err = blobPath(img.Hash)
+	if err != nil {
+		return false, err
+	}
+	img.Backend = storage.BackendOf(store)
+	img.Checksum, err = storage.Put(ctx, store, img.Path, userID, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	written := img.Path
+	if err := repo.SaveImageDB(ctx, img); err != nil {
+		// Nothing refers to the file, typically because the image went over
+		// quota; without this it would stay in storage uncounted.
+		if err := store.Delete(ctx, written, userID); err != nil {
+			log.Printf("deleting unsaved blob %s: %v", written, err)
+		}
+		return false, err
+	}
+	if img.Path != written {
+		// A concurrent save of the same content created the blob first and
+		// img shares that one, so the file written here is unused.
+		if err := store.Delete(ctx, written, userID); err != nil {
+			log.Printf("deleting unused blob %s: %v", written, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+// decodeStored streams a stored image through the decoders registered in
+// this package, verifying the bytes against the checksum recorded when they
+// were written.
+func decodeStored(ctx context.Context, store storage.StorageRepository, record *models.Image, userID string) (image.Image, string, error) {
+	r, err := storage.OpenVerified(ctx, store, record.Path, userID, record.Checksum)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	img, format, decodeErr := image.Decode(r)
+	// Decoders stop at the end of the image data; read the rest so the
+	// checksum covers the whole file. A mismatch explains a decode error
+	// better than the decoder can.
+	if _, err := io.Copy(io.Discard, r); errors.Is(err, storage.ErrChecksumMismatch) {
+		return nil, "", fmt.Errorf("image %d: %w", record.ID, err)
+	}
+	if decodeErr != nil {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		return nil, "", fmt.Errorf("Format error in decoding %w", decodeErr)
+	}
+	return img, format, nil
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+// The Go font family ships as TTF bytes compiled into the binary, so text
+// rendering needs no system fonts.
+var fontFiles = map[string][]byte{
+	"sans":             goregular.TTF,
+	"sans-medium":      gomedium.TTF,
+	"sans-bold":        gobold.TTF,
+	"sans-italic":      goitalic.TTF,
+	"sans-bold-italic": gobolditalic.TTF,
+	"mono":             gomono.TTF,
+	"mono-bold":        gomonobold.TTF,
+}
+
+var (
+	fontMu    sync.Mutex
+	fontCache = map[string]*opentype.Font{}
+)
+
+func loadFont(family string) (*opentype.Font, error) {
+	if family == "" {
+		family = "sans"
+	}
+	fontMu.Lock()
+	defer fontMu.Unlock()
+
+	if f, ok := fontCache[family]; ok {
+		return f, nil
+	}
+	ttf, ok := fontFiles[family]
+	if !ok {
+		return nil, fmt.Errorf("unknown font family %q", family)
+	}
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	fontCache[family] = f
+	return f, nil
+}
@@ -12,47 +12,64 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/metadata"
 	"github.com/HarshithRajesh/PixelForge/internal/models"
 	"github.com/HarshithRajesh/PixelForge/internal/repository"
 	"github.com/HarshithRajesh/PixelForge/storage"
-	"github.com/google/uuid"
 )
 
 type ImageManagement interface {
-	UploadImage(ctx context.Context, header *multipart.FileHeader, userID string) error
-	ListImages(userID uint) ([]*models.Image, error)
-	Transform(imageID *models.URIParam, userID string, req *models.TransformRequest) error
+	UploadImage(ctx context.Context, header *multipart.FileHeader, userID string, policy *models.MetadataPolicy) (*models.Image, bool, error)
+	ListImages(ctx context.Context, userID uint) ([]*models.Image, error)
+	Transform(ctx context.Context, imageID *models.URIParam, userID string, req *models.TransformRequest) error
+	DeleteImage(ctx context.Context, imageID *models.URIParam, userID string) error
+	Usage(ctx context.Context, userID string) (*models.UsageReport, error)
+	SetMetadataPolicy(ctx context.Context, userID string, policy *models.MetadataPolicy) error
+	ProcessingStatus() SchedulerStats
 }
 
 type imageManagement struct {
 	repo        repository.UserRepository
 	storageRepo storage.StorageRepository
 	processor   ImageTransformation
+	limits      config.ImageLimits
+	scheduler   *Scheduler
+	timeouts    config.Timeouts
 }
 
-func NewImageManagement(userRepo repository.UserRepository, store storage.StorageRepository, processor ImageTransformation) ImageManagement {
+func NewImageManagement(userRepo repository.UserRepository, store storage.StorageRepository, processor ImageTransformation, limits config.ImageLimits, scheduler *Scheduler, timeouts config.Timeouts) ImageManagement {
 	return &imageManagement{
 		repo:        userRepo,
 		storageRepo: store,
 		processor:   processor,
+		limits:      limits,
+		scheduler:   scheduler,
+		timeouts:    timeouts,
 	}
 }
 
-func (i *imageManagement) UploadImage(ctx context.Context, header *multipart.FileHeader, userID string) error {
+// UploadImage stores the upload and records it. The returned flag reports
+// that the user already had a file with identical content, which the new
+// image now shares.
+func (i *imageManagement) UploadImage(ctx context.Context, header *multipart.FileHeader, userID string, policy *models.MetadataPolicy) (*models.Image, bool, error) {
+	ctx, cancel := withTimeout(ctx, i.timeouts.Upload)
+	defer cancel()
+
 	file, err := header.Open()
 	if err != nil {
-		return errors.New("failed to open the file")
+		return nil, false, errors.New("failed to open the file")
 	}
 	defer file.Close()
 
 	buffer := make([]byte, 512)
 	_, err = file.Read(buffer)
 	if err != nil {
-		return errors.New("Failed to read the contentType")
+		return nil, false, errors.New("Failed to read the contentType")
 	}
 
 	contentType := http.DetectContentType(buffer)
@@ -62,79 +79,141 @@ func (i *imageManagement) UploadImage(ctx context.Context, header *multipart.Fil
 	}
 
 	if !allowedTypes[contentType] {
-		return errors.New("Wrong image type")
+		return nil, false, errors.New("Wrong image type")
 	}
 
 	file.Seek(0, io.SeekStart)
 
-	ext := filepath.Ext(header.Filename)
-	newID := uuid.New().String()
-
 	if header.Size > 5*1024*1024 {
-		return errors.New("file too large, reduce the size of the image and upload")
+		return nil, false, ErrFileTooLarge
 	}
 
-	storagePath := fmt.Sprintf("%s%s", newID, ext)
-
-	fmt.Print(header.Filename)
-	fmt.Print("Image recieved")
-	err = i.storageRepo.Save(storagePath, userID, header)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		return err
+		return nil, false, errors.New("failed to read the file")
 	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return err
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrUndecodableImage, err)
+	}
+	if err := checkDimensions(imgConfig.Width, imgConfig.Height, i.limits); err != nil {
+		return nil, false, err
 	}
-	imgConfig, _, err := image.DecodeConfig(file)
+	if policy == nil {
+		policy, err = i.userPolicy(ctx, userID)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	data, kept, err := metadata.Strip(data, strings.TrimPrefix(contentType, "image/"), *policy)
 	if err != nil {
-		return errors.New("Failed to decode the image config")
+		return nil, false, errors.New("Failed to strip the image metadata")
 	}
+
+	if err := checkQuota(ctx, i.repo, userID, len(data)); err != nil {
+		return nil, false, err
+	}
+
+	fmt.Print(header.Filename)
+	fmt.Print("Image recieved")
 	newuserID, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
-		return errors.New("failed to convert userid from string to int")
+		return nil, false, errors.New("failed to convert userid from string to int")
 	}
 	imgMetadata := &models.Image{
 		UserID:         uint(newuserID),
 		StoredFilename: header.Filename,
-		Path:           storagePath,
-		Size:           uint64(header.Size), // Convert int64 to uint64
+		Size:           uint64(len(data)),
 		MimeType:       header.Header.Get("Content-Type"),
 		Width:          imgConfig.Width,
 		Height:         imgConfig.Height,
+		Orientation:    kept.Orientation,
+		Copyright:      kept.Copyright,
 	}
-	err = i.repo.SaveImageDB(imgMetadata)
+	duplicate, err := saveImage(ctx, i.repo, i.storageRepo, userID, data, imgMetadata)
 	if err != nil {
-		return errors.New("Failed to save the image in Database")
+		if errors.Is(err, repository.ErrQuotaExceeded) {
+			return nil, false, err
+		}
+		return nil, false, errors.New("Failed to save the image in Database")
 	}
-	return nil
+	return imgMetadata, duplicate, nil
 }
 
-func (i *imageManagement) ListImages(userID uint) ([]*models.Image, error) {
-	return i.repo.GetAllImageData(userID)
+func (i *imageManagement) ListImages(ctx context.Context, userID uint) ([]*models.Image, error) {
+	return i.repo.GetAllImageData(ctx, userID)
 }
 
-func (i *imageManagement) Transform(imageID *models.URIParam, userID string, req *models.TransformRequest) error {
+func (i *imageManagement) Transform(ctx context.Context, imageID *models.URIParam, userID string, req *models.TransformRequest) error {
+	ctx, cancel := withTimeout(ctx, i.timeouts.Transform)
+	defer cancel()
+
 	var image *models.Image
-	image, err := i.repo.GetImage(imageID.ID, userID)
+	image, err := i.repo.GetImage(ctx, imageID.ID, userID)
 	if err != nil {
 		return err
 	}
 	fmt.Println(image)
-	img, format, err := i.storageRepo.Read(image.Path, userID)
+	targetNeed, err := i.probe(ctx, image.Path, userID)
 	if err != nil {
 		return err
 	}
-	fmt.Println("Image found")
-	dataTransformed, err := i.processor.Process(req, img, format)
+	need := targetNeed
+	if req.Operation == "resize" {
+		if err := checkDimensions(req.Params["width"], req.Params["height"], i.limits); err != nil {
+			return err
+		}
+		need += EstimateMemory(req.Params["width"], req.Params["height"])
+	}
+	var logo *models.Image
+	if req.Operation == "watermark" {
+		logo, err = i.watermarkImage(ctx, req, userID)
+		if err != nil {
+			return err
+		}
+		logoNeed, err := i.probe(ctx, logo.Path, userID)
+		if err != nil {
+			return err
+		}
+		// The resized mark fits inside the target and is drawn onto a
+		// layer of the target's size.
+		need += logoNeed + targetNeed
+	}
+
+	release, err := i.scheduler.Acquire(ctx, need)
 	if err != nil {
 		return err
 	}
-	newFilename := fmt.Sprintf("transformed_%d_%s", time.Now().Unix(), image.StoredFilename)
-	newPath := filepath.Join(filepath.Dir(image.Path), newFilename)
-	err = i.storageRepo.SaveTransformedImage(userID, newPath, dataTransformed)
+	defer release()
+
+	img, format, err := decodeStored(ctx, i.storageRepo, image, userID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Image found")
+	policy := req.Metadata
+	if policy == nil {
+		policy, err = i.userPolicy(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	kept := metadata.Tags{Orientation: image.Orientation, Copyright: image.Copyright}.Filter(*policy)
+	src := &Source{Image: img, Format: format, Meta: kept}
+	if logo != nil {
+		src.Overlay, _, err = decodeStored(ctx, i.storageRepo, logo, userID)
+		if err != nil {
+			return err
+		}
+	}
+	dataTransformed, err := i.processor.Process(ctx, req, src)
 	if err != nil {
 		return err
 	}
+	if err := checkQuota(ctx, i.repo, userID, len(dataTransformed)); err != nil {
+		return err
+	}
+	newFilename := fmt.Sprintf("transformed_%d_%s", time.Now().Unix(), image.StoredFilename)
 	newuserID, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
 		return errors.New("failed to convert userid from string to int")
@@ -143,18 +222,78 @@ func (i *imageManagement) Transform(imageID *models.URIParam, userID string, req
 	imgMetadata := &models.Image{
 		UserID:         uint(newuserID),
 		StoredFilename: newFilename,
-		Path:           newPath,
 		Size:           uint64(len(dataTransformed)), // Convert int64 to uint64
 		MimeType:       image.MimeType,
 		Width:          w,
 		Height:         h,
+		Orientation:    kept.Orientation,
+		Copyright:      kept.Copyright,
 	}
 
-	err = i.repo.SaveImageDB(imgMetadata)
+	_, err = saveImage(ctx, i.repo, i.storageRepo, userID, dataTransformed, imgMetadata)
+	return err
+}
+
+// DeleteImage removes the image and deletes its file once no other image of
+// the user shares the same content.
+func (i *imageManagement) DeleteImage(ctx context.Context, imageID *models.URIParam, userID string) error {
+	orphan, err := i.repo.DeleteImage(ctx, imageID.ID, userID)
 	if err != nil {
 		return err
 	}
-	return nil
+	if orphan == "" {
+		return nil
+	}
+	return i.storageRepo.Delete(ctx, orphan, userID)
+}
+
+// Usage returns what the user stores and the quota that applies to them.
+func (i *imageManagement) Usage(ctx context.Context, userID string) (*models.UsageReport, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, errors.New("failed to convert userid from string to int")
+	}
+	return i.repo.GetUsage(ctx, uint(uid))
+}
+
+// watermarkImage looks up the watermark logo. GetImage is scoped to userID,
+// so callers can only watermark with images they own.
+func (i *imageManagement) watermarkImage(ctx context.Context, req *models.TransformRequest, userID string) (*models.Image, error) {
+	logoID, ok := req.Params["image_id"]
+	if !ok {
+		return nil, errors.New("watermark image_id is required")
+	}
+	logo, err := i.repo.GetImage(ctx, strconv.Itoa(logoID), userID)
+	if err != nil {
+		return nil, errors.New("watermark image not found")
+	}
+	return logo, nil
+}
+
+// probe checks a stored image's header against the limits and returns the
+// memory its decode will need.
+func (i *imageManagement) probe(ctx context.Context, path, userID string) (int64, error) {
+	cfg, err := probeStored(ctx, i.storageRepo, i.limits, path, userID)
+	if err != nil {
+		return 0, err
+	}
+	return EstimateMemory(cfg.Width, cfg.Height), nil
+}
+
+func (i *imageManagement) ProcessingStatus() SchedulerStats {
+	return i.scheduler.Stats()
+}
+
+func (i *imageManagement) SetMetadataPolicy(ctx context.Context, userID string, policy *models.MetadataPolicy) error {
+	return i.repo.UpdateMetadataPolicy(ctx, userID, *policy)
+}
+
+func (i *imageManagement) userPolicy(ctx context.Context, userID string) (*models.MetadataPolicy, error) {
+	user, err := i.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, errors.New("failed to load the metadata policy")
+	}
+	return &user.Metadata, nil
 }
 
 func (i *imageManagement) GetImageDimensions(data []byte) (int, int, error) {
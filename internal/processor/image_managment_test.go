@@ -0,0 +1,406 @@
+package processor_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// bombPNG is a valid tiny PNG whose IHDR is rewritten to declare
+// width×height pixels, like a decompression bomb's header.
+func bombPNG(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(1, 1, color.White)))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func fileHeader(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="`+name+`"`)
+	h.Set("Content-Type", "image/png")
+	part, err := w.CreatePart(h)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(int64(len(data)) + 1024)
+	require.NoError(t, err)
+	return form.File["file"][0]
+}
+
+var testLimits = config.ImageLimits{MaxWidth: 4000, MaxHeight: 4000, MaxMegapixels: 4}
+
+func TestUploadImageRejectsPixelBombs(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint32
+		policy        *models.MetadataPolicy
+	}{
+		{name: "too wide", width: 50000, height: 10},
+		{name: "too tall", width: 10, height: 50000},
+		{name: "too many megapixels", width: 3000, height: 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			svc := processor.NewImageManagement(new(MockUserRepository), storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+			_, _, err := svc.UploadImage(context.Background(), fileHeader(t, "bomb.png", bombPNG(t, tt.width, tt.height)), "1", &models.MetadataPolicy{})
+			assert.ErrorIs(t, err, processor.ErrImageTooLarge)
+
+			entries, _ := os.ReadDir(root)
+			assert.Empty(t, entries, "nothing may be stored")
+		})
+	}
+}
+
+func TestTransformChecksHeaderBeforeDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  []byte
+		req     *models.TransformRequest
+		wantErr error
+	}{
+		{
+			name:    "stored image declares too many pixels",
+			stored:  bombPNG(t, 60000, 60000),
+			req:     &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 10, "height": 10}},
+			wantErr: processor.ErrImageTooLarge,
+		},
+		{
+			name:    "resize target exceeds limits",
+			stored:  bombPNG(t, 1, 1),
+			req:     &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 50000, "height": 50000}},
+			wantErr: processor.ErrImageTooLarge,
+		},
+		{
+			name:    "stored file is not an image",
+			stored:  []byte("definitely not an image"),
+			req:     &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 10, "height": 10}},
+			wantErr: processor.ErrUndecodableImage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "1"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(root, "1", "a.png"), tt.stored, 0o644))
+
+			users := new(MockUserRepository)
+			users.On("GetImage", "5", "1").Return(&models.Image{Path: "a.png"}, nil)
+			svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+			err := svc.Transform(context.Background(), &models.URIParam{ID: "5"}, "1", tt.req)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTransformStopsWhenContextDone(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name: "client gone",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "deadline passed",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "1"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(root, "1", "a.png"), buf.Bytes(), 0o644))
+
+			users := new(MockUserRepository)
+			users.On("GetImage", "5", "1").Return(&models.Image{Path: "a.png"}, nil).Maybe()
+			svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			req := &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 4, "height": 4}}
+			err := svc.Transform(ctx, &models.URIParam{ID: "5"}, "1", req)
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			entries, _ := os.ReadDir(filepath.Join(root, "1"))
+			assert.Len(t, entries, 1, "no output may be stored")
+			users.AssertNotCalled(t, "SaveImageDB", mock.Anything)
+		})
+	}
+}
+
+// blobTable stands in for the blobs table. SaveImageDB takes references the
+// way the repository does; lookup, when set, replaces what GetBlob sees to
+// stage a concurrent save or delete, and saveErr fails every save.
+type blobTable struct {
+	*MockUserRepository
+	blobs   map[string]*models.Blob
+	lookup  func(hash string) *models.Blob
+	saveErr error
+}
+
+func newBlobTable() *blobTable {
+	users := new(MockUserRepository)
+	users.On("GetUsage", uint(1)).Return(&models.UsageReport{}, nil)
+	return &blobTable{MockUserRepository: users, blobs: map[string]*models.Blob{}}
+}
+
+func (b *blobTable) GetBlob(ctx context.Context, userID uint, hash string) (*models.Blob, error) {
+	if b.lookup != nil {
+		return b.lookup(hash), nil
+	}
+	return b.blobs[hash], nil
+}
+
+func (b *blobTable) SaveImageDB(ctx context.Context, img *models.Image) error {
+	if b.saveErr != nil {
+		return b.saveErr
+	}
+	blob, ok := b.blobs[img.Hash]
+	switch {
+	case ok:
+		blob.RefCount++
+		img.Path, img.Backend, img.Checksum = blob.Path, blob.Backend, blob.Checksum
+	case img.Path == "":
+		return repository.ErrBlobGone
+	default:
+		b.blobs[img.Hash] = &models.Blob{UserID: img.UserID, Hash: img.Hash, Path: img.Path, Checksum: img.Checksum, RefCount: 1}
+	}
+	return nil
+}
+
+func TestUploadImageDeduplicates(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+	data := buf.Bytes()
+
+	root := t.TempDir()
+	users := newBlobTable()
+	svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+	first, duplicate, err := svc.UploadImage(context.Background(), fileHeader(t, "a.png", data), "1", &models.MetadataPolicy{})
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.Len(t, first.Hash, 64)
+	assert.True(t, strings.HasPrefix(first.Path, filepath.Join("blobs", first.Hash[:2], first.Hash)), first.Path)
+	stored, err := os.ReadFile(filepath.Join(root, "1", first.Path))
+	require.NoError(t, err)
+	assert.Equal(t, storage.Checksum(stored), first.Checksum)
+
+	second, duplicate, err := svc.UploadImage(context.Background(), fileHeader(t, "copy-of-a.png", data), "1", &models.MetadataPolicy{})
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+	assert.Equal(t, first.Path, second.Path)
+	assert.Equal(t, first.Checksum, second.Checksum)
+	assert.Equal(t, "copy-of-a.png", second.StoredFilename)
+	assert.Equal(t, 2, users.blobs[first.Hash].RefCount)
+
+	entries, err := os.ReadDir(filepath.Join(root, "1", "blobs", first.Hash[:2]))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "both uploads share one file")
+}
+
+func TestUploadImageBlobRaces(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+	data := buf.Bytes()
+
+	t.Run("blob deleted after lookup", func(t *testing.T) {
+		root := t.TempDir()
+		users := newBlobTable()
+		users.lookup = func(hash string) *models.Blob {
+			return &models.Blob{UserID: 1, Hash: hash, Path: "blobs/gone", RefCount: 1}
+		}
+		svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+		img, duplicate, err := svc.UploadImage(context.Background(), fileHeader(t, "a.png", data), "1", &models.MetadataPolicy{})
+		require.NoError(t, err)
+		assert.False(t, duplicate)
+		assert.NotEqual(t, "blobs/gone", img.Path)
+		_, err = os.Stat(filepath.Join(root, "1", img.Path))
+		assert.NoError(t, err, "the content is stored again")
+		assert.Equal(t, 1, users.blobs[img.Hash].RefCount)
+	})
+
+	t.Run("blob created after lookup", func(t *testing.T) {
+		root := t.TempDir()
+		users := newBlobTable()
+		users.lookup = func(hash string) *models.Blob {
+			users.blobs[hash] = &models.Blob{UserID: 1, Hash: hash, Path: "blobs/theirs", Checksum: "sha256:theirs", RefCount: 1}
+			return nil
+		}
+		svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+		img, duplicate, err := svc.UploadImage(context.Background(), fileHeader(t, "a.png", data), "1", &models.MetadataPolicy{})
+		require.NoError(t, err)
+		assert.True(t, duplicate)
+		assert.Equal(t, "blobs/theirs", img.Path)
+		assert.Equal(t, 2, users.blobs[img.Hash].RefCount)
+		entries, err := os.ReadDir(filepath.Join(root, "1", "blobs", img.Hash[:2]))
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the unused copy is deleted")
+	})
+}
+
+func TestUploadImageEnforcesQuota(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+	data := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		usage   models.UsageReport
+		wantErr string
+	}{
+		{name: "unlimited", usage: models.UsageReport{Bytes: 1 << 40, Images: 1e6}},
+		{name: "fits", usage: models.UsageReport{Bytes: 100, Images: 1, Quota: models.Quota{MaxBytes: 1 << 20, MaxImages: 2}}},
+		{
+			name:    "too many images",
+			usage:   models.UsageReport{Images: 2, Quota: models.Quota{MaxImages: 2}},
+			wantErr: "storage quota exceeded: 2 of 2 images stored",
+		},
+		{
+			name:    "too many bytes",
+			usage:   models.UsageReport{Bytes: 1<<20 - 10, Quota: models.Quota{MaxBytes: 1 << 20}},
+			wantErr: "storage quota exceeded: 1024.0 KiB of 1.0 MiB used, this image needs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			users := new(MockUserRepository)
+			users.On("GetUsage", uint(1)).Return(&tt.usage, nil)
+			if tt.wantErr == "" {
+				users.On("GetBlob", uint(1), mock.Anything).Return(nil, nil)
+				users.On("SaveImageDB", mock.Anything).Return(nil)
+			}
+			svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+			_, _, err := svc.UploadImage(context.Background(), fileHeader(t, "a.png", data), "1", &models.MetadataPolicy{})
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, repository.ErrQuotaExceeded)
+			assert.ErrorContains(t, err, tt.wantErr)
+			_, statErr := os.Stat(filepath.Join(root, "1"))
+			assert.True(t, os.IsNotExist(statErr), "nothing may be stored over quota")
+			users.AssertNotCalled(t, "SaveImageDB", mock.Anything)
+		})
+	}
+}
+
+func TestUploadImageOverQuotaAtSave(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+
+	// checkQuota passed, but a concurrent upload used up the quota before
+	// SaveImageDB charged this one.
+	root := t.TempDir()
+	users := newBlobTable()
+	users.saveErr = fmt.Errorf("%w: 2 of 2 images stored", repository.ErrQuotaExceeded)
+	svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+	_, _, err := svc.UploadImage(context.Background(), fileHeader(t, "a.png", buf.Bytes()), "1", &models.MetadataPolicy{})
+	assert.ErrorIs(t, err, repository.ErrQuotaExceeded)
+	blobs, err := filepath.Glob(filepath.Join(root, "1", "blobs", "*", "*"))
+	require.NoError(t, err)
+	assert.Empty(t, blobs, "the stored file is deleted again")
+}
+
+func TestDeleteImageRemovesUnreferencedBlobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		orphan   string
+		wantFile bool
+	}{
+		{name: "last reference", orphan: "blobs/ab/abc", wantFile: false},
+		{name: "still shared", orphan: "", wantFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			stored := filepath.Join(root, "1", "blobs", "ab", "abc")
+			require.NoError(t, os.MkdirAll(filepath.Dir(stored), 0o755))
+			require.NoError(t, os.WriteFile(stored, []byte("x"), 0o644))
+
+			users := new(MockUserRepository)
+			users.On("DeleteImage", "5", "1").Return(tt.orphan, nil)
+			svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+			require.NoError(t, svc.DeleteImage(context.Background(), &models.URIParam{ID: "5"}, "1"))
+			_, err := os.Stat(stored)
+			assert.Equal(t, tt.wantFile, err == nil)
+			users.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTransformReportsCorruptStoredFile(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, solidImage(8, 8, color.White)))
+	original := buf.Bytes()
+	corrupt := append([]byte{}, original...)
+	corrupt[len(corrupt)-20] ^= 0xff
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "1"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "1", "a.png"), corrupt, 0o644))
+
+	users := new(MockUserRepository)
+	users.On("GetImage", "5", "1").Return(&models.Image{Path: "a.png", Checksum: storage.Checksum(original)}, nil)
+	svc := processor.NewImageManagement(users, storage.NewStorageRepository(root), processor.NewImageTransformation(), testLimits, testScheduler(), config.Timeouts{})
+
+	req := &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 4, "height": 4}}
+	err := svc.Transform(context.Background(), &models.URIParam{ID: "5"}, "1", req)
+	assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+	users.AssertNotCalled(t, "SaveImageDB", mock.Anything)
+}
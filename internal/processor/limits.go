@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+var (
+	// ErrFileTooLarge means the upload is over the per-file byte limit.
+	ErrFileTooLarge = errors.New("file too large, reduce the size of the image and upload")
+	// ErrImageTooLarge means the image declares more pixels than allowed.
+	ErrImageTooLarge = errors.New("image dimensions exceed the allowed limits")
+	// ErrUndecodableImage means the image header could not be read.
+	ErrUndecodableImage = errors.New("image could not be decoded")
+)
+
+func checkDimensions(width, height int, limits config.ImageLimits) error {
+	if width > limits.MaxWidth || height > limits.MaxHeight {
+		return fmt.Errorf("%w: %dx%d is larger than %dx%d", ErrImageTooLarge, width, height, limits.MaxWidth, limits.MaxHeight)
+	}
+	if mp := float64(width) * float64(height) / 1e6; mp > limits.MaxMegapixels {
+		return fmt.Errorf("%w: %.1f megapixels is more than %.1f", ErrImageTooLarge, mp, limits.MaxMegapixels)
+	}
+	return nil
+}
+
+// probeStored reads only the header of a stored image and refuses it when it
+// declares more pixels than limits allow, so a small file cannot expand into
+// gigabytes during the full decode.
+func probeStored(ctx context.Context, store storage.StorageRepository, limits config.ImageLimits, path, userID string) (image.Config, error) {
+	r, err := store.Open(ctx, path, userID)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer r.Close()
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return image.Config{}, ctx.Err()
+		}
+		return image.Config{}, fmt.Errorf("%w: %v", ErrUndecodableImage, err)
+	}
+	if err := checkDimensions(cfg.Width, cfg.Height, limits); err != nil {
+		return image.Config{}, err
+	}
+	return cfg, nil
+}
+
+// withTimeout bounds ctx by d. A zero timeout leaves ctx unbounded, so a
+// zero-value config.Timeouts never cancels work outright.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+// checkQuota refuses a new image of size bytes early, before anything is
+// written to storage. SaveImageDB checks again under a lock, so this is only
+// a fast path and a race between two uploads is still caught there.
+func checkQuota(ctx context.Context, repo repository.UserRepository, userID string, size int) error {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return errors.New("failed to convert userid from string to int")
+	}
+	report, err := repo.GetUsage(ctx, uint(uid))
+	if err != nil {
+		return err
+	}
+	return repository.CheckQuota(report, int64(size))
+}
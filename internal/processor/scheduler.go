@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+)
+
+// ErrBusy means the scheduler could not admit a job: its queue was full or
+// the job waited longer than the queue timeout.
+var ErrBusy = errors.New("image processing is at capacity, try again later")
+
+// BusyError wraps ErrBusy with a hint for the client's Retry-After.
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string { return ErrBusy.Error() }
+
+func (e *BusyError) Unwrap() error { return ErrBusy }
+
+// EstimateMemory approximates the bytes a job on a width×height image holds:
+// the decoded RGBA source plus an output buffer of the same size.
+func EstimateMemory(width, height int) int64 {
+	const channels, buffers = 4, 2
+	return int64(width) * int64(height) * channels * buffers
+}
+
+type SchedulerStats struct {
+	Running      int   `json:"running"`
+	Queued       int   `json:"queued"`
+	MaxJobs      int   `json:"max_jobs"`
+	MaxQueue     int   `json:"max_queue"`
+	MemoryInUse  int64 `json:"memory_in_use"`
+	MemoryBudget int64 `json:"memory_budget"`
+}
+
+// Scheduler admits image jobs while both the job count and the estimated
+// memory stay within limits. Jobs that do not fit wait in FIFO order.
+type Scheduler struct {
+	limits config.ProcessingLimits
+
+	mu      sync.Mutex
+	running int
+	inUse   int64
+	queue   *list.List // of *waiter
+}
+
+type waiter struct {
+	need     int64
+	ready    chan struct{}
+	admitted bool
+}
+
+func NewScheduler(limits config.ProcessingLimits) *Scheduler {
+	return &Scheduler{limits: limits, queue: list.New()}
+}
+
+// Acquire blocks until a job needing need bytes may run and returns the func
+// that gives its share back. A job larger than the whole budget is admitted
+// only when nothing else is running.
+func (s *Scheduler) Acquire(ctx context.Context, need int64) (func(), error) {
+	if need > s.limits.MemoryBudget {
+		need = s.limits.MemoryBudget
+	}
+
+	s.mu.Lock()
+	if s.queue.Len() == 0 && s.fits(need) {
+		s.admit(need)
+		s.mu.Unlock()
+		return s.releaseFunc(need), nil
+	}
+	if s.queue.Len() >= s.limits.MaxQueue {
+		s.mu.Unlock()
+		return nil, s.busy()
+	}
+	w := &waiter{need: need, ready: make(chan struct{})}
+	elem := s.queue.PushBack(w)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.limits.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return s.releaseFunc(need), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = s.busy()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.admitted {
+		// Admitted while we were giving up; hand the slot straight back.
+		s.release(need)
+	} else {
+		s.queue.Remove(elem)
+	}
+	return nil, err
+}
+
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SchedulerStats{
+		Running:      s.running,
+		Queued:       s.queue.Len(),
+		MaxJobs:      s.limits.MaxJobs,
+		MaxQueue:     s.limits.MaxQueue,
+		MemoryInUse:  s.inUse,
+		MemoryBudget: s.limits.MemoryBudget,
+	}
+}
+
+func (s *Scheduler) fits(need int64) bool {
+	return s.running < s.limits.MaxJobs && s.inUse+need <= s.limits.MemoryBudget
+}
+
+func (s *Scheduler) admit(need int64) {
+	s.running++
+	s.inUse += need
+}
+
+func (s *Scheduler) releaseFunc(need int64) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.release(need)
+		})
+	}
+}
+
+// release must be called with mu held.
+func (s *Scheduler) release(need int64) {
+	s.running--
+	s.inUse -= need
+	for e := s.queue.Front(); e != nil; e = s.queue.Front() {
+		w := e.Value.(*waiter)
+		if !s.fits(w.need) {
+			break
+		}
+		s.queue.Remove(e)
+		s.admit(w.need)
+		w.admitted = true
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) busy() error {
+	// Suggest waiting roughly as long as a queued job would have.
+	retry := s.limits.QueueTimeout / 2
+	if retry < time.Second {
+		retry = time.Second
+	}
+	return &BusyError{RetryAfter: retry}
+}
@@ -0,0 +1,141 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testScheduler() *processor.Scheduler {
+	return processor.NewScheduler(config.ProcessingLimits{
+		MaxJobs:      2,
+		MemoryBudget: 1 << 30,
+		MaxQueue:     4,
+		QueueTimeout: time.Second,
+	})
+}
+
+// acquireAsync starts an Acquire and reports its result on the channel.
+func acquireAsync(s *processor.Scheduler, ctx context.Context, need int64) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		release, err := s.Acquire(ctx, need)
+		if err == nil {
+			defer release()
+		}
+		done <- err
+	}()
+	return done
+}
+
+func waitQueued(t *testing.T, s *processor.Scheduler, n int) {
+	require.Eventually(t, func() bool { return s.Stats().Queued == n }, time.Second, time.Millisecond)
+}
+
+func TestSchedulerQueuesUntilReleased(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.ProcessingLimits
+		first  int64
+		second int64
+	}{
+		{
+			name:   "job count",
+			limits: config.ProcessingLimits{MaxJobs: 1, MemoryBudget: 1000, MaxQueue: 1, QueueTimeout: time.Minute},
+			first:  10,
+			second: 10,
+		},
+		{
+			name:   "memory budget",
+			limits: config.ProcessingLimits{MaxJobs: 10, MemoryBudget: 100, MaxQueue: 1, QueueTimeout: time.Minute},
+			first:  60,
+			second: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := processor.NewScheduler(tt.limits)
+			release, err := s.Acquire(context.Background(), tt.first)
+			require.NoError(t, err)
+
+			done := acquireAsync(s, context.Background(), tt.second)
+			waitQueued(t, s, 1)
+			assert.Equal(t, 1, s.Stats().Running)
+
+			release()
+			require.NoError(t, <-done)
+			assert.Equal(t, processor.SchedulerStats{
+				MaxJobs:      tt.limits.MaxJobs,
+				MaxQueue:     tt.limits.MaxQueue,
+				MemoryBudget: tt.limits.MemoryBudget,
+			}, s.Stats())
+		})
+	}
+}
+
+func TestSchedulerRejectsWhenSaturated(t *testing.T) {
+	limits := config.ProcessingLimits{MaxJobs: 1, MemoryBudget: 100, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond}
+
+	t.Run("queue full", func(t *testing.T) {
+		s := processor.NewScheduler(config.ProcessingLimits{MaxJobs: 1, MemoryBudget: 100, MaxQueue: 1, QueueTimeout: time.Minute})
+		release, err := s.Acquire(context.Background(), 10)
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		acquireAsync(s, ctx, 10)
+		waitQueued(t, s, 1)
+
+		_, err = s.Acquire(context.Background(), 10)
+		var busy *processor.BusyError
+		require.True(t, errors.As(err, &busy))
+		assert.ErrorIs(t, err, processor.ErrBusy)
+		assert.Equal(t, 30*time.Second, busy.RetryAfter)
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		s := processor.NewScheduler(limits)
+		release, err := s.Acquire(context.Background(), 10)
+		require.NoError(t, err)
+		defer release()
+
+		_, err = s.Acquire(context.Background(), 10)
+		assert.ErrorIs(t, err, processor.ErrBusy)
+		assert.Equal(t, 0, s.Stats().Queued)
+	})
+
+	t.Run("caller gives up", func(t *testing.T) {
+		s := processor.NewScheduler(config.ProcessingLimits{MaxJobs: 1, MemoryBudget: 100, MaxQueue: 1, QueueTimeout: time.Minute})
+		release, err := s.Acquire(context.Background(), 10)
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := acquireAsync(s, ctx, 10)
+		waitQueued(t, s, 1)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Equal(t, 0, s.Stats().Queued)
+	})
+}
+
+func TestSchedulerOversizedJobRunsAlone(t *testing.T) {
+	s := processor.NewScheduler(config.ProcessingLimits{MaxJobs: 4, MemoryBudget: 100, MaxQueue: 2, QueueTimeout: time.Minute})
+
+	release, err := s.Acquire(context.Background(), 1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), s.Stats().MemoryInUse)
+
+	done := acquireAsync(s, context.Background(), 1)
+	waitQueued(t, s, 1)
+	release()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,283 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+// Upper limits on templates. Every layer is drawn on each render, and text
+// is measured and drawn glyph by glyph.
+const (
+	maxTemplateSide   = 4096
+	maxTemplateLayers = 32
+	maxTemplateVars   = 32
+	maxTemplateText   = 1000 // runes in a text layer or a variable
+)
+
+type TemplateService interface {
+	CreateTemplate(ctx context.Context, userID string, template *models.Template) error
+	ListTemplates(ctx context.Context, userID string) ([]*models.Template, error)
+	RenderTemplate(ctx context.Context, templateID string, userID string, vars map[string]string) (*models.Image, error)
+}
+
+type templateService struct {
+	templates   repository.TemplateRepository
+	repo        repository.UserRepository
+	storageRepo storage.StorageRepository
+	limits      config.ImageLimits
+	scheduler   *Scheduler
+	timeouts    config.Timeouts
+}
+
+func NewTemplateService(templates repository.TemplateRepository, userRepo repository.UserRepository, store storage.StorageRepository, limits config.ImageLimits, scheduler *Scheduler, timeouts config.Timeouts) TemplateService {
+	return &templateService{
+		templates:   templates,
+		repo:        userRepo,
+		storageRepo: store,
+		limits:      limits,
+		scheduler:   scheduler,
+		timeouts:    timeouts,
+	}
+}
+
+func (t *templateService) CreateTemplate(ctx context.Context, userID string, template *models.Template) error {
+	if template.Name == "" {
+		return errors.New("template name is required")
+	}
+	if err := validateTemplate(&template.Definition); err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return errors.New("failed to convert userid from string to int")
+	}
+	template.UserID = uint(uid)
+	return t.templates.CreateTemplate(ctx, template)
+}
+
+func (t *templateService) ListTemplates(ctx context.Context, userID string) ([]*models.Template, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, errors.New("failed to convert userid from string to int")
+	}
+	return t.templates.ListTemplates(ctx, uint(uid))
+}
+
+func (t *templateService) RenderTemplate(ctx context.Context, templateID string, userID string, vars map[string]string) (*models.Image, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Transform)
+	defer cancel()
+
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, errors.New("failed to convert userid from string to int")
+	}
+	template, err := t.templates.GetTemplate(ctx, templateID, uint(uid))
+	if err != nil {
+		return nil, errors.New("template not found")
+	}
+	if err := checkVariables(vars); err != nil {
+		return nil, err
+	}
+
+	records, err := t.imageRecords(ctx, &template.Definition, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The canvas, and one layer at a time clipped to it; then every source
+	// image, and a copy of the part of one that is scaled onto the canvas,
+	// or the buffers of the largest text layer if those need more.
+	need := 2 * EstimateMemory(template.Definition.Width, template.Definition.Height)
+	var largest int64
+	for _, record := range records {
+		cfg, err := probeStored(ctx, t.storageRepo, t.limits, record.Path, userID)
+		if err != nil {
+			return nil, err
+		}
+		need += EstimateMemory(cfg.Width, cfg.Height)
+		largest = max(largest, EstimateMemory(cfg.Width, cfg.Height))
+	}
+	canvas := image.Rect(0, 0, template.Definition.Width, template.Definition.Height)
+	for _, layer := range template.Definition.Layers {
+		if layer.Type != "text" {
+			continue
+		}
+		opts, err := parseTextOptions(layerTextRequest(layer, layer.Text), canvas)
+		if err != nil {
+			return nil, err
+		}
+		largest = max(largest, textMemory(opts))
+	}
+	need += largest
+
+	release, err := t.scheduler.Acquire(ctx, need)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	images := map[uint]image.Image{}
+	for id, record := range records {
+		images[id], _, err = decodeStored(ctx, t.storageRepo, record, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rendered, err := renderTemplate(ctx, &template.Definition, images, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, rendered); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if err := checkQuota(ctx, t.repo, userID, len(data)); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("template_%d_%d.png", template.ID, time.Now().Unix())
+	imgMetadata := &models.Image{
+		UserID:         uint(uid),
+		StoredFilename: filename,
+		Size:           uint64(len(data)),
+		MimeType:       "image/png",
+		Width:          template.Definition.Width,
+		Height:         template.Definition.Height,
+	}
+	if _, err := saveImage(ctx, t.repo, t.storageRepo, userID, data, imgMetadata); err != nil {
+		return nil, err
+	}
+	return imgMetadata, nil
+}
+
+// imageRecords looks up every image the template refers to. GetImage is
+// scoped to userID, so a template can only use its owner's images.
+func (t *templateService) imageRecords(ctx context.Context, def *models.TemplateDefinition, userID string) (map[uint]*models.Image, error) {
+	ids := []uint{}
+	if def.Background.ImageID != 0 {
+		ids = append(ids, def.Background.ImageID)
+	}
+	for _, layer := range def.Layers {
+		if layer.Type == "image" {
+			ids = append(ids, layer.ImageID)
+		}
+	}
+
+	records := map[uint]*models.Image{}
+	for _, id := range ids {
+		if _, ok := records[id]; ok {
+			continue
+		}
+		record, err := t.repo.GetImage(ctx, strconv.FormatUint(uint64(id), 10), userID)
+		if err != nil {
+			return nil, fmt.Errorf("template image %d not found", id)
+		}
+		records[id] = record
+	}
+	return records, nil
+}
+
+var layerTypes = map[string]bool{"image": true, "text": true, "rect": true, "ellipse": true}
+
+func validateTemplate(def *models.TemplateDefinition) error {
+	if def.Width <= 0 || def.Height <= 0 {
+		return errors.New("template width and height must be greater than 0")
+	}
+	if def.Width > maxTemplateSide || def.Height > maxTemplateSide {
+		return fmt.Errorf("template width and height must be at most %d", maxTemplateSide)
+	}
+	if _, err := parseColor(def.Background.Color, nil); err != nil {
+		return err
+	}
+	if len(def.Layers) > maxTemplateLayers {
+		return fmt.Errorf("templates can have at most %d layers", maxTemplateLayers)
+	}
+	canvas := image.Rect(0, 0, def.Width, def.Height)
+	for n, layer := range def.Layers {
+		if !layerTypes[layer.Type] {
+			return fmt.Errorf("layer %d: invalid type %q", n, layer.Type)
+		}
+		if layer.Type != "text" && (layer.Width <= 0 || layer.Height <= 0) {
+			return fmt.Errorf("layer %d: width and height must be greater than 0", n)
+		}
+		if layer.Width < 0 || layer.Height < 0 || layer.Width > maxTemplateSide || layer.Height > maxTemplateSide {
+			return fmt.Errorf("layer %d: width and height must be at most %d", n, maxTemplateSide)
+		}
+		if abs(layer.X) > maxTemplateSide || abs(layer.Y) > maxTemplateSide {
+			return fmt.Errorf("layer %d: x and y must be between -%d and %d", n, maxTemplateSide, maxTemplateSide)
+		}
+		if layer.Type == "text" {
+			if _, err := parseTextOptions(layerTextRequest(layer, layer.Text), canvas); err != nil {
+				return fmt.Errorf("layer %d: %w", n, err)
+			}
+		} else if image.Rect(layer.X, layer.Y, layer.X+layer.Width, layer.Y+layer.Height).Intersect(canvas).Empty() {
+			return fmt.Errorf("layer %d: must overlap the canvas", n)
+		}
+		if layer.Type == "image" && layer.ImageID == 0 {
+			return fmt.Errorf("layer %d: image_id is required", n)
+		}
+		if layer.Type == "text" && layer.Text == "" {
+			return fmt.Errorf("layer %d: text is required", n)
+		}
+		if utf8.RuneCountInString(layer.Text) > maxTemplateText {
+			return fmt.Errorf("layer %d: text must be at most %d characters", n, maxTemplateText)
+		}
+		switch layer.Mask {
+		case "", "circle", "rounded":
+		default:
+			return fmt.Errorf("layer %d: mask must be circle or rounded", n)
+		}
+		switch layer.Fit {
+		case "", "cover", "contain", "stretch":
+		default:
+			return fmt.Errorf("layer %d: fit must be cover, contain or stretch", n)
+		}
+	}
+	return nil
+}
+
+func checkVariables(vars map[string]string) error {
+	if len(vars) > maxTemplateVars {
+		return fmt.Errorf("at most %d template variables are allowed", maxTemplateVars)
+	}
+	for name, v := range vars {
+		if utf8.RuneCountInString(v) > maxTemplateText {
+			return fmt.Errorf("template variable %q must be at most %d characters", name, maxTemplateText)
+		}
+	}
+	return nil
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// substitute replaces {{name}} placeholders with vars. A placeholder without
+// a value is an error rather than silently rendered blank.
+func substitute(text string, vars map[string]string) (string, error) {
+	var missing string
+	out := placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		name := placeholder.FindStringSubmatch(m)[1]
+		v, ok := vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("missing template variable %q", missing)
+	}
+	return out, nil
+}
@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// renderTemplate draws def onto a fresh canvas. images holds every image the
+// definition refers to, keyed by PixelForge image ID. It stops between
+// layers once ctx is done.
+func renderTemplate(ctx context.Context, def *models.TemplateDefinition, images map[uint]image.Image, vars map[string]string) (*image.RGBA, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, def.Width, def.Height))
+
+	bg, err := parseColor(def.Background.Color, color.White)
+	if err != nil {
+		return nil, err
+	}
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	if def.Background.ImageID != 0 {
+		drawFitted(canvas, canvas.Bounds(), images[def.Background.ImageID], "cover", nil)
+	}
+
+	for n, layer := range def.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rect := image.Rect(layer.X, layer.Y, layer.X+layer.Width, layer.Y+layer.Height)
+		switch layer.Type {
+		case "image":
+			var mask image.Image
+			switch layer.Mask {
+			case "circle":
+				mask = shapeMask{w: rect.Dx(), h: rect.Dy(), ellipse: true}
+			case "rounded":
+				mask = shapeMask{w: rect.Dx(), h: rect.Dy(), radius: layer.Radius}
+			}
+			drawFitted(canvas, rect, images[layer.ImageID], layer.Fit, mask)
+		case "rect", "ellipse":
+			fill, err := parseColor(layer.Fill, color.Black)
+			if err != nil {
+				return nil, fmt.Errorf("layer %d: %w", n, err)
+			}
+			mask := shapeMask{w: rect.Dx(), h: rect.Dy(), radius: layer.Radius, ellipse: layer.Type == "ellipse"}
+			draw.DrawMask(canvas, rect, image.NewUniform(fill), image.Point{}, mask, image.Point{}, draw.Over)
+		case "text":
+			text, err := substitute(layer.Text, vars)
+			if err != nil {
+				return nil, err
+			}
+			opts, err := parseTextOptions(layerTextRequest(layer, text), canvas.Bounds())
+			if err != nil {
+				return nil, fmt.Errorf("layer %d: %w", n, err)
+			}
+			if err := drawText(ctx, canvas, opts); err != nil {
+				return nil, fmt.Errorf("layer %d: %w", n, err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return canvas, nil
+}
+
+// layerTextRequest expresses a text layer as a text operation so templates
+// share its defaults and validation. Unset fields are left out so the
+// operation's defaults apply.
+func layerTextRequest(layer models.TemplateLayer, text string) *models.TransformRequest {
+	req := &models.TransformRequest{
+		Params:  map[string]int{},
+		Options: map[string]string{"text": text},
+	}
+	params := map[string]int{
+		"x": layer.X, "y": layer.Y, "width": layer.Width, "height": layer.Height,
+		"size": layer.Size, "stroke": layer.Stroke, "rotation": layer.Rotation,
+		"shadow_x": layer.ShadowX, "shadow_y": layer.ShadowY, "line_height": layer.LineHeight,
+	}
+	for k, v := range params {
+		if v != 0 {
+			req.Params[k] = v
+		}
+	}
+	options := map[string]string{
+		"font": layer.Font, "color": layer.Color, "stroke_color": layer.StrokeColor,
+		"shadow_color": layer.ShadowColor, "align": layer.Align,
+	}
+	for k, v := range options {
+		if v != "" {
+			req.Options[k] = v
+		}
+	}
+	return req
+}
+
+// drawFitted scales src into rect. cover fills rect and crops the overflow,
+// contain fits entirely inside it, and stretch ignores the aspect ratio.
+// Only the part that lands on dst is scaled, so a layer reaching far past
+// the canvas costs no more than one that fits.
+func drawFitted(dst draw.Image, rect image.Rectangle, src image.Image, fit string, mask image.Image) {
+	sb := src.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w <= 0 || h <= 0 || sb.Dx() <= 0 || sb.Dy() <= 0 {
+		return
+	}
+
+	// placed is where all of the scaled src would go.
+	sw, sh := w, h
+	switch fit {
+	case "stretch":
+	case "contain":
+		sw, sh = w, sb.Dy()*w/sb.Dx()
+		if sh > h {
+			sw, sh = sb.Dx()*h/sb.Dy(), h
+		}
+	default:
+		sw, sh = w, sb.Dy()*w/sb.Dx()
+		if sh < h {
+			sw, sh = sb.Dx()*h/sb.Dy(), h
+		}
+	}
+	sw, sh = max(sw, 1), max(sh, 1)
+	placed := image.Rect(0, 0, sw, sh).Add(rect.Min).Add(image.Pt((w-sw)/2, (h-sh)/2))
+	visible := placed.Intersect(rect).Intersect(dst.Bounds())
+	if visible.Empty() {
+		return
+	}
+
+	// The part of src that maps onto visible, rounded outwards.
+	part := image.Rect(
+		sb.Min.X+(visible.Min.X-placed.Min.X)*sb.Dx()/sw,
+		sb.Min.Y+(visible.Min.Y-placed.Min.Y)*sb.Dy()/sh,
+		sb.Min.X+ceilDiv((visible.Max.X-placed.Min.X)*sb.Dx(), sw),
+		sb.Min.Y+ceilDiv((visible.Max.Y-placed.Min.Y)*sb.Dy(), sh),
+	).Intersect(sb)
+	scaled := transform.Resize(transform.Crop(src, part), visible.Dx(), visible.Dy(), transform.Lanczos)
+
+	if mask == nil {
+		draw.Draw(dst, visible, scaled, scaled.Bounds().Min, draw.Over)
+		return
+	}
+	draw.DrawMask(dst, visible, scaled, scaled.Bounds().Min, mask, visible.Min.Sub(rect.Min), draw.Over)
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// shapeMask is an alpha mask over a w×h box: either the ellipse inscribed in
+// the box or the box with its corners rounded by radius.
+type shapeMask struct {
+	w, h    int
+	radius  int
+	ellipse bool
+}
+
+func (m shapeMask) ColorModel() color.Model { return color.AlphaModel }
+
+func (m shapeMask) Bounds() image.Rectangle { return image.Rect(0, 0, m.w, m.h) }
+
+func (m shapeMask) At(x, y int) color.Color {
+	if m.inside(float64(x)+0.5, float64(y)+0.5) {
+		return color.Opaque
+	}
+	return color.Transparent
+}
+
+func (m shapeMask) inside(px, py float64) bool {
+	w, h := float64(m.w), float64(m.h)
+	if px < 0 || py < 0 || px > w || py > h {
+		return false
+	}
+	if m.ellipse {
+		dx := (px - w/2) / (w / 2)
+		dy := (py - h/2) / (h / 2)
+		return dx*dx+dy*dy <= 1
+	}
+
+	r := float64(min(m.radius, m.w/2, m.h/2))
+	if r <= 0 {
+		return true
+	}
+	// Only the four corner squares can fall outside the shape.
+	cx, cy := px, py
+	switch {
+	case px < r:
+		cx = r
+	case px > w-r:
+		cx = w - r
+	}
+	switch {
+	case py < r:
+		cy = r
+	case py > h-r:
+		cy = h - r
+	}
+	dx, dy := px-cx, py-cy
+	return dx*dx+dy*dy <= r*r
+}
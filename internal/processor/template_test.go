@@ -0,0 +1,209 @@
+package processor_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockTemplateRepository struct {
+	mock.Mock
+}
+
+func (m *MockTemplateRepository) CreateTemplate(ctx context.Context, template *models.Template) error {
+	return m.Called(template).Error(0)
+}
+
+func (m *MockTemplateRepository) ListTemplates(ctx context.Context, userID uint) ([]*models.Template, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]*models.Template), args.Error(1)
+}
+
+func (m *MockTemplateRepository) GetTemplate(ctx context.Context, templateID string, userID uint) (*models.Template, error) {
+	args := m.Called(templateID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Template), args.Error(1)
+}
+
+// MockUserRepository implements only the image and blob calls the processor
+// makes; any other call panics through the nil embedded interface.
+type MockUserRepository struct {
+	repository.UserRepository
+	mock.Mock
+}
+
+func (m *MockUserRepository) GetImage(ctx context.Context, imageID string, userID string) (*models.Image, error) {
+	args := m.Called(imageID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Image), args.Error(1)
+}
+
+func (m *MockUserRepository) SaveImageDB(ctx context.Context, metadata *models.Image) error {
+	return m.Called(metadata).Error(0)
+}
+
+func (m *MockUserRepository) GetBlob(ctx context.Context, userID uint, hash string) (*models.Blob, error) {
+	args := m.Called(userID, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Blob), args.Error(1)
+}
+
+func (m *MockUserRepository) DeleteImage(ctx context.Context, imageID string, userID string) (string, error) {
+	args := m.Called(imageID, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserRepository) GetUsage(ctx context.Context, userID uint) (*models.UsageReport, error) {
+	args := m.Called(userID)
+	return args.Get(0).(*models.UsageReport), args.Error(1)
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, img))
+}
+
+func cardTemplate() *models.Template {
+	tpl := &models.Template{
+		Name: "og-card",
+		Definition: models.TemplateDefinition{
+			Width:      240,
+			Height:     120,
+			Background: models.TemplateBackground{Color: "#102030"},
+			Layers: []models.TemplateLayer{
+				{Type: "rect", X: 0, Y: 100, Width: 240, Height: 20, Fill: "#ff0000", Radius: 4},
+				{Type: "image", X: 10, Y: 10, Width: 60, Height: 60, ImageID: 7, Mask: "circle"},
+				{Type: "ellipse", X: 200, Y: 10, Width: 30, Height: 30, Fill: "#00ff00"},
+				{Type: "text", X: 80, Y: 20, Width: 150, Text: "Hello {{name}}", Size: 18},
+			},
+		},
+	}
+	tpl.ID = 3
+	return tpl
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		wantErr string
+	}{
+		{name: "renders card", vars: map[string]string{"name": "World"}},
+		{name: "missing variable", vars: map[string]string{}, wantErr: `missing template variable "name"`},
+		{name: "variable too long", vars: map[string]string{"name": strings.Repeat("a", 1001)}, wantErr: `template variable "name" must be at most 1000 characters`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writePNG(t, filepath.Join(root, "1", "logo.png"), solidImage(20, 20, color.White))
+
+			templates := new(MockTemplateRepository)
+			templates.On("GetTemplate", "3", uint(1)).Return(cardTemplate(), nil)
+			users := new(MockUserRepository)
+			users.On("GetImage", "7", "1").Return(&models.Image{Path: "logo.png"}, nil)
+			if tt.wantErr == "" {
+				users.On("GetUsage", uint(1)).Return(&models.UsageReport{}, nil)
+				users.On("GetBlob", uint(1), mock.Anything).Return(nil, nil)
+				users.On("SaveImageDB", mock.Anything).Return(nil)
+			}
+
+			svc := processor.NewTemplateService(templates, users, storage.NewStorageRepository(root), config.LoadImageLimits(), testScheduler(), config.Timeouts{})
+			record, err := svc.RenderTemplate(context.Background(), "3", "1", tt.vars)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 240, record.Width)
+			assert.Equal(t, "image/png", record.MimeType)
+
+			data, err := os.ReadFile(filepath.Join(root, "1", record.Path))
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+
+			// circle mask: centre is the logo, corner keeps the background
+			r, g, b, _ := img.At(40, 40).RGBA()
+			assert.Equal(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b})
+			r, _, _, _ = img.At(11, 11).RGBA()
+			assert.Equal(t, uint32(0x1010), r)
+			r, _, _, _ = img.At(120, 110).RGBA()
+			assert.Equal(t, uint32(0xffff), r)
+			_, g, _, _ = img.At(215, 25).RGBA()
+			assert.Equal(t, uint32(0xffff), g)
+
+			templates.AssertExpectations(t)
+			users.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateTemplateValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(tpl *models.Template)
+		wantErr string
+	}{
+		{name: "valid", mutate: func(tpl *models.Template) {}},
+		{name: "missing name", mutate: func(tpl *models.Template) { tpl.Name = "" }, wantErr: "template name is required"},
+		{name: "oversized canvas", mutate: func(tpl *models.Template) { tpl.Definition.Width = 10000 }, wantErr: "template width and height must be at most 4096"},
+		{name: "bad layer type", mutate: func(tpl *models.Template) { tpl.Definition.Layers[0].Type = "video" }, wantErr: `layer 0: invalid type "video"`},
+		{name: "bad mask", mutate: func(tpl *models.Template) { tpl.Definition.Layers[1].Mask = "star" }, wantErr: "layer 1: mask must be circle or rounded"},
+		{name: "oversized layer", mutate: func(tpl *models.Template) { tpl.Definition.Layers[1].Width = 1 << 30 }, wantErr: "layer 1: width and height must be at most 4096"},
+		{name: "layer far away", mutate: func(tpl *models.Template) { tpl.Definition.Layers[1].Y = -1 << 30 }, wantErr: "layer 1: x and y must be between -4096 and 4096"},
+		{name: "layer off the canvas", mutate: func(tpl *models.Template) { tpl.Definition.Layers[1].X = 4000 }, wantErr: "layer 1: must overlap the canvas"},
+		{name: "text layer with oversized stroke", mutate: func(tpl *models.Template) { tpl.Definition.Layers[3].Stroke = 5000 }, wantErr: "layer 3: stroke must be between 0 and 20"},
+		{name: "text layer too long", mutate: func(tpl *models.Template) { tpl.Definition.Layers[3].Text = strings.Repeat("a", 1001) }, wantErr: "layer 3: text must be at most 1000 characters"},
+		{name: "too many layers", mutate: func(tpl *models.Template) {
+			for len(tpl.Definition.Layers) <= 32 {
+				tpl.Definition.Layers = append(tpl.Definition.Layers, tpl.Definition.Layers[0])
+			}
+		}, wantErr: "templates can have at most 32 layers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := cardTemplate()
+			tt.mutate(tpl)
+			templates := new(MockTemplateRepository)
+			if tt.wantErr == "" {
+				templates.On("CreateTemplate", tpl).Return(nil)
+			}
+
+			svc := processor.NewTemplateService(templates, new(MockUserRepository), nil, config.LoadImageLimits(), testScheduler(), config.Timeouts{})
+			err := svc.CreateTemplate(context.Background(), "1", tpl)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, uint(1), tpl.UserID)
+			}
+			templates.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/anthonynsimon/bild/transform"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Upper limits on text parameters. Stroke is drawn by repeating the text
+// about πr² times, and size, stroke and shadow all grow the layer it is
+// drawn on.
+const (
+	maxTextLength     = 1000 // runes
+	maxTextSize       = 1000
+	maxTextLineHeight = 1000 // percent
+	maxTextStroke     = 20
+	maxTextShadow     = 200
+)
+
+type textOptions struct {
+	text        string
+	family      string
+	size        int
+	fill        color.Color
+	stroke      int
+	strokeColor color.Color
+	shadow      image.Point
+	shadowColor color.Color
+	align       string
+	box         image.Rectangle
+	rotation    int // degrees, clockwise
+	lineHeight  int // percent of the font size
+}
+
+func parseTextOptions(req *models.TransformRequest, bounds image.Rectangle) (textOptions, error) {
+	opts := textOptions{
+		text:       req.Options["text"],
+		family:     req.Options["font"],
+		size:       32,
+		align:      "left",
+		lineHeight: 120,
+	}
+	if opts.text == "" {
+		return opts, errors.New("text is required")
+	}
+	if utf8.RuneCountInString(opts.text) > maxTextLength {
+		return opts, fmt.Errorf("text must be at most %d characters", maxTextLength)
+	}
+	if v, ok := req.Params["size"]; ok {
+		opts.size = v
+	}
+	if v, ok := req.Params["line_height"]; ok {
+		opts.lineHeight = v
+	}
+	if v := req.Options["align"]; v != "" {
+		opts.align = v
+	}
+	opts.stroke = req.Params["stroke"]
+	opts.rotation = req.Params["rotation"]
+	opts.shadow = image.Pt(req.Params["shadow_x"], req.Params["shadow_y"])
+
+	var err error
+	if opts.fill, err = parseColor(req.Options["color"], color.White); err != nil {
+		return opts, err
+	}
+	if opts.strokeColor, err = parseColor(req.Options["stroke_color"], color.Black); err != nil {
+		return opts, err
+	}
+	if opts.shadowColor, err = parseColor(req.Options["shadow_color"], color.NRGBA{A: 0x80}); err != nil {
+		return opts, err
+	}
+
+	x, y := req.Params["x"], req.Params["y"]
+	w, h := req.Params["width"], req.Params["height"]
+	if w == 0 {
+		w = bounds.Dx() - x
+	}
+	if h == 0 {
+		h = bounds.Dy() - y
+	}
+	// The box is in the image's own coordinates, which start at 0,0 where
+	// text is drawn.
+	opts.box = image.Rect(x, y, x+w, y+h).Intersect(bounds.Sub(bounds.Min))
+
+	if opts.size <= 0 || opts.size > maxTextSize {
+		return opts, fmt.Errorf("size must be between 1 and %d", maxTextSize)
+	}
+	if opts.lineHeight <= 0 || opts.lineHeight > maxTextLineHeight {
+		return opts, fmt.Errorf("line_height must be between 1 and %d", maxTextLineHeight)
+	}
+	if opts.stroke < 0 || opts.stroke > maxTextStroke {
+		return opts, fmt.Errorf("stroke must be between 0 and %d", maxTextStroke)
+	}
+	if abs(opts.shadow.X) > maxTextShadow || abs(opts.shadow.Y) > maxTextShadow {
+		return opts, fmt.Errorf("shadow_x and shadow_y must be between -%d and %d", maxTextShadow, maxTextShadow)
+	}
+	if opts.align != "left" && opts.align != "center" && opts.align != "right" {
+		return opts, errors.New("align must be left, center or right")
+	}
+	if opts.box.Empty() {
+		return opts, errors.New("text box must overlap the image")
+	}
+	return opts, nil
+}
+
+// parseColor reads #RGB, #RRGGBB or #RRGGBBAA, falling back to def when s is
+// empty.
+func parseColor(s string, def color.Color) (color.Color, error) {
+	if s == "" {
+		return def, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	c := color.NRGBA{A: 0xff}
+	var err error
+	switch len(hex) {
+	case 3:
+		_, err = fmt.Sscanf(hex, "%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R, c.G, c.B = c.R*17, c.G*17, c.B*17
+	case 6:
+		_, err = fmt.Sscanf(hex, "%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 8:
+		_, err = fmt.Sscanf(hex, "%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = errors.New("bad length")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid colour %q", s)
+	}
+	return c, nil
+}
+
+// drawText renders opts onto dst. The text is laid out inside opts.box and,
+// when rotated, turned about the centre of that box. It stops between lines
+// once ctx is done.
+func drawText(ctx context.Context, dst draw.Image, opts textOptions) error {
+	f, err := loadFont(opts.family)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(opts.size),
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	// Pad the layer so strokes and shadows near the box edge are not clipped.
+	pad := opts.stroke + max(abs(opts.shadow.X), abs(opts.shadow.Y))
+	w, h := opts.box.Dx(), opts.box.Dy()
+	layer := image.NewRGBA(image.Rect(0, 0, w+2*pad, h+2*pad))
+
+	lines := wrapText(face, opts.text, w)
+	step := opts.size * opts.lineHeight / 100
+	ascent := face.Metrics().Ascent.Ceil()
+
+	drawLines := func(c color.Color, offset image.Point) error {
+		d := &font.Drawer{Dst: layer, Src: image.NewUniform(c), Face: face}
+		for n, line := range lines {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			lw := font.MeasureString(face, line).Ceil()
+			x := 0
+			switch opts.align {
+			case "center":
+				x = (w - lw) / 2
+			case "right":
+				x = w - lw
+			}
+			y := ascent + n*step
+			d.Dot = fixed.P(pad+x+offset.X, pad+y+offset.Y)
+			d.DrawString(line)
+		}
+		return nil
+	}
+
+	if opts.shadow != (image.Point{}) {
+		if err := drawLines(opts.shadowColor, opts.shadow); err != nil {
+			return err
+		}
+	}
+	for dx := -opts.stroke; dx <= opts.stroke; dx++ {
+		for dy := -opts.stroke; dy <= opts.stroke; dy++ {
+			if (dx != 0 || dy != 0) && dx*dx+dy*dy <= opts.stroke*opts.stroke {
+				if err := drawLines(opts.strokeColor, image.Pt(dx, dy)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := drawLines(opts.fill, image.Point{}); err != nil {
+		return err
+	}
+
+	var out image.Image = layer
+	if opts.rotation%360 != 0 {
+		out = transform.Rotate(layer, float64(opts.rotation), &transform.RotationOptions{ResizeBounds: true})
+	}
+	ob := out.Bounds()
+	center := image.Pt(opts.box.Min.X+w/2, opts.box.Min.Y+h/2)
+	origin := center.Sub(image.Pt(ob.Dx()/2, ob.Dy()/2))
+	draw.Draw(dst, image.Rectangle{Min: origin, Max: origin.Add(ob.Size())}, out, ob.Min, draw.Over)
+	return nil
+}
+
+// textMemory approximates the bytes drawText holds for opts: the padded
+// layer the text is drawn on and, when rotated, the larger rotated copy.
+func textMemory(opts textOptions) int64 {
+	pad := opts.stroke + max(abs(opts.shadow.X), abs(opts.shadow.Y))
+	w, h := opts.box.Dx()+2*pad, opts.box.Dy()+2*pad
+	if opts.rotation%360 != 0 {
+		// A rotated w×h layer fits in a square with the sides' sum.
+		return EstimateMemory(w+h, w+h)
+	}
+	return EstimateMemory(w, h)
+}
+
+// wrapText breaks text into lines no wider than width, honouring explicit
+// newlines. Words longer than a whole line are split between characters.
+func wrapText(face font.Face, text string, width int) []string {
+	limit := fixed.I(width)
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate) <= limit {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			runes := []rune(word)
+			for font.MeasureString(face, string(runes)) > limit {
+				cut := 1
+				for cut < len(runes) && font.MeasureString(face, string(runes[:cut+1])) <= limit {
+					cut++
+				}
+				lines = append(lines, string(runes[:cut]))
+				runes = runes[cut:]
+			}
+			line = string(runes)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (i *imageTransformation) text(ctx context.Context, img image.Image, opts textOptions) (image.Image, error) {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	if err := drawText(ctx, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -2,6 +2,7 @@ package processor
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"image"
 	"image/gif"
@@ -9,6 +10,7 @@ import (
 	"image/png"
 	"log"
 
+	"github.com/HarshithRajesh/PixelForge/internal/metadata"
 	"github.com/HarshithRajesh/PixelForge/internal/models"
 
 	"github.com/anthonynsimon/bild/transform"
@@ -19,16 +21,35 @@ type TransformRequest struct {
 	Params    map[string]int
 }
 
+// Source is the decoded image a transformation runs on, together with the
+// other inputs some operations need.
+type Source struct {
+	Image  image.Image
+	Format string
+	// Meta is the metadata the output is allowed to carry.
+	Meta metadata.Tags
+	// Overlay is the caller's logo for the watermark operation.
+	Overlay image.Image
+}
+
 type ImageTransformation interface {
-	Process(req *models.TransformRequest, img image.Image, format string) ([]byte, error)
+	Process(ctx context.Context, req *models.TransformRequest, src *Source) ([]byte, error)
 }
 
 type imageTransformation struct{}
 
 func NewImageTransformation() ImageTransformation { return &imageTransformation{} }
 
-func (i *imageTransformation) Process(req *models.TransformRequest, img image.Image, format string) ([]byte, error) {
+// Process applies req to src and encodes the result. The standard library
+// encoders never write metadata, so the output carries only src.Meta.
+// Cancellation of ctx is checked between the transform and encode steps.
+func (i *imageTransformation) Process(ctx context.Context, req *models.TransformRequest, src *Source) ([]byte, error) {
+	img, format := src.Image, src.Format
 	var res image.Image
+	var err error
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	log.Println("Before transformation")
 	switch req.Operation {
 	case "resize":
@@ -38,12 +59,32 @@ func (i *imageTransformation) Process(req *models.TransformRequest, img image.Im
 			return nil, errors.New("width and height must be greater than 0")
 		}
 		res = i.resize(img, w, h)
+	case "watermark":
+		if src.Overlay == nil {
+			return nil, errors.New("watermark image is required")
+		}
+		opts, err := parseWatermarkOptions(req)
+		if err != nil {
+			return nil, err
+		}
+		res = i.watermark(img, src.Overlay, opts)
+	case "text":
+		opts, err := parseTextOptions(req, img.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		res, err = i.text(ctx, img, opts)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, errors.New("invalid operation as input")
 	}
 	log.Println("transformed")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	buf := new(bytes.Buffer)
-	var err error
 
 	switch format {
 	case "png":
@@ -57,7 +98,10 @@ func (i *imageTransformation) Process(req *models.TransformRequest, img image.Im
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metadata.Embed(buf.Bytes(), format, src.Meta)
 }
 
 func (i *imageTransformation) resize(img image.Image, w, h int) image.Image {
@@ -0,0 +1,288 @@
+package processor_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/metadata"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/processor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func solidImage(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestProcessMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		meta   metadata.Tags
+	}{
+		{name: "jpeg no metadata", format: "jpeg"},
+		{name: "jpeg keeps copyright", format: "jpeg", meta: metadata.Tags{Copyright: "(c) Alice"}},
+		{name: "png keeps orientation", format: "png", meta: metadata.Tags{Orientation: 3}},
+	}
+
+	proc := processor.NewImageTransformation()
+	req := &models.TransformRequest{Operation: "resize", Params: map[string]int{"width": 4, "height": 4}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &processor.Source{Image: solidImage(16, 16, color.White), Format: tt.format, Meta: tt.meta}
+			out, err := proc.Process(context.Background(), req, src)
+			require.NoError(t, err)
+
+			ex, err := metadata.Extract(out, tt.format)
+			require.NoError(t, err)
+			if tt.meta == (metadata.Tags{}) {
+				assert.Nil(t, ex)
+			} else {
+				require.NotNil(t, ex)
+				assert.False(t, ex.HasGPS)
+				assert.Equal(t, tt.meta.Orientation, ex.Orientation)
+				assert.Equal(t, tt.meta.Copyright, ex.Copyright)
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+			assert.Equal(t, 4, cfg.Width)
+		})
+	}
+}
+
+func TestProcessWatermark(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *models.TransformRequest
+		overlay image.Image
+		probe   image.Point
+		marked  bool
+		wantErr string
+	}{
+		{
+			name:    "missing overlay",
+			req:     &models.TransformRequest{Operation: "watermark"},
+			wantErr: "watermark image is required",
+		},
+		{
+			name: "invalid position",
+			req: &models.TransformRequest{
+				Operation: "watermark",
+				Options:   map[string]string{"position": "middle"},
+			},
+			overlay: solidImage(10, 10, color.Black),
+			wantErr: "invalid watermark position",
+		},
+		{
+			name: "bottom right corner",
+			req: &models.TransformRequest{
+				Operation: "watermark",
+				Params:    map[string]int{"scale": 25, "margin": 0, "opacity": 100},
+			},
+			overlay: solidImage(10, 10, color.Black),
+			probe:   image.Pt(90, 90),
+			marked:  true,
+		},
+		{
+			name: "top left untouched when anchored bottom right",
+			req: &models.TransformRequest{
+				Operation: "watermark",
+				Params:    map[string]int{"scale": 25, "margin": 0, "opacity": 100},
+			},
+			overlay: solidImage(10, 10, color.Black),
+			probe:   image.Pt(5, 5),
+			marked:  false,
+		},
+		{
+			name: "grid tiling covers top left",
+			req: &models.TransformRequest{
+				Operation: "watermark",
+				Params:    map[string]int{"scale": 25, "margin": 0, "opacity": 100},
+				Options:   map[string]string{"tile": "grid"},
+			},
+			overlay: solidImage(10, 10, color.Black),
+			probe:   image.Pt(5, 5),
+			marked:  true,
+		},
+		{
+			name: "a tall mark is fitted inside the target",
+			req: &models.TransformRequest{
+				Operation: "watermark",
+				Params:    map[string]int{"scale": 100, "margin": 0, "opacity": 100},
+			},
+			overlay: solidImage(100, 1000, color.Black),
+			probe:   image.Pt(5, 5),
+			marked:  false,
+		},
+	}
+
+	proc := processor.NewImageTransformation()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &processor.Source{Image: solidImage(100, 100, color.White), Format: "png", Overlay: tt.overlay}
+			out, err := proc.Process(context.Background(), tt.req, src)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			img, _, err := image.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			r, _, _, _ := img.At(tt.probe.X, tt.probe.Y).RGBA()
+			if tt.marked {
+				assert.Less(t, r, uint32(0x1000))
+			} else {
+				assert.Equal(t, uint32(0xffff), r)
+			}
+		})
+	}
+}
+
+func TestProcessText(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *models.TransformRequest
+		wantErr string
+	}{
+		{
+			name:    "missing text",
+			req:     &models.TransformRequest{Operation: "text"},
+			wantErr: "text is required",
+		},
+		{
+			name: "unknown font",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Options:   map[string]string{"text": "hello", "font": "comic-sans"},
+			},
+			wantErr: `unknown font family "comic-sans"`,
+		},
+		{
+			name: "invalid colour",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Options:   map[string]string{"text": "hello", "color": "#zzz"},
+			},
+			wantErr: `invalid colour "#zzz"`,
+		},
+		{
+			name: "oversized font",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"size": 1 << 20},
+				Options:   map[string]string{"text": "hello"},
+			},
+			wantErr: "size must be between 1 and 1000",
+		},
+		{
+			name: "oversized stroke",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"stroke": 5000},
+				Options:   map[string]string{"text": "hello"},
+			},
+			wantErr: "stroke must be between 0 and 20",
+		},
+		{
+			name: "text too long",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Options:   map[string]string{"text": strings.Repeat("é", 1001)},
+			},
+			wantErr: "text must be at most 1000 characters",
+		},
+		{
+			name: "oversized line height",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"line_height": 1 << 20},
+				Options:   map[string]string{"text": "hello"},
+			},
+			wantErr: "line_height must be between 1 and 1000",
+		},
+		{
+			name: "oversized shadow",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"shadow_y": -1 << 30},
+				Options:   map[string]string{"text": "hello"},
+			},
+			wantErr: "shadow_x and shadow_y must be between -200 and 200",
+		},
+		{
+			name: "box outside the image",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"x": 5000, "y": 5000},
+				Options:   map[string]string{"text": "hello"},
+			},
+			wantErr: "text box must overlap the image",
+		},
+		{
+			name: "huge box is clipped to the image",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"size": 24, "width": 1 << 30, "height": 1 << 30},
+				Options:   map[string]string{"text": "PIXELFORGE"},
+			},
+		},
+		{
+			name: "wrapped centred caption with stroke and shadow",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"size": 24, "stroke": 2, "shadow_x": 3, "shadow_y": 3, "x": 10, "y": 10, "width": 180},
+				Options:   map[string]string{"text": "PIXELFORGE CAPTION THAT WRAPS", "align": "center", "color": "#ffffff"},
+			},
+		},
+		{
+			name: "rotated credit",
+			req: &models.TransformRequest{
+				Operation: "text",
+				Params:    map[string]int{"size": 20, "rotation": 90},
+				Options:   map[string]string{"text": "credit", "font": "mono-bold", "color": "#ff0000"},
+			},
+		},
+	}
+
+	proc := processor.NewImageTransformation()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &processor.Source{Image: solidImage(200, 200, color.Black), Format: "png"}
+			out, err := proc.Process(context.Background(), tt.req, src)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			img, _, err := image.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, 200, img.Bounds().Dx())
+
+			painted := 0
+			for x := 0; x < 200; x++ {
+				for y := 0; y < 200; y++ {
+					if r, _, _, _ := img.At(x, y).RGBA(); r > 0x8000 {
+						painted++
+					}
+				}
+			}
+			assert.Greater(t, painted, 50)
+		})
+	}
+}
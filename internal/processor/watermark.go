@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/anthonynsimon/bild/blend"
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// minTileStep is the least distance between tiled copies of a mark, so a
+// tiny mark with no margin cannot turn into millions of draws.
+const minTileStep = 32
+
+type watermarkOptions struct {
+	position string
+	tile     bool
+	margin   int
+	scale    int // percent of the target width
+	opacity  int // percent
+}
+
+var watermarkPositions = map[string]bool{
+	"top-left": true, "top": true, "top-right": true,
+	"left": true, "center": true, "right": true,
+	"bottom-left": true, "bottom": true, "bottom-right": true,
+}
+
+func parseWatermarkOptions(req *models.TransformRequest) (watermarkOptions, error) {
+	opts := watermarkOptions{
+		position: "bottom-right",
+		margin:   16,
+		scale:    20,
+		opacity:  60,
+	}
+	if v, ok := req.Params["margin"]; ok {
+		opts.margin = v
+	}
+	if v, ok := req.Params["scale"]; ok {
+		opts.scale = v
+	}
+	if v, ok := req.Params["opacity"]; ok {
+		opts.opacity = v
+	}
+	if v := req.Options["position"]; v != "" {
+		opts.position = v
+	}
+	switch req.Options["tile"] {
+	case "", "none":
+	case "grid":
+		opts.tile = true
+	default:
+		return opts, errors.New("tile must be none or grid")
+	}
+
+	if !watermarkPositions[opts.position] {
+		return opts, errors.New("invalid watermark position")
+	}
+	if opts.margin < 0 {
+		return opts, errors.New("margin must not be negative")
+	}
+	if opts.scale <= 0 || opts.scale > 100 {
+		return opts, errors.New("scale must be between 1 and 100")
+	}
+	if opts.opacity < 0 || opts.opacity > 100 {
+		return opts, errors.New("opacity must be between 0 and 100")
+	}
+	return opts, nil
+}
+
+func (i *imageTransformation) watermark(img, logo image.Image, opts watermarkOptions) image.Image {
+	b := img.Bounds()
+	lb := logo.Bounds()
+
+	w := b.Dx() * opts.scale / 100
+	h := lb.Dy() * w / lb.Dx()
+	if h > b.Dy() {
+		// A mark taller than the target is fitted to its height instead.
+		w = lb.Dx() * b.Dy() / lb.Dy()
+		h = b.Dy()
+	}
+	if w < 1 || h < 1 {
+		return img
+	}
+	mark := transform.Resize(logo, w, h, transform.Lanczos)
+
+	// Draw every copy of the mark onto a transparent layer the size of the
+	// target, then blend that layer once so opacity is applied uniformly.
+	layer := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if opts.tile {
+		stepX := max(w+opts.margin, minTileStep)
+		stepY := max(h+opts.margin, minTileStep)
+		for y := opts.margin; y < b.Dy(); y += stepY {
+			for x := opts.margin; x < b.Dx(); x += stepX {
+				draw.Draw(layer, image.Rect(x, y, x+w, y+h), mark, image.Point{}, draw.Over)
+			}
+		}
+	} else {
+		pt := anchor(b.Dx(), b.Dy(), w, h, opts.position, opts.margin)
+		draw.Draw(layer, image.Rectangle{Min: pt, Max: pt.Add(image.Pt(w, h))}, mark, image.Point{}, draw.Over)
+	}
+
+	return blend.Opacity(img, layer, float64(opts.opacity)/100)
+}
+
+// anchor returns the top-left corner of a w×h box placed at position inside a
+// width×height canvas, inset by margin from the edges it touches.
+func anchor(width, height, w, h int, position string, margin int) image.Point {
+	x := (width - w) / 2
+	y := (height - h) / 2
+	switch position {
+	case "top-left", "left", "bottom-left":
+		x = margin
+	case "top-right", "right", "bottom-right":
+		x = width - w - margin
+	}
+	switch position {
+	case "top-left", "top", "top-right":
+		y = margin
+	case "bottom-left", "bottom", "bottom-right":
+		y = height - h - margin
+	}
+	return image.Pt(x, y)
+}
@@ -0,0 +1,117 @@
+// Package rbac decides what each role may do. The built-in roles are
+// defined here; custom roles come from the roles table.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+)
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrBuiltinRole means a custom role would replace a built-in one.
+	ErrBuiltinRole = errors.New("built-in roles cannot be changed")
+)
+
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+var builtin = map[string][]string{
+	models.RoleUser:  {},
+	models.RoleAdmin: models.Permissions,
+}
+
+type Roles interface {
+	// Can reports whether role grants perm. An empty role is
+	// models.RoleUser; a role that no longer exists grants nothing.
+	Can(ctx context.Context, role, perm string) (bool, error)
+	// Check returns ErrUnknownRole unless users can be given role.
+	Check(ctx context.Context, role string) error
+	// List returns the built-in roles, then the custom ones.
+	List(ctx context.Context) ([]*models.Role, error)
+	Save(ctx context.Context, role *models.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+type roles struct {
+	repo repository.RoleRepository
+}
+
+func NewRoles(repo repository.RoleRepository) Roles {
+	return &roles{repo: repo}
+}
+
+func (r *roles) Can(ctx context.Context, role, perm string) (bool, error) {
+	perms, err := r.permissions(ctx, role)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(perms, perm), nil
+}
+
+func (r *roles) Check(ctx context.Context, role string) error {
+	if _, ok := builtin[role]; ok {
+		return nil
+	}
+	custom, err := r.repo.GetRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if custom == nil {
+		return fmt.Errorf("%w %q", ErrUnknownRole, role)
+	}
+	return nil
+}
+
+func (r *roles) List(ctx context.Context) ([]*models.Role, error) {
+	custom, err := r.repo.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*models.Role{
+		{Name: models.RoleUser, Permissions: builtin[models.RoleUser]},
+		{Name: models.RoleAdmin, Permissions: builtin[models.RoleAdmin]},
+	}, custom...), nil
+}
+
+func (r *roles) Save(ctx context.Context, role *models.Role) error {
+	if _, ok := builtin[role.Name]; ok {
+		return ErrBuiltinRole
+	}
+	if !roleName.MatchString(role.Name) {
+		return fmt.Errorf("role names are lower-case letters, digits, - and _, not %q", role.Name)
+	}
+	for _, perm := range role.Permissions {
+		if !slices.Contains(models.Permissions, perm) {
+			return fmt.Errorf("unknown permission %q, expected one of %s", perm, strings.Join(models.Permissions, ", "))
+		}
+	}
+	return r.repo.SaveRole(ctx, role)
+}
+
+func (r *roles) Delete(ctx context.Context, name string) error {
+	if _, ok := builtin[name]; ok {
+		return ErrBuiltinRole
+	}
+	return r.repo.DeleteRole(ctx, name)
+}
+
+func (r *roles) permissions(ctx context.Context, role string) ([]string, error) {
+	if role == "" {
+		role = models.RoleUser
+	}
+	if perms, ok := builtin[role]; ok {
+		return perms, nil
+	}
+	custom, err := r.repo.GetRole(ctx, role)
+	if err != nil || custom == nil {
+		return nil, err
+	}
+	return custom.Permissions, nil
+}
@@ -0,0 +1,83 @@
+package rbac_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/rbac"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRoles is a RoleRepository over a map.
+type memoryRoles map[string]*models.Role
+
+func (m memoryRoles) SaveRole(ctx context.Context, role *models.Role) error {
+	m[role.Name] = role
+	return nil
+}
+
+func (m memoryRoles) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	return m[name], nil
+}
+
+func (m memoryRoles) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	var roles []*models.Role
+	for _, r := range m {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func (m memoryRoles) DeleteRole(ctx context.Context, name string) error {
+	if _, ok := m[name]; !ok {
+		return repository.ErrRoleNotFound
+	}
+	delete(m, name)
+	return nil
+}
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	roles := rbac.NewRoles(memoryRoles{})
+	require.NoError(t, roles.Save(ctx, &models.Role{Name: "support", Permissions: []string{models.PermUsersRead}}))
+
+	tests := []struct {
+		role string
+		perm string
+		want bool
+	}{
+		{"", models.PermUsersRead, false},
+		{models.RoleUser, models.PermUsersRead, false},
+		{models.RoleAdmin, models.PermUsersManage, true},
+		{"support", models.PermUsersRead, true},
+		{"support", models.PermUsersManage, false},
+		{"gone", models.PermUsersRead, false},
+	}
+	for _, tt := range tests {
+		ok, err := roles.Can(ctx, tt.role, tt.perm)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, ok, "%q %s", tt.role, tt.perm)
+	}
+
+	assert.NoError(t, roles.Check(ctx, models.RoleAdmin))
+	assert.NoError(t, roles.Check(ctx, "support"))
+	assert.ErrorIs(t, roles.Check(ctx, "gone"), rbac.ErrUnknownRole)
+
+	assert.ErrorIs(t, roles.Save(ctx, &models.Role{Name: models.RoleAdmin}), rbac.ErrBuiltinRole)
+	assert.ErrorIs(t, roles.Delete(ctx, models.RoleUser), rbac.ErrBuiltinRole)
+	assert.Error(t, roles.Save(ctx, &models.Role{Name: "Bad Name"}))
+	assert.ErrorContains(t, roles.Save(ctx, &models.Role{Name: "ops", Permissions: []string{"everything"}}), "unknown permission")
+
+	list, err := roles.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	assert.Equal(t, []string{models.RoleUser, models.RoleAdmin, "support"}, []string{list[0].Name, list[1].Name, list[2].Name})
+
+	require.NoError(t, roles.Delete(ctx, "support"))
+	ok, err := roles.Can(ctx, "support", models.PermUsersRead)
+	require.NoError(t, err)
+	assert.False(t, ok, "a deleted role grants nothing")
+}
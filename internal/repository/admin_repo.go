@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrUserNotFound means no user has the given ID.
+var ErrUserNotFound = errors.New("user not found")
+
+// AdminRepository is the admin side of accounts.
+type AdminRepository interface {
+	// ListUsers returns a page of users by ID and the total number of
+	// users.
+	ListUsers(ctx context.Context, offset, limit int) ([]*models.User, int64, error)
+	GetUser(ctx context.Context, userID uint) (*models.User, error)
+	SetUserRole(ctx context.Context, userID uint, role string) error
+	// SetUserSuspended suspends the user at at, or lifts the suspension
+	// when at is nil.
+	SetUserSuspended(ctx context.Context, userID uint, at *time.Time) error
+}
+
+type adminRepository struct {
+	db *gorm.DB
+}
+
+func NewAdminRepository(db *gorm.DB) AdminRepository {
+	return &adminRepository{db}
+}
+
+func (r *adminRepository) ListUsers(ctx context.Context, offset, limit int) ([]*models.User, int64, error) {
+	var total int64
+	if err := r.db.WithContext(ctx).Model(&models.User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []*models.User
+	err := r.db.WithContext(ctx).Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *adminRepository) GetUser(ctx context.Context, userID uint) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Where("id = ?", userID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *adminRepository) SetUserRole(ctx context.Context, userID uint, role string) error {
+	return r.update(ctx, userID, "role", role)
+}
+
+func (r *adminRepository) SetUserSuspended(ctx context.Context, userID uint, at *time.Time) error {
+	return r.update(ctx, userID, "suspended_at", at)
+}
+
+func (r *adminRepository) update(ctx context.Context, userID uint, column string, value interface{}) error {
+	result := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
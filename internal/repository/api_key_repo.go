@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	ListAPIKeys(ctx context.Context, userID uint) ([]*models.APIKey, error)
+	// GetAPIKeyByPrefix returns nil when no key has the prefix or its owner
+	// is suspended.
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// DeleteAPIKey reports whether the user had a key with that ID.
+	DeleteAPIKey(ctx context.Context, userID, keyID uint) (bool, error)
+	TouchAPIKey(ctx context.Context, keyID uint, at time.Time) error
+}
+
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyRepository{db}
+}
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context, userID uint) ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).
+		Joins("JOIN users ON users.id = api_keys.user_id AND users.suspended_at IS NULL").
+		Where("api_keys.prefix = ?", prefix).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *apiKeyRepository) DeleteAPIKey(ctx context.Context, userID, keyID uint) (bool, error) {
+	res := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", keyID, userID).Delete(&models.APIKey{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, keyID uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", keyID).Update("last_used_at", at).Error
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, entry *models.AuditLog) error
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db}
+}
+
+func (r *auditRepository) Record(ctx context.Context, entry *models.AuditLog) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type KeyRepository interface {
+	GetDataKey(ctx context.Context, userID uint) (*models.DataKey, error)
+	// CreateDataKey stores key unless the user already has one, and returns
+	// whichever key ends up stored.
+	CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error)
+	ListDataKeysNotWrappedWith(ctx context.Context, keyID string) ([]*models.DataKey, error)
+	UpdateWrappedKey(ctx context.Context, key *models.DataKey, oldKeyID string) error
+}
+
+type keyRepository struct {
+	db *gorm.DB
+}
+
+func NewKeyRepository(db *gorm.DB) KeyRepository {
+	return &keyRepository{db}
+}
+
+func (r *keyRepository) GetDataKey(ctx context.Context, userID uint) (*models.DataKey, error) {
+	var key models.DataKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *keyRepository) CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error) {
+	// Two first uploads may race; the loser must use the winner's key.
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(key).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.GetDataKey(ctx, key.UserID)
+}
+
+func (r *keyRepository) ListDataKeysNotWrappedWith(ctx context.Context, keyID string) ([]*models.DataKey, error) {
+	var keys []*models.DataKey
+	err := r.db.WithContext(ctx).Where("key_id <> ?", keyID).Order("user_id").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UpdateWrappedKey replaces the wrapping only if the row is still wrapped
+// with oldKeyID, so concurrent re-wraps cannot clobber each other.
+func (r *keyRepository) UpdateWrappedKey(ctx context.Context, key *models.DataKey, oldKeyID string) error {
+	return r.db.WithContext(ctx).Model(&models.DataKey{}).
+		Where("user_id = ? AND key_id = ?", key.UserID, oldKeyID).
+		Updates(map[string]interface{}{"key_id": key.KeyID, "wrapped_key": key.WrappedKey}).Error
+}
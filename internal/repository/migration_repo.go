@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+// MigrationRepository tracks which backend holds each stored file while
+// files are copied between backends. Rows with no backend recorded count as
+// being on the backend migrated from.
+type MigrationRepository interface {
+	// ListImagesOn returns up to limit rows on backend with an ID above
+	// afterID, in ID order.
+	ListImagesOn(ctx context.Context, backend string, afterID uint, limit int) ([]*models.Image, error)
+	// SetBackend moves every image and blob row of the user that points at
+	// path from one backend to another.
+	SetBackend(ctx context.Context, userID uint, path, from, to string) error
+}
+
+type migrationRepository struct {
+	db *gorm.DB
+}
+
+func NewMigrationRepository(db *gorm.DB) MigrationRepository {
+	return &migrationRepository{db}
+}
+
+func (r *migrationRepository) ListImagesOn(ctx context.Context, backend string, afterID uint, limit int) ([]*models.Image, error) {
+	var images []*models.Image
+	err := r.db.WithContext(ctx).
+		Where("id > ? AND backend IN (?, '')", afterID, backend).
+		Order("id").Limit(limit).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (r *migrationRepository) SetBackend(ctx context.Context, userID uint, path, from, to string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		where := "user_id = ? AND path = ? AND backend IN (?, '')"
+		err := tx.Model(&models.Image{}).Where(where, userID, path, from).Update("backend", to).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Blob{}).Where(where, userID, path, from).Update("backend", to).Error
+	})
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrQuotaExceeded means a write would take the user over their quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrPlanNotFound means no plan has the given name.
+var ErrPlanNotFound = errors.New("plan not found")
+
+// CheckQuota reports whether adding one image of size bytes stays within the
+// quota in report.
+func CheckQuota(report *models.UsageReport, size int64) error {
+	q := report.Quota
+	if q.MaxImages > 0 && report.Images+1 > q.MaxImages {
+		return fmt.Errorf("%w: %d of %d images stored", ErrQuotaExceeded, report.Images, q.MaxImages)
+	}
+	if q.MaxBytes > 0 && report.Bytes+size > q.MaxBytes {
+		return fmt.Errorf("%w: %s of %s used, this image needs %s",
+			ErrQuotaExceeded, formatBytes(report.Bytes), formatBytes(q.MaxBytes), formatBytes(size))
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// usageReport reads the user's usage and the quota that applies to them:
+// their own overrides, then their plan, then DefaultPlan.
+func usageReport(tx *gorm.DB, userID uint) (*models.UsageReport, error) {
+	var user models.User
+	err := tx.Select("id", "plan", "quota_max_bytes", "quota_max_images").Where("id = ?", userID).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	report := &models.UsageReport{Plan: user.Plan}
+	if report.Plan == "" {
+		report.Plan = models.DefaultPlan
+	}
+
+	var plan models.Plan
+	err = tx.Where("name = ?", report.Plan).First(&plan).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	report.Quota = plan.Quota
+	if user.QuotaMaxBytes != nil {
+		report.Quota.MaxBytes = *user.QuotaMaxBytes
+	}
+	if user.QuotaMaxImages != nil {
+		report.Quota.MaxImages = *user.QuotaMaxImages
+	}
+
+	var usage models.Usage
+	err = tx.Where("user_id = ?", userID).First(&usage).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	report.Bytes, report.Images = usage.Bytes, usage.Images
+	return report, nil
+}
+
+// insertMissingUsage creates usage rows counted from the images table for
+// users who have images but no usage row yet, such as everyone who uploaded
+// before usage was tracked. Existing rows are left alone.
+const insertMissingUsage = `INSERT INTO usages (user_id, bytes, images, updated_at)
+	SELECT user_id, COALESCE(SUM(size), 0), COUNT(*), now() FROM images
+	WHERE deleted_at IS NULL
+	GROUP BY user_id
+	ON CONFLICT (user_id) DO NOTHING`
+
+// chargeUsage adds one image of size bytes to the user's usage, failing with
+// ErrQuotaExceeded instead if that would go over quota. The usage row is
+// locked so concurrent uploads cannot both squeeze under the limit. Users
+// who had images before usage was tracked got their row from BackfillUsage
+// at startup, so a missing row means no images yet.
+func chargeUsage(tx *gorm.DB, userID uint, size int64) error {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Usage{UserID: userID}).Error
+	if err != nil {
+		return err
+	}
+	var usage models.Usage
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&usage).Error
+	if err != nil {
+		return err
+	}
+	report, err := usageReport(tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := CheckQuota(report, size); err != nil {
+		return err
+	}
+	return tx.Model(&models.Usage{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"bytes":      gorm.Expr("bytes + ?", size),
+		"images":     gorm.Expr("images + 1"),
+		"updated_at": gorm.Expr("now()"),
+	}).Error
+}
+
+func refundUsage(tx *gorm.DB, userID uint, size int64) error {
+	return tx.Model(&models.Usage{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"bytes":      gorm.Expr("GREATEST(bytes - ?, 0)", size),
+		"images":     gorm.Expr("GREATEST(images - 1, 0)"),
+		"updated_at": gorm.Expr("now()"),
+	}).Error
+}
+
+// QuotaRepository is the admin side of quotas: plans, per-user settings and
+// rebuilding the usage counters.
+type QuotaRepository interface {
+	SavePlan(ctx context.Context, plan *models.Plan) error
+	ListPlans(ctx context.Context) ([]*models.Plan, error)
+	DeletePlan(ctx context.Context, name string) error
+	// SetUserQuota assigns plan (empty for DefaultPlan) and the overrides,
+	// where nil falls back to the plan's limit.
+	SetUserQuota(ctx context.Context, userID uint, plan string, maxBytes, maxImages *int64) error
+	GetUsage(ctx context.Context, userID uint) (*models.UsageReport, error)
+	// RecomputeUsage rebuilds every usage row from the images table and
+	// returns how many users have images.
+	RecomputeUsage(ctx context.Context) (int64, error)
+	// BackfillUsage creates the missing usage rows of users with images and
+	// returns how many it created. Existing rows are kept, so it is safe to
+	// run on every start.
+	BackfillUsage(ctx context.Context) (int64, error)
+}
+
+type quotaRepository struct {
+	db *gorm.DB
+}
+
+func NewQuotaRepository(db *gorm.DB) QuotaRepository {
+	return &quotaRepository{db}
+}
+
+func (r *quotaRepository) SavePlan(ctx context.Context, plan *models.Plan) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"max_bytes", "max_images", "updated_at"}),
+	}).Create(plan).Error
+}
+
+func (r *quotaRepository) ListPlans(ctx context.Context) ([]*models.Plan, error) {
+	var plans []*models.Plan
+	err := r.db.WithContext(ctx).Order("name").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (r *quotaRepository) DeletePlan(ctx context.Context, name string) error {
+	result := r.db.WithContext(ctx).Where("name = ?", name).Delete(&models.Plan{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPlanNotFound
+	}
+	return nil
+}
+
+func (r *quotaRepository) SetUserQuota(ctx context.Context, userID uint, plan string, maxBytes, maxImages *int64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if plan != "" && plan != models.DefaultPlan {
+			err := tx.Where("name = ?", plan).First(&models.Plan{}).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPlanNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+		result := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"plan":             plan,
+			"quota_max_bytes":  maxBytes,
+			"quota_max_images": maxImages,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *quotaRepository) GetUsage(ctx context.Context, userID uint) (*models.UsageReport, error) {
+	return usageReport(r.db.WithContext(ctx), userID)
+}
+
+func (r *quotaRepository) RecomputeUsage(ctx context.Context) (int64, error) {
+	var users int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("UPDATE usages SET bytes = 0, images = 0, updated_at = now()").Error
+		if err != nil {
+			return err
+		}
+		result := tx.Exec(`INSERT INTO usages (user_id, bytes, images, updated_at)
+			SELECT user_id, COALESCE(SUM(size), 0), COUNT(*), now() FROM images
+			WHERE deleted_at IS NULL GROUP BY user_id
+			ON CONFLICT (user_id) DO UPDATE SET bytes = excluded.bytes, images = excluded.images, updated_at = excluded.updated_at`)
+		users = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return users, nil
+}
+
+func (r *quotaRepository) BackfillUsage(ctx context.Context) (int64, error) {
+	result := r.db.WithContext(ctx).Exec(insertMissingUsage)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
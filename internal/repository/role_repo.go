@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRoleNotFound means no custom role has the given name.
+var ErrRoleNotFound = errors.New("role not found")
+
+type RoleRepository interface {
+	SaveRole(ctx context.Context, role *models.Role) error
+	// GetRole returns nil when there is no such custom role.
+	GetRole(ctx context.Context, name string) (*models.Role, error)
+	ListRoles(ctx context.Context) ([]*models.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{db}
+}
+
+func (r *roleRepository) SaveRole(ctx context.Context, role *models.Role) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permissions", "updated_at"}),
+	}).Create(role).Error
+}
+
+func (r *roleRepository) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepository) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	var roles []*models.Role
+	err := r.db.WithContext(ctx).Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// DeleteRole removes a custom role. Users who had it fall back to
+// models.RoleUser.
+func (r *roleRepository) DeleteRole(ctx context.Context, name string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("name = ?", name).Delete(&models.Role{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRoleNotFound
+		}
+		return tx.Model(&models.User{}).Where("role = ?", name).Update("role", "").Error
+	})
+}
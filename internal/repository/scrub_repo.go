@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+// ScrubRepository is what the storage scrub needs from the database: every
+// user that may own files, their image rows, and a place to flag broken rows.
+type ScrubRepository interface {
+	ListOwnerIDs(ctx context.Context) ([]uint, error)
+	ListImagesByUser(ctx context.Context, userID uint) ([]*models.Image, error)
+	SetImageStatus(ctx context.Context, imageID uint, status string) error
+}
+
+type scrubRepository struct {
+	db *gorm.DB
+}
+
+func NewScrubRepository(db *gorm.DB) ScrubRepository {
+	return &scrubRepository{db}
+}
+
+// ListOwnerIDs includes users that only appear on image rows, so files of a
+// deleted account are still scrubbed.
+func (r *scrubRepository) ListOwnerIDs(ctx context.Context) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).
+		Raw("SELECT id FROM users UNION SELECT DISTINCT user_id FROM images WHERE deleted_at IS NULL ORDER BY 1").
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *scrubRepository) ListImagesByUser(ctx context.Context, userID uint) ([]*models.Image, error) {
+	var images []*models.Image
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (r *scrubRepository) SetImageStatus(ctx context.Context, imageID uint, status string) error {
+	return r.db.WithContext(ctx).Model(&models.Image{}).Where("id = ?", imageID).Update("status", status).Error
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+type SigningKeyRepository interface {
+	// ListSigningKeys returns the keys not expired at now, oldest first.
+	ListSigningKeys(ctx context.Context, now time.Time) ([]*models.SigningKey, error)
+	CreateSigningKey(ctx context.Context, key *models.SigningKey) error
+	DeleteExpiredSigningKeys(ctx context.Context, now time.Time) error
+	// RewrapSigningKey replaces the private key's wrapping only if it is
+	// still wrapped with oldKeyID, like UpdateWrappedKey.
+	RewrapSigningKey(ctx context.Context, key *models.SigningKey, oldKeyID string) error
+}
+
+type signingKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db}
+}
+
+func (r *signingKeyRepository) ListSigningKeys(ctx context.Context, now time.Time) ([]*models.SigningKey, error) {
+	var keys []*models.SigningKey
+	err := r.db.WithContext(ctx).Where("expires_at > ?", now).Order("activates_at").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *signingKeyRepository) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *signingKeyRepository) DeleteExpiredSigningKeys(ctx context.Context, now time.Time) error {
+	return r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.SigningKey{}).Error
+}
+
+func (r *signingKeyRepository) RewrapSigningKey(ctx context.Context, key *models.SigningKey, oldKeyID string) error {
+	return r.db.WithContext(ctx).Model(&models.SigningKey{}).
+		Where("kid = ? AND key_id = ?", key.KID, oldKeyID).
+		Updates(map[string]interface{}{"key_id": key.KeyID, "private_key": key.PrivateKey}).Error
+}
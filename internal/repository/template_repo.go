@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"gorm.io/gorm"
+)
+
+type TemplateRepository interface {
+	CreateTemplate(ctx context.Context, template *models.Template) error
+	ListTemplates(ctx context.Context, userID uint) ([]*models.Template, error)
+	GetTemplate(ctx context.Context, templateID string, userID uint) (*models.Template, error)
+}
+
+type templateRepository struct {
+	db *gorm.DB
+}
+
+func NewTemplateRepository(db *gorm.DB) TemplateRepository {
+	return &templateRepository{db}
+}
+
+func (r *templateRepository) CreateTemplate(ctx context.Context, template *models.Template) error {
+	return r.db.WithContext(ctx).Create(template).Error
+}
+
+func (r *templateRepository) ListTemplates(ctx context.Context, userID uint) ([]*models.Template, error) {
+	var templates []*models.Template
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&templates).Error
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (r *templateRepository) GetTemplate(ctx context.Context, templateID string, userID uint) (*models.Template, error) {
+	var template models.Template
+	err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", templateID, userID).First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
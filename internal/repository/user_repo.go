@@ -2,19 +2,48 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"strconv"
+	"time"
 
 	"github.com/HarshithRajesh/PixelForge/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrImageNotFound means no image with that ID belongs to the user.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrBlobGone means an image was saved to share a blob that no longer
+// exists, because the last image using it was deleted meanwhile. The
+// content has to be stored again.
+var ErrBlobGone = errors.New("blob no longer exists")
+
 type UserRepository interface {
-	CreateUser(user *models.User) error
-	GetUser(email string) (*models.User, error)
-	SaveImageDB(metadata *models.Image) error
-	GetAllImageData(userID uint) ([]*models.Image, error)
-	GetImage(imageID string, userID string) (*models.Image, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUser(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
+	UpdatePassword(ctx context.Context, userID string, hash string) error
+	MarkEmailVerified(ctx context.Context, userID string, at time.Time) error
+	// SetTOTPSecret starts an enrollment, leaving TOTP disabled.
+	SetTOTPSecret(ctx context.Context, userID string, secret string) error
+	// EnableTOTP turns TOTP on and replaces the user's recovery codes.
+	EnableTOTP(ctx context.Context, userID string, at time.Time, codes []*models.RecoveryCode) error
+	DisableTOTP(ctx context.Context, userID string) error
+	ListRecoveryCodes(ctx context.Context, userID string) ([]*models.RecoveryCode, error)
+	// UseRecoveryCode marks a code used, reporting false if it already was.
+	UseRecoveryCode(ctx context.Context, codeID uint, at time.Time) (bool, error)
+	// GetExternalIdentity returns nil when the identity is not linked.
+	GetExternalIdentity(ctx context.Context, issuer, subject string) (*models.ExternalIdentity, error)
+	CreateExternalIdentity(ctx context.Context, identity *models.ExternalIdentity) error
+	UpdateMetadataPolicy(ctx context.Context, userID string, policy models.MetadataPolicy) error
+	SaveImageDB(ctx context.Context, metadata *models.Image) error
+	GetAllImageData(ctx context.Context, userID uint) ([]*models.Image, error)
+	GetImage(ctx context.Context, imageID string, userID string) (*models.Image, error)
+	GetBlob(ctx context.Context, userID uint, hash string) (*models.Blob, error)
+	DeleteImage(ctx context.Context, imageID string, userID string) (string, error)
+	GetUsage(ctx context.Context, userID uint) (*models.UsageReport, error)
 }
 
 type userRepository struct {
@@ -25,13 +54,13 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db}
 }
 
-func (r *userRepository) CreateUser(user *models.User) error {
-	return r.db.Create(user).Error
+func (r *userRepository) CreateUser(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *userRepository) GetUser(email string) (*models.User, error) {
+func (r *userRepository) GetUser(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email=?", email).First(&user).Error
+	err := r.db.WithContext(ctx).Where("email=?", email).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -41,13 +70,155 @@ func (r *userRepository) GetUser(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (r *userRepository) SaveImageDB(metadata *models.Image) error {
-	return r.db.Create(metadata).Error
+func (r *userRepository) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) UpdatePassword(ctx context.Context, userID string, hash string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("password", hash).Error
+}
+
+func (r *userRepository) MarkEmailVerified(ctx context.Context, userID string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", at).Error
+}
+
+func (r *userRepository) SetTOTPSecret(ctx context.Context, userID string, secret string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+	}).Error
+}
+
+func (r *userRepository) EnableTOTP(ctx context.Context, userID string, at time.Time, codes []*models.RecoveryCode) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Update("totp_enabled_at", at).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+func (r *userRepository) DisableTOTP(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (r *userRepository) ListRecoveryCodes(ctx context.Context, userID string) ([]*models.RecoveryCode, error) {
+	var codes []*models.RecoveryCode
+	err := r.db.WithContext(ctx).Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *userRepository) UseRecoveryCode(ctx context.Context, codeID uint, at time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", codeID).Update("used_at", at)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *userRepository) GetExternalIdentity(ctx context.Context, issuer, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	err := r.db.WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *userRepository) CreateExternalIdentity(ctx context.Context, identity *models.ExternalIdentity) error {
+	return r.db.WithContext(ctx).Create(identity).Error
+}
+
+func (r *userRepository) UpdateMetadataPolicy(ctx context.Context, userID string, policy models.MetadataPolicy) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"metadata_keep_copyright":   policy.KeepCopyright,
+		"metadata_keep_orientation": policy.KeepOrientation,
+	}).Error
 }
 
-func (r *userRepository) GetAllImageData(userID uint) ([]*models.Image, error) {
+// SaveImageDB stores the image row and, for content-addressed images, takes
+// a reference on its blob in the same transaction. The image is charged to
+// the user's usage and refused with ErrQuotaExceeded if it does not fit.
+func (r *userRepository) SaveImageDB(ctx context.Context, metadata *models.Image) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := chargeUsage(tx, metadata.UserID, int64(metadata.Size)); err != nil {
+			return err
+		}
+		if metadata.Hash != "" {
+			if err := takeBlobReference(tx, metadata); err != nil {
+				return err
+			}
+		}
+		return tx.Create(metadata).Error
+	})
+}
+
+// takeBlobReference counts a reference on the blob of image and points image
+// at the blob's file. An image with a Path brings a file the caller just
+// stored, which becomes the blob unless a concurrent save of the same content
+// created it first. An image without one shares an existing blob and gets
+// ErrBlobGone if that was deleted. The blob row is locked, so DeleteImage
+// cannot drop it between the check and the increment.
+func takeBlobReference(tx *gorm.DB, image *models.Image) error {
+	if image.Path != "" {
+		blob := &models.Blob{
+			UserID:   image.UserID,
+			Hash:     image.Hash,
+			Path:     image.Path,
+			Backend:  image.Backend,
+			Size:     image.Size,
+			Checksum: image.Checksum,
+			RefCount: 1,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(blob)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil
+		}
+	}
+
+	var blob models.Blob
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND hash = ?", image.UserID, image.Hash).First(&blob).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrBlobGone
+		}
+		return err
+	}
+	image.Path, image.Backend, image.Checksum = blob.Path, blob.Backend, blob.Checksum
+	return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+func (r *userRepository) GetAllImageData(ctx context.Context, userID uint) ([]*models.Image, error) {
 	var images []*models.Image
-	err := r.db.Where("user_id=?", userID).Find(&images).Error
+	err := r.db.WithContext(ctx).Where("user_id=?", userID).Find(&images).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -57,14 +228,14 @@ func (r *userRepository) GetAllImageData(userID uint) ([]*models.Image, error) {
 	return images, nil
 }
 
-func (r *userRepository) GetImage(imageID string, userID string) (*models.Image, error) {
+func (r *userRepository) GetImage(ctx context.Context, imageID string, userID string) (*models.Image, error) {
 	newuserID, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
 		return nil, err
 	}
 	nuserID := uint(newuserID)
 	var image *models.Image
-	result := r.db.Where("id = ? AND user_id = ?", imageID, nuserID).First(&image)
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", imageID, nuserID).First(&image)
 
 	if result.Error != nil {
 		return nil, result.Error
@@ -72,3 +243,62 @@ func (r *userRepository) GetImage(imageID string, userID string) (*models.Image,
 
 	return image, nil
 }
+
+func (r *userRepository) GetBlob(ctx context.Context, userID uint, hash string) (*models.Blob, error) {
+	var blob models.Blob
+	err := r.db.WithContext(ctx).Where("user_id = ? AND hash = ?", userID, hash).First(&blob).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// DeleteImage removes the image row and drops its blob reference. It returns
+// the storage path to delete once nothing refers to the file any more, or ""
+// while other images still share it.
+func (r *userRepository) DeleteImage(ctx context.Context, imageID string, userID string) (string, error) {
+	var orphan string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var image models.Image
+		if err := tx.Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrImageNotFound
+			}
+			return err
+		}
+		if err := tx.Delete(&image).Error; err != nil {
+			return err
+		}
+		if err := refundUsage(tx, image.UserID, int64(image.Size)); err != nil {
+			return err
+		}
+		if image.Hash == "" {
+			// Files stored before content addressing belong to one image.
+			orphan = image.Path
+			return nil
+		}
+
+		var blob models.Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND hash = ?", image.UserID, image.Hash).First(&blob).Error
+		if err != nil {
+			return err
+		}
+		if blob.RefCount > 1 {
+			return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+		}
+		orphan = blob.Path
+		return tx.Delete(&blob).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return orphan, nil
+}
+
+func (r *userRepository) GetUsage(ctx context.Context, userID uint) (*models.UsageReport, error) {
+	return usageReport(r.db.WithContext(ctx), userID)
+}
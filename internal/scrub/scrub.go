@@ -0,0 +1,230 @@
+// Package scrub cross-checks stored files against the images table.
+package scrub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/storage"
+)
+
+type Options struct {
+	// Repair deletes orphaned files and sets the status of broken rows.
+	Repair bool
+	// DryRun reports what Repair would do without changing anything.
+	DryRun bool
+	// MinAge spares files younger than this from orphan cleanup: an upload
+	// writes its file before its row, so a fresh file may not be orphaned.
+	MinAge time.Duration
+}
+
+// Finding is one problem the scrub found. Action says what was done about
+// it, or would be done in a dry run, and Error why that failed.
+type Finding struct {
+	UserID  uint   `json:"user_id"`
+	ImageID uint   `json:"image_id,omitempty"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Repair   bool      `json:"repair"`
+	DryRun   bool      `json:"dry_run"`
+	Users    int       `json:"users"`
+	Files    int       `json:"files"`
+	Images   int       `json:"images"`
+	Orphans  []Finding `json:"orphans"`
+	Missing  []Finding `json:"missing"`
+	Corrupt  []Finding `json:"corrupt"`
+	Restored []Finding `json:"restored"`
+	// Unreadable lists files that could not be checked and storage entries
+	// that are not a user's space.
+	Unreadable []Finding `json:"unreadable"`
+}
+
+// Clean reports whether the scrub found nothing wrong.
+func (r *Report) Clean() bool {
+	return len(r.Orphans) == 0 && len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.Unreadable) == 0
+}
+
+type Scrubber struct {
+	repo  repository.ScrubRepository
+	store storage.StorageRepository
+	opts  Options
+	now   func() time.Time
+}
+
+func New(repo repository.ScrubRepository, store storage.StorageRepository, opts Options) *Scrubber {
+	return &Scrubber{repo: repo, store: store, opts: opts, now: time.Now}
+}
+
+func (s *Scrubber) Run(ctx context.Context) (*Report, error) {
+	report := &Report{
+		Repair:     s.opts.Repair,
+		DryRun:     s.opts.DryRun,
+		Orphans:    []Finding{},
+		Missing:    []Finding{},
+		Corrupt:    []Finding{},
+		Restored:   []Finding{},
+		Unreadable: []Finding{},
+	}
+	owners, err := s.owners(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	for _, uid := range owners {
+		if err := s.scrubUser(ctx, uid, report); err != nil {
+			return nil, fmt.Errorf("user %d: %w", uid, err)
+		}
+		report.Users++
+	}
+	return report, nil
+}
+
+// owners merges the users the database knows with the user spaces found in
+// storage, so files left behind by an account that is gone are scrubbed too.
+func (s *Scrubber) owners(ctx context.Context, report *Report) ([]uint, error) {
+	known, err := s.repo.ListOwnerIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	spaces, err := s.store.Owners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage owners: %w", err)
+	}
+	seen := map[uint]bool{}
+	for _, uid := range known {
+		seen[uid] = true
+	}
+	owners := known
+	for _, space := range spaces {
+		uid, err := strconv.ParseUint(space, 10, 64)
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, Finding{Path: space + "/", Error: "not a user ID"})
+			continue
+		}
+		if !seen[uint(uid)] {
+			seen[uint(uid)] = true
+			owners = append(owners, uint(uid))
+		}
+	}
+	slices.Sort(owners)
+	return owners, nil
+}
+
+func (s *Scrubber) scrubUser(ctx context.Context, uid uint, report *Report) error {
+	userID := strconv.FormatUint(uint64(uid), 10)
+	files, err := s.store.List(ctx, "", userID)
+	if err != nil {
+		return err
+	}
+	images, err := s.repo.ListImagesByUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	report.Files += len(files)
+	report.Images += len(images)
+
+	stored := map[string]storage.FileInfo{}
+	for _, f := range files {
+		stored[f.Path] = f
+	}
+	referenced := map[string]bool{}
+	for _, img := range images {
+		referenced[img.Path] = true
+
+		if _, ok := stored[img.Path]; !ok {
+			report.Missing = append(report.Missing, s.markImage(ctx, img, models.ImageStatusMissing))
+			continue
+		}
+		intact, err := s.verify(ctx, img, userID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			report.Unreadable = append(report.Unreadable, Finding{UserID: img.UserID, ImageID: img.ID, Path: img.Path, Error: err.Error()})
+			continue
+		}
+		switch {
+		case !intact:
+			report.Corrupt = append(report.Corrupt, s.markImage(ctx, img, models.ImageStatusCorrupt))
+		case img.Status != "":
+			// The file was put back since an earlier scrub flagged the row.
+			report.Restored = append(report.Restored, s.markImage(ctx, img, ""))
+		}
+	}
+
+	for _, f := range files {
+		if referenced[f.Path] || s.now().Sub(f.ModTime) < s.opts.MinAge {
+			continue
+		}
+		report.Orphans = append(report.Orphans, s.deleteOrphan(ctx, uid, f))
+	}
+	return nil
+}
+
+// verify reads the whole file back against the checksum recorded when it
+// was written. Rows from before checksums were recorded always pass.
+func (s *Scrubber) verify(ctx context.Context, img *models.Image, userID string) (bool, error) {
+	if img.Checksum == "" {
+		return true, nil
+	}
+	r, err := storage.OpenVerified(ctx, s.store, img.Path, userID, img.Checksum)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	if errors.Is(err, storage.ErrChecksumMismatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Scrubber) markImage(ctx context.Context, img *models.Image, status string) Finding {
+	f := Finding{UserID: img.UserID, ImageID: img.ID, Path: img.Path}
+	if !s.opts.Repair || img.Status == status {
+		return f
+	}
+	f.Action = "mark " + statusName(status)
+	if s.opts.DryRun {
+		f.Action = "would " + f.Action
+		return f
+	}
+	if err := s.repo.SetImageStatus(ctx, img.ID, status); err != nil {
+		f.Error = err.Error()
+	}
+	return f
+}
+
+func (s *Scrubber) deleteOrphan(ctx context.Context, uid uint, file storage.FileInfo) Finding {
+	f := Finding{UserID: uid, Path: file.Path, Size: file.Size}
+	if !s.opts.Repair {
+		return f
+	}
+	f.Action = "delete"
+	if s.opts.DryRun {
+		f.Action = "would " + f.Action
+		return f
+	}
+	if err := s.store.Delete(ctx, file.Path, strconv.FormatUint(uint64(uid), 10)); err != nil {
+		f.Error = err.Error()
+	}
+	return f
+}
+
+func statusName(status string) string {
+	if status == "" {
+		return "ok"
+	}
+	return status
+}
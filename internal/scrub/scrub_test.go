@@ -0,0 +1,197 @@
+package scrub_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/scrub"
+	"github.com/HarshithRajesh/PixelForge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockScrubRepository struct {
+	mock.Mock
+}
+
+func (m *MockScrubRepository) ListOwnerIDs(ctx context.Context) ([]uint, error) {
+	args := m.Called()
+	return args.Get(0).([]uint), args.Error(1)
+}
+
+func (m *MockScrubRepository) ListImagesByUser(ctx context.Context, userID uint) ([]*models.Image, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]*models.Image), args.Error(1)
+}
+
+func (m *MockScrubRepository) SetImageStatus(ctx context.Context, imageID uint, status string) error {
+	return m.Called(imageID, status).Error(0)
+}
+
+func image(id uint, path, checksum, status string) *models.Image {
+	img := &models.Image{UserID: 1, Path: path, Checksum: checksum, Status: status}
+	img.ID = id
+	return img
+}
+
+// fixture stores one file of each kind for user 1 and returns the rows
+// pointing at them.
+func fixture(t *testing.T, root string) []*models.Image {
+	write := func(path, content string, age time.Duration) {
+		full := filepath.Join(root, "1", path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0o644))
+		old := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(full, old, old))
+	}
+	write("blobs/aa/good", "good", 48*time.Hour)
+	write("blobs/bb/rotten", "rotten!", 48*time.Hour)
+	write("blobs/cc/back", "back", 48*time.Hour)
+	write("legacy.png", "legacy", 48*time.Hour)
+	write("blobs/dd/orphan", "orphan", 48*time.Hour)
+	write("blobs/ee/fresh", "fresh", time.Minute)
+
+	return []*models.Image{
+		image(1, "blobs/aa/good", storage.Checksum([]byte("good")), ""),
+		image(2, "blobs/bb/rotten", storage.Checksum([]byte("rotten")), ""),
+		image(3, "blobs/ff/gone", storage.Checksum([]byte("gone")), ""),
+		image(4, "blobs/cc/back", storage.Checksum([]byte("back")), models.ImageStatusMissing),
+		image(5, "legacy.png", "", ""),
+	}
+}
+
+func paths(findings []scrub.Finding) []string {
+	out := []string{}
+	for _, f := range findings {
+		out = append(out, f.Path)
+	}
+	return out
+}
+
+func TestScrub(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        scrub.Options
+		setup       func(m *MockScrubRepository)
+		wantAction  string
+		wantDeleted bool
+	}{
+		{
+			name:  "report only",
+			opts:  scrub.Options{MinAge: time.Hour},
+			setup: func(m *MockScrubRepository) {},
+		},
+		{
+			name:       "dry run",
+			opts:       scrub.Options{Repair: true, DryRun: true, MinAge: time.Hour},
+			setup:      func(m *MockScrubRepository) {},
+			wantAction: "would delete",
+		},
+		{
+			name: "repair",
+			opts: scrub.Options{Repair: true, MinAge: time.Hour},
+			setup: func(m *MockScrubRepository) {
+				m.On("SetImageStatus", uint(2), models.ImageStatusCorrupt).Return(nil)
+				m.On("SetImageStatus", uint(3), models.ImageStatusMissing).Return(nil)
+				m.On("SetImageStatus", uint(4), "").Return(nil)
+			},
+			wantAction:  "delete",
+			wantDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			repo := new(MockScrubRepository)
+			repo.On("ListOwnerIDs").Return([]uint{1}, nil)
+			repo.On("ListImagesByUser", uint(1)).Return(fixture(t, root), nil)
+			tt.setup(repo)
+
+			report, err := scrub.New(repo, storage.NewStorageRepository(root), tt.opts).Run(context.Background())
+			require.NoError(t, err)
+
+			assert.Equal(t, 6, report.Files)
+			assert.Equal(t, 5, report.Images)
+			assert.Equal(t, []string{"blobs/dd/orphan"}, paths(report.Orphans), "fresh files are spared")
+			assert.Equal(t, []string{"blobs/ff/gone"}, paths(report.Missing))
+			assert.Equal(t, []string{"blobs/bb/rotten"}, paths(report.Corrupt))
+			assert.Equal(t, []string{"blobs/cc/back"}, paths(report.Restored))
+			assert.Equal(t, tt.wantAction, report.Orphans[0].Action)
+			assert.False(t, report.Clean())
+
+			_, err = os.Stat(filepath.Join(root, "1", "blobs/dd/orphan"))
+			assert.Equal(t, tt.wantDeleted, os.IsNotExist(err))
+			repo.AssertExpectations(t)
+			if !tt.wantDeleted {
+				repo.AssertNotCalled(t, "SetImageStatus", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestScrubDuringMigration(t *testing.T) {
+	primary, old := t.TempDir(), t.TempDir()
+	images := fixture(t, old)
+	repo := new(MockScrubRepository)
+	repo.On("ListOwnerIDs").Return([]uint{1}, nil)
+	repo.On("ListImagesByUser", uint(1)).Return(images, nil)
+	store := storage.NewFallbackStorage(storage.NewStorageRepository(primary), storage.NewStorageRepository(old))
+
+	report, err := scrub.New(repo, store, scrub.Options{MinAge: time.Hour}).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blobs/ff/gone"}, paths(report.Missing), "files not migrated yet are not missing")
+	assert.Equal(t, []string{"blobs/bb/rotten"}, paths(report.Corrupt))
+}
+
+// unreadable fails to open one path, the way a file on a broken disk or one
+// the data key no longer decrypts would.
+type unreadable struct {
+	storage.StorageRepository
+	path string
+}
+
+func (u unreadable) Open(ctx context.Context, path string, userID string) (io.ReadCloser, error) {
+	if path == u.path {
+		return nil, errors.New("input/output error")
+	}
+	return u.StorageRepository.Open(ctx, path, userID)
+}
+
+func TestScrubKeepsGoing(t *testing.T) {
+	root := t.TempDir()
+	images := fixture(t, root)
+	for _, dir := range []string{"7", "tmp"} {
+		full := filepath.Join(root, dir, "left-behind.png")
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte("x"), 0o644))
+		old := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(full, old, old))
+	}
+
+	repo := new(MockScrubRepository)
+	repo.On("ListOwnerIDs").Return([]uint{1}, nil)
+	repo.On("ListImagesByUser", uint(1)).Return(images, nil)
+	repo.On("ListImagesByUser", uint(7)).Return([]*models.Image{}, nil)
+	store := unreadable{storage.NewStorageRepository(root), "blobs/aa/good"}
+
+	report, err := scrub.New(repo, store, scrub.Options{MinAge: time.Hour}).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Users, "user 7 only exists in storage")
+	assert.Equal(t, []string{"blobs/dd/orphan", "left-behind.png"}, paths(report.Orphans))
+	assert.Equal(t, uint(7), report.Orphans[1].UserID)
+	assert.Equal(t, []string{"blobs/bb/rotten"}, paths(report.Corrupt), "files after the unreadable one are still checked")
+	require.Equal(t, []string{"tmp/", "blobs/aa/good"}, paths(report.Unreadable))
+	assert.Equal(t, "not a user ID", report.Unreadable[0].Error)
+	assert.Equal(t, uint(1), report.Unreadable[1].ImageID)
+	assert.Equal(t, "input/output error", report.Unreadable[1].Error)
+	assert.False(t, report.Clean())
+}
@@ -0,0 +1,344 @@
+// Package signing keeps the rotating set of keys JWTs are signed and
+// verified with, and publishes its public half as a JWKS.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/keys"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey means no key is active yet; Rotate has not run.
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// Methods are the signing algorithms a KeySet may produce.
+var Methods = []string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}
+
+type key struct {
+	kid         string
+	method      jwt.SigningMethod
+	private     crypto.Signer
+	activatesAt time.Time
+	expiresAt   time.Time
+}
+
+// KeySet signs with the newest active key and verifies with any published
+// one. Keys live in the database so every replica shares them; each replica
+// reloads them periodically and whichever notices first creates the next.
+// With master keys configured, private keys are wrapped before they are
+// stored.
+type KeySet struct {
+	repo repository.SigningKeyRepository
+	cfg  config.Signing
+	enc  config.Encryption
+	now  func() time.Time
+
+	mu   sync.RWMutex
+	keys []*key // by activation, oldest first
+}
+
+func NewKeySet(repo repository.SigningKeyRepository, cfg config.Signing, enc config.Encryption) *KeySet {
+	return &KeySet{repo: repo, cfg: cfg, enc: enc, now: time.Now}
+}
+
+// Load replaces the in-memory keys with the published ones.
+func (k *KeySet) Load(ctx context.Context) error {
+	rows, err := k.repo.ListSigningKeys(ctx, k.now())
+	if err != nil {
+		return err
+	}
+	keys := make([]*key, 0, len(rows))
+	for _, row := range rows {
+		parsed, err := k.parseKey(row)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", row.KID, err)
+		}
+		keys = append(keys, parsed)
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// Rotate reloads the keys and, when the active key is due for replacement,
+// publishes its successor to take over PublishLead from now. With no usable
+// key at all, the new key signs immediately. Keys not yet wrapped with the
+// active master key are re-wrapped first.
+func (k *KeySet) Rotate(ctx context.Context) error {
+	if err := k.rewrap(ctx); err != nil {
+		return err
+	}
+	if err := k.Load(ctx); err != nil {
+		return err
+	}
+	now := k.now()
+	k.mu.RLock()
+	var newest *key
+	if len(k.keys) > 0 {
+		newest = k.keys[len(k.keys)-1]
+	}
+	_, active := k.signer(now)
+	k.mu.RUnlock()
+
+	if newest != nil && now.Before(newest.activatesAt.Add(k.cfg.RotateEvery-k.cfg.PublishLead)) {
+		return nil
+	}
+	activatesAt := now.Add(k.cfg.PublishLead)
+	if active != nil {
+		// the successor must verify everything signed until it takes over
+		if active.expiresAt.Before(activatesAt.Add(k.cfg.TokenLifetime)) {
+			activatesAt = now
+		}
+	} else {
+		activatesAt = now
+	}
+	row, err := generateKey(k.cfg.Alg, activatesAt, activatesAt.Add(k.cfg.RotateEvery+k.cfg.PublishLead+k.cfg.TokenLifetime))
+	if err != nil {
+		return err
+	}
+	if err := k.wrap(row, row.PrivateKey); err != nil {
+		return err
+	}
+	if err := k.repo.CreateSigningKey(ctx, row); err != nil {
+		return err
+	}
+	if err := k.repo.DeleteExpiredSigningKeys(ctx, now); err != nil {
+		return err
+	}
+	return k.Load(ctx)
+}
+
+// rewrap wraps keys stored in the clear, or with a retired master key,
+// with the active master key.
+func (k *KeySet) rewrap(ctx context.Context) error {
+	if !k.enc.Enabled() {
+		return nil
+	}
+	rows, err := k.repo.ListSigningKeys(ctx, k.now())
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.KeyID == k.enc.ActiveKeyID {
+			continue
+		}
+		der, err := k.unwrap(row)
+		if err != nil {
+			return err
+		}
+		oldKeyID := row.KeyID
+		if err := k.wrap(row, der); err != nil {
+			return err
+		}
+		if err := k.repo.RewrapSigningKey(ctx, row, oldKeyID); err != nil {
+			return fmt.Errorf("signing key %s: %w", row.KID, err)
+		}
+	}
+	return nil
+}
+
+// wrap stores der as row's private key, wrapped when master keys are
+// configured. The kid is bound in, so a wrapped key moved to another row
+// will not open.
+func (k *KeySet) wrap(row *models.SigningKey, der []byte) error {
+	if !k.enc.Enabled() {
+		row.KeyID, row.PrivateKey = "", der
+		return nil
+	}
+	keyID, wrapped, err := keys.Wrap(k.enc, der, signingAD(row.KID))
+	if err != nil {
+		return err
+	}
+	row.KeyID, row.PrivateKey = keyID, wrapped
+	return nil
+}
+
+// unwrap returns row's private key as PKCS #8 DER.
+func (k *KeySet) unwrap(row *models.SigningKey) ([]byte, error) {
+	if row.KeyID == "" {
+		return row.PrivateKey, nil
+	}
+	der, err := keys.Unwrap(k.enc, row.KeyID, row.PrivateKey, signingAD(row.KID))
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", row.KID, err)
+	}
+	return der, nil
+}
+
+func signingAD(kid string) []byte {
+	return []byte("pixelforge-signing-key:" + kid)
+}
+
+// Run rotates every interval until ctx is done.
+func (k *KeySet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Rotate(ctx); err != nil {
+				log.Printf("signing key rotation: %v", err)
+			}
+		}
+	}
+}
+
+// signer returns the newest key that has activated and not expired. The
+// caller holds mu.
+func (k *KeySet) signer(now time.Time) (int, *key) {
+	for n := len(k.keys) - 1; n >= 0; n-- {
+		key := k.keys[n]
+		if !now.Before(key.activatesAt) && now.Before(key.expiresAt) {
+			return n, key
+		}
+	}
+	return -1, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	_, key := k.signer(k.now())
+	k.mu.RUnlock()
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc finds the public key for a token by its kid, for jwt.Parse.
+func (k *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.kid != kid {
+			continue
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		if k.now().After(key.expiresAt) {
+			return nil, errors.New("signing key expired")
+		}
+		return key.private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists every published key, including one not yet signing, so
+// verifiers learn of it before the first token it signs.
+func (k *KeySet) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{Use: "sig", Kid: key.kid, Alg: key.method.Alg()}
+		switch pub := key.private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", b64(pub)
+		case *rsa.PublicKey:
+			jwk.Kty, jwk.N, jwk.E = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func generateKey(alg string, activatesAt, expiresAt time.Time) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(pub)
+	return &models.SigningKey{
+		KID:         b64(sum[:12]),
+		Alg:         alg,
+		PrivateKey:  der,
+		ActivatesAt: activatesAt,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+func (k *KeySet) parseKey(row *models.SigningKey) (*key, error) {
+	der, err := k.unwrap(row)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	parsedKey := &key{kid: row.KID, activatesAt: row.ActivatesAt, expiresAt: row.ExpiresAt}
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		parsedKey.method, parsedKey.private = jwt.SigningMethodEdDSA, private
+	case *rsa.PrivateKey:
+		parsedKey.method, parsedKey.private = jwt.SigningMethodRS256, private
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+	if parsedKey.method.Alg() != row.Alg {
+		return nil, fmt.Errorf("key type does not match %s", row.Alg)
+	}
+	return parsedKey, nil
+}
@@ -0,0 +1,218 @@
+package signing_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeys is a SigningKeyRepository over a map.
+type memoryKeys map[string]*models.SigningKey
+
+func (m memoryKeys) ListSigningKeys(ctx context.Context, now time.Time) ([]*models.SigningKey, error) {
+	var out []*models.SigningKey
+	for _, key := range m {
+		if key.ExpiresAt.After(now) {
+			out = append(out, key)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ActivatesAt.Before(out[j].ActivatesAt) })
+	return out, nil
+}
+
+func (m memoryKeys) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	m[key.KID] = key
+	return nil
+}
+
+func (m memoryKeys) DeleteExpiredSigningKeys(ctx context.Context, now time.Time) error {
+	for kid, key := range m {
+		if !key.ExpiresAt.After(now) {
+			delete(m, kid)
+		}
+	}
+	return nil
+}
+
+func (m memoryKeys) RewrapSigningKey(ctx context.Context, key *models.SigningKey, oldKeyID string) error {
+	if stored, ok := m[key.KID]; ok && stored.KeyID == oldKeyID {
+		stored.KeyID, stored.PrivateKey = key.KeyID, key.PrivateKey
+	}
+	return nil
+}
+
+// age moves every key back by d, as if d had passed.
+func (m memoryKeys) age(d time.Duration) {
+	for _, key := range m {
+		key.ActivatesAt = key.ActivatesAt.Add(-d)
+		key.ExpiresAt = key.ExpiresAt.Add(-d)
+	}
+}
+
+var cfg = config.Signing{
+	Alg:           "EdDSA",
+	RotateEvery:   time.Hour,
+	PublishLead:   10 * time.Minute,
+	TokenLifetime: time.Hour,
+}
+
+func sign(t *testing.T, ks *signing.KeySet) (string, string) {
+	t.Helper()
+	tok, err := ks.Sign(jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(tok, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	return tok, parsed.Header["kid"].(string)
+}
+
+func verify(ks *signing.KeySet, tok string) error {
+	_, err := jwt.NewParser(jwt.WithValidMethods(signing.Methods)).Parse(tok, ks.Keyfunc)
+	return err
+}
+
+func TestKeySetRotation(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryKeys{}
+	ks := signing.NewKeySet(repo, cfg, config.Encryption{})
+
+	_, err := ks.Sign(jwt.RegisteredClaims{})
+	assert.ErrorIs(t, err, signing.ErrNoSigningKey)
+
+	require.NoError(t, ks.Rotate(ctx))
+	require.Len(t, repo, 1)
+	first, firstKID := sign(t, ks)
+	require.NoError(t, verify(ks, first))
+
+	require.NoError(t, ks.Rotate(ctx))
+	assert.Len(t, repo, 1, "the key is not due for rotation yet")
+
+	// Within PublishLead of the rotation, the successor is published but
+	// does not sign yet.
+	repo.age(55 * time.Minute)
+	require.NoError(t, ks.Rotate(ctx))
+	require.Len(t, repo, 2)
+	assert.Len(t, ks.JWKS().Keys, 2)
+	_, kid := sign(t, ks)
+	assert.Equal(t, firstKID, kid)
+
+	repo.age(10 * time.Minute)
+	require.NoError(t, ks.Load(ctx))
+	second, secondKID := sign(t, ks)
+	assert.NotEqual(t, firstKID, secondKID)
+	assert.NoError(t, verify(ks, second))
+	assert.NoError(t, verify(ks, first), "the retired key still verifies")
+
+	// Once its tokens can no longer be valid, the retired key is dropped.
+	repo.age(2 * time.Hour)
+	require.NoError(t, ks.Rotate(ctx))
+	assert.NotContains(t, repo, firstKID)
+	assert.Error(t, verify(ks, first))
+}
+
+func TestKeySetStaleKeyIsReplacedAtOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryKeys{}
+	ks := signing.NewKeySet(repo, cfg, config.Encryption{})
+	require.NoError(t, ks.Rotate(ctx))
+	_, oldKID := sign(t, ks)
+
+	// Every replica was down past the key's rotation and most of its life.
+	repo.age(cfg.RotateEvery + cfg.PublishLead + 30*time.Minute)
+	require.NoError(t, ks.Rotate(ctx))
+	_, kid := sign(t, ks)
+	assert.NotEqual(t, oldKID, kid, "a key too close to expiry must not sign")
+}
+
+func TestKeySetWrapsPrivateKeys(t *testing.T) {
+	ctx := context.Background()
+	enc := config.Encryption{MasterKeys: map[string][]byte{"k1": make([]byte, 32)}, ActiveKeyID: "k1"}
+
+	// A key stored before encryption was configured.
+	repo := memoryKeys{}
+	require.NoError(t, signing.NewKeySet(repo, cfg, config.Encryption{}).Rotate(ctx))
+	require.Len(t, repo, 1)
+	var legacy *models.SigningKey
+	for _, key := range repo {
+		legacy = key
+	}
+	_, err := x509.ParsePKCS8PrivateKey(legacy.PrivateKey)
+	require.NoError(t, err, "stored in the clear")
+
+	ks := signing.NewKeySet(repo, cfg, enc)
+	require.NoError(t, ks.Rotate(ctx))
+	assert.Equal(t, "k1", legacy.KeyID, "re-wrapped on rotation")
+	_, err = x509.ParsePKCS8PrivateKey(legacy.PrivateKey)
+	assert.Error(t, err)
+	tok, _ := sign(t, ks)
+	assert.NoError(t, verify(ks, tok))
+
+	// New keys are wrapped from the start.
+	repo.age(55 * time.Minute)
+	require.NoError(t, ks.Rotate(ctx))
+	require.Len(t, repo, 2)
+	for _, key := range repo {
+		assert.Equal(t, "k1", key.KeyID)
+	}
+
+	// Without the master key the private keys are useless.
+	other := config.Encryption{MasterKeys: map[string][]byte{"k2": make([]byte, 32)}, ActiveKeyID: "k2"}
+	err = signing.NewKeySet(repo, cfg, other).Load(ctx)
+	assert.ErrorContains(t, err, `wrapped with unknown master key "k1"`)
+	err = signing.NewKeySet(repo, cfg, config.Encryption{}).Load(ctx)
+	assert.Error(t, err)
+
+	// A wrapped key copied under another kid does not open.
+	moved := *legacy
+	moved.KID = "moved"
+	repo[moved.KID] = &moved
+	err = ks.Load(ctx)
+	assert.ErrorContains(t, err, "signing key moved")
+}
+
+func TestJWKS(t *testing.T) {
+	for _, alg := range []string{"EdDSA", "RS256"} {
+		t.Run(alg, func(t *testing.T) {
+			c := cfg
+			c.Alg = alg
+			ks := signing.NewKeySet(memoryKeys{}, c, config.Encryption{})
+			require.NoError(t, ks.Rotate(context.Background()))
+			tok, kid := sign(t, ks)
+
+			set := ks.JWKS()
+			require.Len(t, set.Keys, 1)
+			jwk := set.Keys[0]
+			assert.Equal(t, kid, jwk.Kid)
+			assert.Equal(t, alg, jwk.Alg)
+			assert.Equal(t, "sig", jwk.Use)
+
+			// A verifier that only has the JWKS can check the token.
+			b64 := base64.RawURLEncoding.DecodeString
+			_, err := jwt.Parse(tok, func(t *jwt.Token) (interface{}, error) {
+				switch jwk.Kty {
+				case "OKP":
+					x, err := b64(jwk.X)
+					return ed25519.PublicKey(x), err
+				case "RSA":
+					n, _ := b64(jwk.N)
+					e, _ := b64(jwk.E)
+					return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+				}
+				return nil, nil
+			}, jwt.WithValidMethods([]string{alg}))
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package user
+
+import "time"
+
+// SetClock makes s read the time of login failures from now.
+func SetClock(s UserService, now func() time.Time) {
+	s.(*userService).now = now
+}
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/middleware"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+)
+
+// ErrProviderEmailNotVerified means a provider vouched for an identity we
+// have not seen before but not for its email address, so there is nothing
+// safe to link it to.
+var ErrProviderEmailNotVerified = errors.New("the identity provider has not verified this email address")
+
+// ErrAccountEmailNotVerified means the account with the provider's email
+// never proved it owns the address. Whoever signed it up may not be the
+// address's owner, so the identity is not linked to it.
+var ErrAccountEmailNotVerified = errors.New("an account with this email address exists but has not verified it; log in with its password and verify the address first")
+
+// ExternalLogin signs in with an identity a provider vouched for. A linked
+// identity logs its user in. A new one is linked to the user with the same
+// email, or to a new account, but only if the provider verified the address;
+// otherwise anyone could take over an account by claiming its email there.
+// The account must have verified the address too: otherwise someone could
+// sign up with a victim's email ahead of time and keep a password to the
+// account the victim then signs in to.
+func (s *userService) ExternalLogin(ctx context.Context, login *models.ExternalLogin) (*middleware.Tokens, *models.MFAChallenge, error) {
+	identity, err := s.repo.GetExternalIdentity(ctx, login.Issuer, login.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	var existingUser *models.User
+	if identity != nil {
+		existingUser, err = s.repo.GetUserByID(ctx, strconv.FormatUint(uint64(identity.UserID), 10))
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		existingUser, err = s.linkIdentity(ctx, login)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return s.signIn(ctx, existingUser, login.Client)
+}
+
+func (s *userService) linkIdentity(ctx context.Context, login *models.ExternalLogin) (*models.User, error) {
+	if !login.EmailVerified || login.Email == "" {
+		return nil, ErrProviderEmailNotVerified
+	}
+	now := time.Now().UTC()
+	existingUser, err := s.repo.GetUser(ctx, login.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser == nil {
+		// The account has no password of its own: a random one nobody knows
+		// until they reset it.
+		secret, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := domain.HashPassword(secret)
+		if err != nil {
+			return nil, err
+		}
+		existingUser = &models.User{
+			Name:     displayName(login),
+			Email:    login.Email,
+			Password: hash,
+		}
+		if err := s.repo.CreateUser(ctx, existingUser); err != nil {
+			return nil, err
+		}
+		if err := s.repo.MarkEmailVerified(ctx, strconv.FormatUint(uint64(existingUser.ID), 10), now); err != nil {
+			return nil, err
+		}
+		existingUser.EmailVerifiedAt = &now
+	} else if existingUser.EmailVerifiedAt == nil {
+		return nil, ErrAccountEmailNotVerified
+	}
+	err = s.repo.CreateExternalIdentity(ctx, &models.ExternalIdentity{
+		UserID:  existingUser.ID,
+		Issuer:  login.Issuer,
+		Subject: login.Subject,
+		Email:   login.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.audit.Record(ctx, &models.AuditLog{
+		UserID: &existingUser.ID,
+		Action: models.AuditIdentityLinked,
+		Target: login.Issuer + " " + login.Subject,
+		IP:     login.Client.IP,
+		Detail: fmt.Sprintf("linked by the verified email %s", login.Email),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", models.AuditIdentityLinked, err)
+	}
+	return existingUser, nil
+}
+
+func displayName(login *models.ExternalLogin) string {
+	name := strings.TrimSpace(login.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(login.Email, "@")
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+)
+
+// After delayAfter failures, each further login attempt on an account has
+// to wait a second, then two, four and so on up to maxDelay, until the
+// lockout proper kicks in.
+const (
+	delayAfter = 2
+	maxDelay   = time.Minute
+)
+
+// ErrInvalidCredentials is the one answer to a wrong email or a wrong
+// password, so logins cannot be used to find out who has an account.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// dummyHash is compared against for unknown emails, so they take as long
+// as a wrong password.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := domain.HashPassword("pixelforge-timing")
+	return hash
+})
+
+// loginKeys returns the failure counter keys of a login attempt. Emails are
+// counted whether or not they have an account.
+func loginKeys(email, ip string) (account, addr string) {
+	account = "account:" + strings.ToLower(strings.TrimSpace(email))
+	if ip != "" {
+		addr = "ip:" + ip
+	}
+	return account, addr
+}
+
+// beginLogin counts the attempt against the account and address before
+// the password or code is checked, and refuses it while either is locked or
+// the account's delay has not passed. The attempts it returns are failures
+// until endLogin takes them back.
+func (s *userService) beginLogin(ctx context.Context, account, addr string) ([]*config.LoginAttempt, error) {
+	lk := s.cfg.Lockout
+	limits := []struct {
+		key   string
+		limit config.AttemptLimit
+	}{
+		{account, config.AttemptLimit{Limit: lk.AccountLimit, Window: lk.Window, DelayAfter: delayAfter, MaxDelay: maxDelay}},
+		{addr, config.AttemptLimit{Limit: lk.IPLimit, Window: lk.Window, DelayAfter: -1}},
+	}
+	var attempts []*config.LoginAttempt
+	for _, l := range limits {
+		if l.key == "" || l.limit.Limit <= 0 {
+			continue
+		}
+		a, wait, err := s.rds.BeginLoginAttempt(ctx, l.key, s.now(), l.limit)
+		if err == nil && a == nil {
+			err = tooManyLogins(wait)
+		}
+		if err != nil {
+			s.endLogin(ctx, attempts)
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, nil
+}
+
+// endLogin takes back attempts that did not fail.
+func (s *userService) endLogin(ctx context.Context, attempts []*config.LoginAttempt) {
+	for _, a := range attempts {
+		if err := s.rds.EndLoginAttempt(ctx, a); err != nil {
+			log.Printf("failed to take back the login attempt of %s: %v", a.Key, err)
+		}
+	}
+}
+
+func tooManyLogins(wait time.Duration) error {
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return fmt.Errorf("%w: too many failed logins, try again in %s", ErrTooManyRequests, wait.Round(time.Second))
+}
+
+// loginFailed keeps attempts that turned out to be failed logins, a wrong
+// password or a wrong second factor, dated from when they were found to
+// be, and locks the account or address once it reaches its limit. It only
+// fails if the count cannot be kept.
+func (s *userService) loginFailed(ctx context.Context, user *models.User, attempts []*config.LoginAttempt, ip string) error {
+	lk := s.cfg.Lockout
+	now := s.now()
+	for _, a := range attempts {
+		if err := s.rds.FailLoginAttempt(ctx, a, now); err != nil {
+			return err
+		}
+		if a.Count < a.Limit {
+			continue
+		}
+		if err := s.rds.LockLogin(ctx, a.Key, lk.Duration); err != nil {
+			return err
+		}
+		entry := &models.AuditLog{
+			Action: models.AuditLoginLockout,
+			Target: a.Key,
+			IP:     ip,
+			Detail: fmt.Sprintf("%d failed logins within %s, locked for %s", a.Count, lk.Window, lk.Duration),
+		}
+		if user != nil && !strings.HasPrefix(a.Key, "ip:") {
+			entry.UserID = &user.ID
+		}
+		if err := s.audit.Record(ctx, entry); err != nil {
+			log.Printf("failed to record the lockout of %s: %v", a.Key, err)
+		}
+		log.Printf("login locked for %s after %d failures", a.Key, a.Count)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/middleware"
+	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/totp"
+)
+
+const (
+	// challengeTTL is how long a login waits for its second factor.
+	challengeTTL = 5 * time.Minute
+	// challengeAttempts caps the codes tried against one challenge.
+	challengeAttempts = 5
+	// disableAttempts caps the codes tried to turn two-factor
+	// authentication off within disableWindow.
+	disableAttempts = 5
+	disableWindow   = time.Hour
+	recoveryCodes   = 10
+)
+
+var (
+	// ErrInvalidMFAChallenge means the challenge is unknown, expired, used
+	// or out of attempts; the user has to log in again.
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge, please log in again")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+	ErrTOTPEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication is not set up")
+)
+
+// challenge starts the second step of a login.
+func (s *userService) challenge(ctx context.Context, userID string) (*models.MFAChallenge, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rds.SaveOneTimeToken(ctx, "mfa", hashToken(token), userID, challengeTTL); err != nil {
+		return nil, err
+	}
+	return &models.MFAChallenge{Challenge: token, ExpiresAt: time.Now().UTC().Add(challengeTTL)}, nil
+}
+
+// CompleteMFA finishes a login with a TOTP code or an unused recovery code.
+// A wrong code leaves the challenge open for another try, and counts as a
+// failed login of the account and client address, so codes cannot be
+// guessed faster than passwords.
+func (s *userService) CompleteMFA(ctx context.Context, req *models.MFALogin) (*middleware.Tokens, error) {
+	hash := hashToken(req.Challenge)
+	userID, err := s.rds.PeekOneTimeToken(ctx, "mfa", hash)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+	ok, _, err := s.rds.Allow(ctx, "mfa:"+hash, challengeAttempts, challengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFAChallenge
+	}
+	existingUser, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+	account, addr := loginKeys(existingUser.Email, req.Client.IP)
+	attempts, err := s.beginLogin(ctx, account, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCode(ctx, existingUser, req.Code); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			s.endLogin(ctx, attempts)
+			return nil, err
+		}
+		if err := s.loginFailed(ctx, existingUser, attempts, req.Client.IP); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	s.endLogin(ctx, attempts)
+	taken, err := s.rds.TakeOneTimeToken(ctx, "mfa", hash)
+	if err != nil {
+		return nil, err
+	}
+	if taken == "" {
+		// a concurrent request completed the challenge first
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err := s.rds.ClearLoginFailures(ctx, account); err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, existingUser, req.Client)
+}
+
+// checkCode accepts a current TOTP code, each time step once, or an unused
+// recovery code, which is then spent.
+func (s *userService) checkCode(ctx context.Context, user *models.User, code string) error {
+	userID := strconv.FormatUint(uint64(user.ID), 10)
+	if user.TOTPEnabledAt == nil {
+		return ErrTOTPNotEnrolled
+	}
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := s.rds.ClaimOnce(ctx, fmt.Sprintf("totp:%s:%d", userID, step), 3*totp.Period)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != 10 {
+		return ErrInvalidMFACode
+	}
+	codes, err := s.repo.ListRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, rc := range codes {
+		if !domain.CheckPasswordHash(normalized, rc.Hash) {
+			continue
+		}
+		used, err := s.repo.UseRecoveryCode(ctx, rc.ID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		log.Printf("user %s logged in with a recovery code, %d left", userID, len(codes)-1)
+		return nil
+	}
+	return ErrInvalidMFACode
+}
+
+func (s *userService) EnrollTOTP(ctx context.Context, userID string) (*models.TOTPEnrollment, error) {
+	existingUser, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser.TOTPEnabledAt != nil {
+		return nil, ErrTOTPEnabled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &models.TOTPEnrollment{Secret: secret, URI: totp.URI("PixelForge", existingUser.Email, secret)}, nil
+}
+
+func (s *userService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	existingUser, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser.TOTPEnabledAt != nil {
+		return nil, ErrTOTPEnabled
+	}
+	if existingUser.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if _, ok := totp.Validate(existingUser.TOTPSecret, code, time.Now()); !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	plain := make([]string, recoveryCodes)
+	rows := make([]*models.RecoveryCode, recoveryCodes)
+	for n := range plain {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := domain.HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+		plain[n] = code[:5] + "-" + code[5:]
+		rows[n] = &models.RecoveryCode{UserID: existingUser.ID, Hash: hash}
+	}
+	if err := s.repo.EnableTOTP(ctx, userID, time.Now().UTC(), rows); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It takes a code, so a
+// stolen session alone cannot remove the second factor, and only a few
+// codes an hour are tried, so the session cannot guess one either.
+func (s *userService) DisableTOTP(ctx context.Context, userID, code string) error {
+	ok, _, err := s.rds.Allow(ctx, "totp_disable:"+userID, disableAttempts, disableWindow)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTooManyRequests
+	}
+	existingUser, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCode(ctx, existingUser, code); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(ctx, userID)
+}
+
+// Recovery codes are ten characters of the base32 alphabet, 50 random bits,
+// shown as two groups of five.
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for n := range b {
+		b[n] = recoveryAlphabet[b[n]&31]
+	}
+	return string(b), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
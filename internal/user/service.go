@@ -3,39 +3,106 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/HarshithRajesh/PixelForge/internal/config"
 	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/mailer"
 	"github.com/HarshithRajesh/PixelForge/internal/middleware"
 	"github.com/HarshithRajesh/PixelForge/internal/models"
 	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidRefreshToken means the refresh token is malformed, expired
+	// or revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means a refresh token was presented again after
+	// it had been rotated. Either the client or an attacker holds a stolen
+	// copy, so every token of the login has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected, please log in again")
+	// ErrSessionNotFound means the user has no active session with that ID.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidResetToken means the password reset token is unknown,
+	// expired, already used or superseded by a newer one.
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	// ErrInvalidVerifyToken means the verification token is unknown,
+	// expired, already used or superseded by a resend.
+	ErrInvalidVerifyToken = errors.New("invalid or expired verification token")
+	// ErrEmailNotVerified means the policy requires a verified address for
+	// what the user tried.
+	ErrEmailNotVerified = errors.New("verify your email address first")
+	// ErrTooManyRequests means the caller hit a rate limit.
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrAccountSuspended means an admin suspended the account.
+	ErrAccountSuspended = errors.New("this account is suspended")
 )
 
 type UserService interface {
-	SignUp(user *models.User) error
-	Login(ctx context.Context, user *models.Login) (*middleware.Tokens, error)
+	SignUp(ctx context.Context, user *models.User) error
+	// Login returns tokens, or for accounts with two-factor authentication a
+	// challenge to pass to CompleteMFA with a code.
+	Login(ctx context.Context, user *models.Login) (*middleware.Tokens, *models.MFAChallenge, error)
+	CompleteMFA(ctx context.Context, req *models.MFALogin) (*middleware.Tokens, error)
+	// ExternalLogin is Login for an identity an OpenID Connect provider
+	// vouched for.
+	ExternalLogin(ctx context.Context, login *models.ExternalLogin) (*middleware.Tokens, *models.MFAChallenge, error)
+	EnrollTOTP(ctx context.Context, userID string) (*models.TOTPEnrollment, error)
+	// ConfirmTOTP enables TOTP once code shows the app is set up, and returns
+	// the recovery codes, which are not shown again.
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID, code string) error
+	Refresh(ctx context.Context, refreshToken string, client models.Client) (*middleware.Tokens, error)
+	ListSessions(ctx context.Context, userID, currentID string) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	RevokeAllSessions(ctx context.Context, userID string) (int, error)
+	// ForgotPassword rate-limits the request by address and by the client's
+	// ip, then sends the reset email in the background.
+	ForgotPassword(ctx context.Context, email, ip string) error
+	ResetPassword(ctx context.Context, req *models.ResetPassword) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	// UploadAllowed returns ErrEmailNotVerified if the verification policy
+	// keeps the user from storing images, by upload, transform or render.
+	UploadAllowed(ctx context.Context, userID string) error
 }
 
 type userService struct {
-	repo repository.UserRepository
-	rds  *config.Redis
+	repo  repository.UserRepository
+	rds   *config.Redis
+	mail  mailer.Mailer
+	cfg   config.Auth
+	audit repository.AuditRepository
+	now   func() time.Time
 }
 
-func NewUserService(repo repository.UserRepository, rds *config.Redis) UserService {
+func NewUserService(repo repository.UserRepository, rds *config.Redis, mail mailer.Mailer, cfg config.Auth, audit repository.AuditRepository) UserService {
 	return &userService{
-		repo: repo,
-		rds:  rds,
+		repo:  repo,
+		rds:   rds,
+		mail:  mail,
+		cfg:   cfg,
+		audit: audit,
+		now:   time.Now,
 	}
 }
 
-func (s *userService) SignUp(user *models.User) error {
+func (s *userService) SignUp(ctx context.Context, user *models.User) error {
 	if user.ConfirmPassword != user.Password {
 		return errors.New("password are not matching")
 	}
-	existingUser, err := s.repo.GetUser(user.Email)
+	existingUser, err := s.repo.GetUser(ctx, user.Email)
 	if err != nil {
 		log.Printf("database error: %v", err)
 		return err
@@ -48,32 +115,348 @@ func (s *userService) SignUp(user *models.User) error {
 	if err != nil {
 		return err
 	}
-	err = s.repo.CreateUser(user)
+	err = s.repo.CreateUser(ctx, user)
 	if err != nil {
 		return err
 	}
+	// The account exists either way; a failed email can be resent.
+	if err := s.sendVerification(ctx, user); err != nil {
+		log.Printf("failed to send the verification email to user %d: %v", user.ID, err)
+	}
 	return nil
 }
 
-func (s *userService) Login(ctx context.Context, user *models.Login) (*middleware.Tokens, error) {
+func (s *userService) Login(ctx context.Context, user *models.Login) (*middleware.Tokens, *models.MFAChallenge, error) {
+	account, addr := loginKeys(user.Email, user.Client.IP)
+	attempts, err := s.beginLogin(ctx, account, addr)
+	if err != nil {
+		return nil, nil, err
+	}
 	var existingUser *models.User
-	existingUser, err := s.repo.GetUser(user.Email)
+	existingUser, err = s.repo.GetUser(ctx, user.Email)
 	if err != nil {
-		return nil, err
+		s.endLogin(ctx, attempts)
+		return nil, nil, err
 	}
 	if existingUser == nil {
-		return nil, errors.New("user doesnt exist")
+		domain.CheckPasswordHash(user.Password, dummyHash())
+		if err := s.loginFailed(ctx, nil, attempts, user.Client.IP); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
 	}
 	if !domain.CheckPasswordHash(user.Password, existingUser.Password) {
-		return nil, errors.New("invalid password")
+		if err := s.loginFailed(ctx, existingUser, attempts, user.Client.IP); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
 	}
-	userID := strconv.FormatUint(uint64(existingUser.ID), 10)
-	token, err := middleware.IssueTokens(userID)
+	s.endLogin(ctx, attempts)
+	// With a second factor the login has not succeeded yet; CompleteMFA
+	// clears the failures, so a known password does not reset the count of
+	// guessed codes.
+	if existingUser.TOTPEnabledAt == nil {
+		if err := s.rds.ClearLoginFailures(ctx, account); err != nil {
+			return nil, nil, err
+		}
+	}
+	if s.cfg.VerifyPolicy == config.VerifyLogin && existingUser.EmailVerifiedAt == nil {
+		return nil, nil, ErrEmailNotVerified
+	}
+	return s.signIn(ctx, existingUser, user.Client)
+}
+
+// signIn logs in a user who proved who they are, or asks for their second
+// factor first.
+func (s *userService) signIn(ctx context.Context, user *models.User, client models.Client) (*middleware.Tokens, *models.MFAChallenge, error) {
+	if user.SuspendedAt != nil {
+		return nil, nil, ErrAccountSuspended
+	}
+	if user.TOTPEnabledAt != nil {
+		challenge, err := s.challenge(ctx, strconv.FormatUint(uint64(user.ID), 10))
+		return nil, challenge, err
+	}
+	token, err := s.startSession(ctx, user, client)
+	return token, nil, err
+}
+
+// startSession issues the tokens of a new login.
+func (s *userService) startSession(ctx context.Context, user *models.User, client models.Client) (*middleware.Tokens, error) {
+	token, err := middleware.IssueTokens(strconv.FormatUint(uint64(user.ID), 10), user.Role)
 	if err != nil {
 		return nil, errors.New("failed to generate token")
 	}
 	if err := middleware.Persist(ctx, s.rds, token); err != nil {
 		return nil, err
 	}
+	if err := s.saveSession(ctx, token, client); err != nil {
+		return nil, err
+	}
 	return token, nil
 }
+
+// saveSession records the login the tokens belong to, or refreshes its
+// record after a rotation.
+func (s *userService) saveSession(ctx context.Context, t *middleware.Tokens, client models.Client) error {
+	now := time.Now().UTC()
+	return s.rds.SaveSession(ctx, &models.Session{
+		ID:        t.Family,
+		UserID:    t.UserID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		CreatedAt: now,
+		LastSeen:  now,
+	}, t.ExpRef)
+}
+
+// Refresh trades a refresh token for a new token pair in the same family.
+// The old refresh token is moved to "rotated:<jti>" rather than deleted, so
+// a second use of it can be told apart from an unknown token.
+func (s *userService) Refresh(ctx context.Context, refreshToken string, client models.Client) (*middleware.Tokens, error) {
+	claims, err := middleware.ParseRefresh(refreshToken)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	rotated, err := s.rds.RotateJTI(ctx, "refresh:"+claims.ID, "rotated:"+claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		reused, err := s.rds.ExistsJTI(ctx, "rotated:"+claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !reused {
+			return nil, ErrInvalidRefreshToken
+		}
+		if err := s.rds.RevokeFamily(ctx, claims.Family); err != nil {
+			return nil, err
+		}
+		if _, err := s.rds.RevokeSession(ctx, claims.Subject, claims.Family); err != nil {
+			return nil, err
+		}
+		log.Printf("refresh token reuse for user %s, revoked family %s", claims.Subject, claims.Family)
+		return nil, ErrRefreshTokenReused
+	}
+
+	family := claims.Family
+	if family == "" {
+		family = uuid.NewString()
+	}
+	tokens, err := middleware.ReissueTokens(claims.Subject, claims.Role, family)
+	if err != nil {
+		return nil, errors.New("failed to generate token")
+	}
+	if err := middleware.Persist(ctx, s.rds, tokens); err != nil {
+		return nil, err
+	}
+	if err := s.saveSession(ctx, tokens, client); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// ListSessions returns the user's active sessions, marking currentID.
+func (s *userService) ListSessions(ctx context.Context, userID, currentID string) ([]*models.Session, error) {
+	sessions, err := s.rds.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (s *userService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	ok, err := s.rds.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *userService) RevokeAllSessions(ctx context.Context, userID string) (int, error) {
+	return s.rds.RevokeAllSessions(ctx, userID)
+}
+
+// resetMailTimeout bounds the background work of one ForgotPassword.
+const resetMailTimeout = time.Minute
+
+// ForgotPassword emails a password reset link to the account's address. The
+// account is looked up and the email sent after it returns, so the response
+// takes as long and says the same whether or not the address has an
+// account; failures are only logged. The rate limits count every request,
+// so they cannot tell addresses apart either.
+func (s *userService) ForgotPassword(ctx context.Context, email, ip string) error {
+	limits := []struct {
+		key   string
+		limit int
+	}{
+		{"reset:" + strings.ToLower(email), s.cfg.ResetLimit},
+		{"reset_ip:" + ip, s.cfg.ResetIPLimit},
+	}
+	for _, l := range limits {
+		ok, retry, err := s.rds.Allow(ctx, l.key, l.limit, time.Hour)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w, try again in %s", ErrTooManyRequests, retry.Round(time.Second))
+		}
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+		defer cancel()
+		if err := s.sendReset(ctx, email); err != nil {
+			log.Printf("password reset email: %v", err)
+		}
+	}()
+	return nil
+}
+
+// sendReset does the work of ForgotPassword, sending nothing for unknown
+// addresses.
+func (s *userService) sendReset(ctx context.Context, email string) error {
+	existingUser, err := s.repo.GetUser(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existingUser == nil {
+		return nil
+	}
+	userID := strconv.FormatUint(uint64(existingUser.ID), 10)
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := s.rds.SaveOneTimeToken(ctx, "reset", hashToken(token), userID, s.cfg.ResetTTL); err != nil {
+		return err
+	}
+	return s.mail.Send(ctx, mailer.Message{
+		To:      existingUser.Email,
+		Subject: "Reset your PixelForge password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your PixelForge account.\n\n"+
+			"To choose a new password, open\n\n%s/reset-password?token=%s\n\n"+
+			"The link works once and expires in %s. If you did not ask for it, ignore this email.\n",
+			s.cfg.AppURL, token, s.cfg.ResetTTL),
+	})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// logs the user out everywhere.
+func (s *userService) ResetPassword(ctx context.Context, req *models.ResetPassword) error {
+	if req.Password == "" {
+		return errors.New("password is required")
+	}
+	if req.ConfirmPassword != req.Password {
+		return errors.New("password are not matching")
+	}
+	hash, err := domain.HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	userID, err := s.rds.TakeOneTimeToken(ctx, "reset", hashToken(req.Token))
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return ErrInvalidResetToken
+	}
+	if err := s.repo.UpdatePassword(ctx, userID, hash); err != nil {
+		return err
+	}
+	if _, err := s.rds.RevokeAllSessions(ctx, userID); err != nil {
+		return err
+	}
+	log.Printf("password reset for user %s, all sessions revoked", userID)
+	return nil
+}
+
+func (s *userService) sendVerification(ctx context.Context, user *models.User) error {
+	userID := strconv.FormatUint(uint64(user.ID), 10)
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := s.rds.SaveOneTimeToken(ctx, "verify", hashToken(token), userID, s.cfg.VerifyTTL); err != nil {
+		return err
+	}
+	return s.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your PixelForge email address",
+		Body: fmt.Sprintf("Welcome to PixelForge! To confirm this is your address, open\n\n"+
+			"%s/verify-email?token=%s\n\n"+
+			"The link expires in %s. If you did not sign up, ignore this email.\n",
+			s.cfg.AppURL, token, s.cfg.VerifyTTL),
+	})
+}
+
+func (s *userService) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.rds.TakeOneTimeToken(ctx, "verify", hashToken(token))
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return ErrInvalidVerifyToken
+	}
+	return s.repo.MarkEmailVerified(ctx, userID, time.Now().UTC())
+}
+
+// ResendVerification sends a fresh verification link, invalidating the last.
+// Like ForgotPassword it is silent about unknown or verified addresses; the
+// rate limit applies to those too, so it cannot be used to tell them apart.
+func (s *userService) ResendVerification(ctx context.Context, email string) error {
+	ok, retry, err := s.rds.Allow(ctx, "verify_resend:"+strings.ToLower(email), s.cfg.ResendLimit, time.Hour)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w, try again in %s", ErrTooManyRequests, retry.Round(time.Second))
+	}
+	existingUser, err := s.repo.GetUser(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existingUser == nil || existingUser.EmailVerifiedAt != nil {
+		return nil
+	}
+	return s.sendVerification(ctx, existingUser)
+}
+
+func (s *userService) UploadAllowed(ctx context.Context, userID string) error {
+	if s.cfg.VerifyPolicy == config.VerifyNone {
+		return nil
+	}
+	existingUser, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if existingUser.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// newToken returns a random token for an emailed link.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored of an emailed token, so a Redis dump does not
+// hand out working links.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
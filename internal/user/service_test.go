@@ -1,20 +1,56 @@
 package user_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/HarshithRajesh/PixelForge/internal/config"
 	"github.com/HarshithRajesh/PixelForge/internal/domain"
+	"github.com/HarshithRajesh/PixelForge/internal/mailer"
+	"github.com/HarshithRajesh/PixelForge/internal/middleware"
 	"github.com/HarshithRajesh/PixelForge/internal/models"
+	"github.com/HarshithRajesh/PixelForge/internal/repository"
+	"github.com/HarshithRajesh/PixelForge/internal/totp"
 	service "github.com/HarshithRajesh/PixelForge/internal/user"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// MockUserRepository implements the calls the user service makes; any other
+// call panics through the nil embedded interface.
 type MockUserRepository struct {
+	repository.UserRepository
 	mock.Mock
 }
 
-func (m *MockUserRepository) GetUser(email string) (*models.User, error) {
+// memoryAudit is an AuditRepository over a slice.
+type memoryAudit struct {
+	entries []*models.AuditLog
+}
+
+func (m *memoryAudit) Record(ctx context.Context, entry *models.AuditLog) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+var testAuth = config.Auth{AppURL: "https://pixelforge.test", ResetTTL: time.Hour, VerifyTTL: time.Hour, ResetLimit: 5, ResetIPLimit: 20}
+
+func testRedis(t *testing.T) *config.Redis {
+	t.Setenv("ACCESS_SECRET", "test-access-secret")
+	t.Setenv("REFRESH_SECRET", "test-refresh-secret")
+	mr := miniredis.RunT(t)
+	return &config.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+}
+
+func (m *MockUserRepository) GetUser(ctx context.Context, email string) (*models.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -22,11 +58,62 @@ func (m *MockUserRepository) GetUser(email string) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserRepository) CreateUser(user *models.User) error {
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID string, hash string) error {
+	return m.Called(userID, hash).Error(0)
+}
+
+func (m *MockUserRepository) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	args := m.Called(userID)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, userID string, at time.Time) error {
+	return m.Called(userID).Error(0)
+}
+
+func (m *MockUserRepository) SetTOTPSecret(ctx context.Context, userID string, secret string) error {
+	return m.Called(userID, secret).Error(0)
+}
+
+func (m *MockUserRepository) EnableTOTP(ctx context.Context, userID string, at time.Time, codes []*models.RecoveryCode) error {
+	return m.Called(userID, codes).Error(0)
+}
+
+func (m *MockUserRepository) DisableTOTP(ctx context.Context, userID string) error {
+	return m.Called(userID).Error(0)
+}
+
+func (m *MockUserRepository) ListRecoveryCodes(ctx context.Context, userID string) ([]*models.RecoveryCode, error) {
+	args := m.Called(userID)
+	if list, ok := args.Get(0).(func() []*models.RecoveryCode); ok {
+		return list(), args.Error(1)
+	}
+	return args.Get(0).([]*models.RecoveryCode), args.Error(1)
+}
+
+func (m *MockUserRepository) UseRecoveryCode(ctx context.Context, codeID uint, at time.Time) (bool, error) {
+	args := m.Called(codeID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) CreateUser(ctx context.Context, user *models.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) GetExternalIdentity(ctx context.Context, issuer, subject string) (*models.ExternalIdentity, error) {
+	args := m.Called(issuer, subject)
+	if find, ok := args.Get(0).(func() *models.ExternalIdentity); ok {
+		return find(), args.Error(1)
+	}
+	identity, _ := args.Get(0).(*models.ExternalIdentity)
+	return identity, args.Error(1)
+}
+
+func (m *MockUserRepository) CreateExternalIdentity(ctx context.Context, identity *models.ExternalIdentity) error {
+	return m.Called(identity).Error(0)
+}
+
 func TestSignUp(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -81,9 +168,9 @@ func TestSignUp(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := new(MockUserRepository)
 			tt.mockSetup(mockRepo) // set up mock for this specific case
-			svc := service.NewUserService(mockRepo)
+			svc := service.NewUserService(mockRepo, testRedis(t), mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
 
-			err := svc.SignUp(tt.input)
+			err := svc.SignUp(context.Background(), tt.input)
 
 			if tt.expectedError == "" {
 				assert.NoError(t, err)
@@ -141,7 +228,40 @@ func TestLogin(t *testing.T) {
 				m.On("GetUser", "alice@example.com").Return(existingUser, nil)
 			},
 			expectedToken: false,
-			expectedError: "invalid password",
+			expectedError: "invalid email or password",
+		},
+		{
+			name: "suspended",
+			input: &models.Login{
+				Email:    "alice@example.com",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *MockUserRepository) {
+				hashedPassword, _ := domain.HashPassword("correctpassword")
+				suspended := time.Now()
+
+				existingUser := &models.User{
+					ID:          1,
+					Email:       "alice@example.com",
+					Password:    hashedPassword,
+					SuspendedAt: &suspended,
+				}
+				m.On("GetUser", "alice@example.com").Return(existingUser, nil)
+			},
+			expectedToken: false,
+			expectedError: "this account is suspended",
+		},
+		{
+			name: "unknown email",
+			input: &models.Login{
+				Email:    "nobody@example.com",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *MockUserRepository) {
+				m.On("GetUser", "nobody@example.com").Return(nil, nil)
+			},
+			expectedToken: false,
+			expectedError: "invalid email or password",
 		},
 	}
 
@@ -149,9 +269,9 @@ func TestLogin(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := new(MockUserRepository)
 			tt.mockSetup(mockRepo)
-			svc := service.NewUserService(mockRepo)
+			svc := service.NewUserService(mockRepo, testRedis(t), mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
 
-			token, err := svc.Login(tt.input)
+			token, _, err := svc.Login(context.Background(), tt.input)
 
 			if tt.expectedError == "" {
 				assert.NoError(t, err)
@@ -166,3 +286,570 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginRole(t *testing.T) {
+	hashed, _ := domain.HashPassword("pw")
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "root@example.com").Return(&models.User{ID: 1, Email: "root@example.com", Password: hashed, Role: models.RoleAdmin}, nil)
+	svc := service.NewUserService(repo, testRedis(t), mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
+
+	tokens, _, err := svc.Login(context.Background(), &models.Login{Email: "root@example.com", Password: "pw"})
+	require.NoError(t, err)
+	claims, err := middleware.ParseAccess(tokens.Access)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, claims.Role)
+
+	refreshed, err := svc.Refresh(context.Background(), tokens.Refresh, models.Client{})
+	require.NoError(t, err)
+	claims, err = middleware.ParseAccess(refreshed.Access)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, claims.Role, "the role survives a refresh")
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	svc := service.NewUserService(new(MockUserRepository), rds, mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
+
+	login, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	require.NoError(t, middleware.Persist(ctx, rds, login))
+
+	rotated, err := svc.Refresh(ctx, login.Refresh, models.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", rotated.UserID)
+	assert.Equal(t, login.Family, rotated.Family)
+	assert.NotEqual(t, login.JTIRef, rotated.JTIRef)
+
+	next, err := svc.Refresh(ctx, rotated.Refresh, models.Client{})
+	require.NoError(t, err, "the rotated token is usable once")
+
+	_, err = svc.Refresh(ctx, "not-a-token", models.Client{})
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+
+	// Presenting the first token again revokes the whole family, including
+	// the tokens the legitimate client holds now.
+	_, err = svc.Refresh(ctx, login.Refresh, models.Client{})
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+	_, err = rds.GetUserByJTI(ctx, "access:"+next.JTIAcc)
+	assert.Error(t, err, "access token revoked")
+	_, err = svc.Refresh(ctx, next.Refresh, models.Client{})
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+
+	other, err := middleware.IssueTokens("1", "")
+	require.NoError(t, err)
+	require.NoError(t, middleware.Persist(ctx, rds, other))
+	_, err = svc.Refresh(ctx, other.Refresh, models.Client{})
+	assert.NoError(t, err, "other logins are unaffected")
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("pw")
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(&models.User{ID: 1, Password: hashed}, nil)
+	svc := service.NewUserService(repo, rds, mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
+
+	login := func(agent string) *middleware.Tokens {
+		tokens, _, err := svc.Login(ctx, &models.Login{
+			Email:    "alice@example.com",
+			Password: "pw",
+			Client:   models.Client{UserAgent: agent, IP: "203.0.113.7"},
+		})
+		require.NoError(t, err)
+		return tokens
+	}
+	laptop, phone, tablet := login("laptop"), login("phone"), login("tablet")
+
+	sessions, err := svc.ListSessions(ctx, "1", laptop.Family)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+	agents := map[string]bool{}
+	for _, s := range sessions {
+		agents[s.UserAgent] = s.Current
+		assert.Equal(t, "203.0.113.7", s.IP)
+		assert.False(t, s.CreatedAt.IsZero())
+	}
+	assert.Equal(t, map[string]bool{"laptop": true, "phone": false, "tablet": false}, agents)
+
+	// Refreshing keeps the session and records the new client.
+	rotated, err := svc.Refresh(ctx, phone.Refresh, models.Client{UserAgent: "phone", IP: "198.51.100.2"})
+	require.NoError(t, err)
+	assert.Equal(t, phone.Family, rotated.Family)
+
+	assert.ErrorIs(t, svc.RevokeSession(ctx, "2", phone.Family), service.ErrSessionNotFound, "only the owner may revoke")
+	require.NoError(t, svc.RevokeSession(ctx, "1", phone.Family))
+	_, err = svc.Refresh(ctx, rotated.Refresh, models.Client{})
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+	active, err := rds.TouchSession(ctx, phone.Family, time.Now())
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	sessions, err = svc.ListSessions(ctx, "1", "")
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	n, err := svc.RevokeAllSessions(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	for _, tokens := range []*middleware.Tokens{laptop, tablet} {
+		_, err := rds.GetUserByJTI(ctx, "access:"+tokens.JTIAcc)
+		assert.Error(t, err)
+	}
+	sessions, err = svc.ListSessions(ctx, "1", "")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("old")
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(&models.User{ID: 1, Email: "alice@example.com", Password: hashed}, nil)
+	lookedUp := make(chan string, 1)
+	repo.On("GetUser", "nobody@example.com").Run(func(args mock.Arguments) {
+		lookedUp <- args.String(0)
+	}).Return(nil, nil)
+	var newHash string
+	repo.On("UpdatePassword", "1", mock.Anything).Run(func(args mock.Arguments) {
+		newHash = args.String(1)
+	}).Return(nil)
+	mail := mailer.NewMemoryMailer()
+	svc := service.NewUserService(repo, rds, mail, testAuth, &memoryAudit{})
+
+	session, _, err := svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "old"})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.ForgotPassword(ctx, "nobody@example.com", "192.0.2.1"))
+	require.Eventually(t, func() bool { return len(lookedUp) == 1 }, time.Second, time.Millisecond, "the account is looked up in the background")
+	assert.Empty(t, mail.Sent(), "unknown addresses get no mail")
+
+	token := func() string {
+		n := len(mail.Sent())
+		require.NoError(t, svc.ForgotPassword(ctx, "alice@example.com", "192.0.2.1"))
+		require.Eventually(t, func() bool { return len(mail.Sent()) > n }, time.Second, time.Millisecond, "the email is sent in the background")
+		sent := mail.Sent()
+		msg := sent[len(sent)-1]
+		assert.Equal(t, "alice@example.com", msg.To)
+		_, link, ok := strings.Cut(msg.Body, testAuth.AppURL+"/reset-password?token=")
+		require.True(t, ok, msg.Body)
+		return strings.Fields(link)[0]
+	}
+	first := token()
+	second := token()
+
+	reset := func(token, password string) error {
+		return svc.ResetPassword(ctx, &models.ResetPassword{Token: token, Password: password, ConfirmPassword: password})
+	}
+	assert.EqualError(t, svc.ResetPassword(ctx, &models.ResetPassword{Token: second, Password: "a", ConfirmPassword: "b"}), "password are not matching")
+	assert.ErrorIs(t, reset(first, "new"), service.ErrInvalidResetToken, "a newer token supersedes the first")
+	require.NoError(t, reset(second, "new"))
+	assert.True(t, domain.CheckPasswordHash("new", newHash))
+	assert.ErrorIs(t, reset(second, "newer"), service.ErrInvalidResetToken, "tokens are single-use")
+
+	_, err = rds.GetUserByJTI(ctx, "access:"+session.JTIAcc)
+	assert.Error(t, err, "existing sessions are revoked")
+	repo.AssertNumberOfCalls(t, "UpdatePassword", 1)
+}
+
+func TestForgotPasswordRateLimits(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockUserRepository)
+	repo.On("GetUser", mock.Anything).Return(nil, nil)
+	cfg := testAuth
+	cfg.ResetLimit, cfg.ResetIPLimit = 2, 2
+	svc := service.NewUserService(repo, testRedis(t), mailer.NewMemoryMailer(), cfg, &memoryAudit{})
+
+	require.NoError(t, svc.ForgotPassword(ctx, "alice@example.com", "192.0.2.1"))
+	require.NoError(t, svc.ForgotPassword(ctx, "Alice@example.com", "192.0.2.2"))
+	assert.ErrorIs(t, svc.ForgotPassword(ctx, "alice@example.com", "192.0.2.3"), service.ErrTooManyRequests, "per address, ignoring case")
+
+	require.NoError(t, svc.ForgotPassword(ctx, "bob@example.com", "192.0.2.1"))
+	assert.ErrorIs(t, svc.ForgotPassword(ctx, "carol@example.com", "192.0.2.1"), service.ErrTooManyRequests, "per client address")
+	require.NoError(t, svc.ForgotPassword(ctx, "carol@example.com", "192.0.2.4"))
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("pw")
+	alice := &models.User{ID: 1, Email: "alice@example.com", Password: hashed}
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(nil, nil).Once()
+	repo.On("CreateUser", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.User).ID = 1
+	}).Return(nil)
+	repo.On("GetUser", "alice@example.com").Return(alice, nil)
+	repo.On("GetUser", "Alice@example.com").Return(nil, nil)
+	repo.On("GetUserByID", "1").Return(alice, nil)
+	repo.On("MarkEmailVerified", "1").Return(nil)
+	mail := mailer.NewMemoryMailer()
+	cfg := testAuth
+	cfg.VerifyPolicy, cfg.ResendLimit = config.VerifyLogin, 2
+	svc := service.NewUserService(repo, rds, mail, cfg, &memoryAudit{})
+
+	link := func() string {
+		sent := mail.Sent()
+		require.NotEmpty(t, sent)
+		_, token, ok := strings.Cut(sent[len(sent)-1].Body, cfg.AppURL+"/verify-email?token=")
+		require.True(t, ok)
+		return strings.Fields(token)[0]
+	}
+
+	require.NoError(t, svc.SignUp(ctx, &models.User{Email: "alice@example.com", Password: "pw", ConfirmPassword: "pw"}))
+	first := link()
+
+	_, _, err := svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+	assert.ErrorIs(t, err, service.ErrEmailNotVerified)
+	assert.ErrorIs(t, svc.UploadAllowed(ctx, "1"), service.ErrEmailNotVerified)
+
+	require.NoError(t, svc.ResendVerification(ctx, "alice@example.com"))
+	second := link()
+	require.NoError(t, svc.ResendVerification(ctx, "Alice@example.com"))
+	assert.ErrorIs(t, svc.ResendVerification(ctx, "alice@example.com"), service.ErrTooManyRequests)
+	assert.ErrorIs(t, svc.ResendVerification(ctx, "ALICE@example.com"), service.ErrTooManyRequests, "the limit ignores case")
+	assert.Len(t, mail.Sent(), 2)
+
+	assert.ErrorIs(t, svc.VerifyEmail(ctx, first), service.ErrInvalidVerifyToken, "a resend supersedes the first link")
+	require.NoError(t, svc.VerifyEmail(ctx, second))
+	assert.ErrorIs(t, svc.VerifyEmail(ctx, second), service.ErrInvalidVerifyToken, "links are single-use")
+	repo.AssertCalled(t, "MarkEmailVerified", "1")
+
+	verified := time.Now()
+	alice.EmailVerifiedAt = &verified
+	_, _, err = svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.UploadAllowed(ctx, "1"))
+}
+
+func TestTOTP(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("pw")
+	alice := &models.User{ID: 1, Email: "alice@example.com", Password: hashed}
+	var recovery []*models.RecoveryCode
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(alice, nil)
+	repo.On("GetUserByID", "1").Return(alice, nil)
+	repo.On("SetTOTPSecret", "1", mock.Anything).Run(func(args mock.Arguments) {
+		alice.TOTPSecret = args.String(1)
+	}).Return(nil)
+	repo.On("EnableTOTP", "1", mock.Anything).Run(func(args mock.Arguments) {
+		now := time.Now()
+		alice.TOTPEnabledAt = &now
+		recovery = args.Get(1).([]*models.RecoveryCode)
+		for n, rc := range recovery {
+			rc.ID = uint(n + 1)
+		}
+	}).Return(nil)
+	repo.On("ListRecoveryCodes", "1").Return(func() []*models.RecoveryCode {
+		var unused []*models.RecoveryCode
+		for _, rc := range recovery {
+			if rc.UsedAt == nil {
+				unused = append(unused, rc)
+			}
+		}
+		return unused
+	}, nil)
+	repo.On("UseRecoveryCode", mock.Anything).Run(func(args mock.Arguments) {
+		now := time.Now()
+		recovery[args.Get(0).(uint)-1].UsedAt = &now
+	}).Return(true, nil)
+	svc := service.NewUserService(repo, rds, mailer.NewMemoryMailer(), testAuth, &memoryAudit{})
+
+	enrollment, err := svc.EnrollTOTP(ctx, "1")
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/PixelForge:alice@example.com?")
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	// Until confirmed, logins still go straight through.
+	tokens, challenge, err := svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+	assert.Nil(t, challenge)
+
+	now := func(offset int64) string {
+		code, err := totp.Code(enrollment.Secret, totp.Step(time.Now())+offset)
+		require.NoError(t, err)
+		return code
+	}
+	_, err = svc.ConfirmTOTP(ctx, "1", "000000")
+	assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+	codes, err := svc.ConfirmTOTP(ctx, "1", now(0))
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	for _, rc := range recovery {
+		assert.NotContains(t, codes, rc.Hash, "recovery codes are stored hashed")
+	}
+	_, err = svc.EnrollTOTP(ctx, "1")
+	assert.ErrorIs(t, err, service.ErrTOTPEnabled)
+
+	login := func() string {
+		tokens, challenge, err := svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+		require.NoError(t, err)
+		assert.Nil(t, tokens, "no tokens before the second factor")
+		require.NotNil(t, challenge)
+		return challenge.Challenge
+	}
+	complete := func(challenge, code string) error {
+		_, err := svc.CompleteMFA(ctx, &models.MFALogin{Challenge: challenge, Code: code})
+		return err
+	}
+
+	first, next := login(), now(1)
+	assert.ErrorIs(t, complete(first, "000000"), service.ErrInvalidMFACode)
+	require.NoError(t, complete(first, next), "a wrong code leaves the challenge open")
+	assert.ErrorIs(t, complete(first, next), service.ErrInvalidMFAChallenge, "challenges are single-use")
+	assert.ErrorIs(t, complete(login(), next), service.ErrInvalidMFACode, "a code is accepted once")
+
+	upper := strings.ToUpper(codes[0])
+	require.NoError(t, complete(login(), upper), "recovery codes work in place of a code")
+	assert.ErrorIs(t, complete(login(), codes[0]), service.ErrInvalidMFACode, "recovery codes are single-use")
+
+	guessed := login()
+	for n := 0; n < 5; n++ {
+		assert.ErrorIs(t, complete(guessed, "000000"), service.ErrInvalidMFACode)
+	}
+	assert.ErrorIs(t, complete(guessed, now(0)), service.ErrInvalidMFAChallenge, "attempts are limited")
+
+	for n := 0; n < 5; n++ {
+		assert.ErrorIs(t, svc.DisableTOTP(ctx, "1", "000000"), service.ErrInvalidMFACode)
+	}
+	assert.ErrorIs(t, svc.DisableTOTP(ctx, "1", now(0)), service.ErrTooManyRequests, "codes to disable it are limited too")
+	repo.AssertNotCalled(t, "DisableTOTP", "1")
+}
+
+func TestMFALockout(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("pw")
+	secret, err := totp.NewSecret()
+	require.NoError(t, err)
+	enabled := time.Now()
+	alice := &models.User{ID: 1, Email: "alice@example.com", Password: hashed, TOTPSecret: secret, TOTPEnabledAt: &enabled}
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(alice, nil)
+	repo.On("GetUserByID", "1").Return(alice, nil)
+	audit := &memoryAudit{}
+	cfg := testAuth
+	cfg.Lockout = config.Lockout{AccountLimit: 4, Window: time.Hour, Duration: time.Hour}
+	svc := service.NewUserService(repo, rds, mailer.NewMemoryMailer(), cfg, audit)
+	clock := time.Now()
+	service.SetClock(svc, func() time.Time { return clock })
+
+	login := func() string {
+		_, challenge, err := svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+		require.NoError(t, err)
+		require.NotNil(t, challenge)
+		return challenge.Challenge
+	}
+	complete := func(challenge, code string) error {
+		_, err := svc.CompleteMFA(ctx, &models.MFALogin{Challenge: challenge, Code: code})
+		return err
+	}
+	failures := func() int {
+		n, _, err := rds.LoginFailures(ctx, "account:alice@example.com")
+		require.NoError(t, err)
+		return n
+	}
+	code := func(offset int64) string {
+		code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+		require.NoError(t, err)
+		return code
+	}
+
+	first := login()
+	assert.ErrorIs(t, complete(first, "000000"), service.ErrInvalidMFACode)
+	assert.Equal(t, 1, failures(), "a wrong code counts as a failed login")
+	require.NoError(t, complete(first, code(0)))
+	assert.Equal(t, 0, failures(), "a completed login clears the count")
+
+	guessed := login()
+	assert.ErrorIs(t, complete(guessed, "000000"), service.ErrInvalidMFACode)
+	assert.ErrorIs(t, complete(guessed, "000001"), service.ErrInvalidMFACode)
+	guessed = login()
+	assert.Equal(t, 2, failures(), "the right password alone does not clear the count")
+	assert.ErrorIs(t, complete(guessed, "000002"), service.ErrInvalidMFACode)
+	assert.ErrorIs(t, complete(guessed, code(1)), service.ErrTooManyRequests, "the third failure delays the next attempt")
+
+	clock = clock.Add(time.Second)
+	assert.ErrorIs(t, complete(guessed, "000003"), service.ErrInvalidMFACode)
+	require.Len(t, audit.entries, 1)
+	assert.Equal(t, "account:alice@example.com", audit.entries[0].Target)
+	assert.ErrorIs(t, complete(guessed, code(1)), service.ErrTooManyRequests, "the fourth failure locks the account")
+	_, _, err = svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "pw"})
+	assert.ErrorIs(t, err, service.ErrTooManyRequests)
+}
+
+func TestExternalLogin(t *testing.T) {
+	ctx := context.Background()
+	const issuer = "https://idp.example.com"
+	verified := time.Now()
+	alice := &models.User{ID: 1, Email: "alice@example.com", EmailVerifiedAt: &verified}
+	carol := &models.User{ID: 3, Email: "carol@example.com"}
+	linked := map[string]*models.ExternalIdentity{}
+	var created *models.User
+	repo := new(MockUserRepository)
+	for _, subject := range []string{"alice-sub", "bob-sub", "carol-sub", "mallory-sub"} {
+		subject := subject
+		repo.On("GetExternalIdentity", issuer, subject).Return(func() *models.ExternalIdentity { return linked[subject] }, nil)
+	}
+	repo.On("CreateExternalIdentity", mock.Anything).Run(func(args mock.Arguments) {
+		identity := args.Get(0).(*models.ExternalIdentity)
+		linked[identity.Subject] = identity
+	}).Return(nil)
+	repo.On("GetUser", "alice@example.com").Return(alice, nil)
+	repo.On("GetUser", "bob@example.com").Return(nil, nil)
+	repo.On("GetUser", "carol@example.com").Return(carol, nil)
+	repo.On("CreateUser", mock.Anything).Run(func(args mock.Arguments) {
+		created = args.Get(0).(*models.User)
+		created.ID = 2
+	}).Return(nil)
+	repo.On("MarkEmailVerified", "2").Return(nil)
+	repo.On("GetUserByID", "1").Return(alice, nil)
+	audit := &memoryAudit{}
+	svc := service.NewUserService(repo, testRedis(t), mailer.NewMemoryMailer(), testAuth, audit)
+
+	t.Run("links an existing account by verified email", func(t *testing.T) {
+		tokens, challenge, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, Client: models.Client{IP: "198.51.100.1"}})
+		require.NoError(t, err)
+		assert.NotNil(t, tokens)
+		assert.Nil(t, challenge)
+		require.Contains(t, linked, "alice-sub")
+		assert.Equal(t, uint(1), linked["alice-sub"].UserID)
+		require.Len(t, audit.entries, 1)
+		assert.Equal(t, models.AuditIdentityLinked, audit.entries[0].Action)
+		assert.Equal(t, uint(1), *audit.entries[0].UserID)
+		assert.Equal(t, issuer+" alice-sub", audit.entries[0].Target)
+		assert.Equal(t, "198.51.100.1", audit.entries[0].IP)
+	})
+
+	t.Run("an account that has not verified its email is never linked", func(t *testing.T) {
+		_, _, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "carol-sub", Email: "carol@example.com", EmailVerified: true})
+		assert.ErrorIs(t, err, service.ErrAccountEmailNotVerified)
+		assert.NotContains(t, linked, "carol-sub")
+		assert.Nil(t, carol.EmailVerifiedAt)
+	})
+
+	t.Run("a linked identity signs in without an email", func(t *testing.T) {
+		tokens, _, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "alice-sub"})
+		require.NoError(t, err)
+		assert.NotNil(t, tokens)
+		repo.AssertCalled(t, "GetUserByID", "1")
+	})
+
+	t.Run("creates an account for a new email", func(t *testing.T) {
+		tokens, _, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "bob-sub", Email: "bob@example.com", EmailVerified: true})
+		require.NoError(t, err)
+		assert.NotNil(t, tokens)
+		require.NotNil(t, created)
+		assert.Equal(t, "bob", created.Name)
+		assert.NotEmpty(t, created.Password)
+		repo.AssertCalled(t, "MarkEmailVerified", "2")
+		assert.Equal(t, uint(2), linked["bob-sub"].UserID)
+		require.Len(t, audit.entries, 2)
+		assert.Equal(t, uint(2), *audit.entries[1].UserID)
+	})
+
+	t.Run("an unverified email is never linked", func(t *testing.T) {
+		_, _, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "mallory-sub", Email: "alice@example.com"})
+		assert.ErrorIs(t, err, service.ErrProviderEmailNotVerified)
+		assert.NotContains(t, linked, "mallory-sub")
+	})
+
+	t.Run("asks for the second factor", func(t *testing.T) {
+		now := time.Now()
+		alice.TOTPEnabledAt = &now
+		t.Cleanup(func() { alice.TOTPEnabledAt = nil })
+		tokens, challenge, err := svc.ExternalLogin(ctx, &models.ExternalLogin{Issuer: issuer, Subject: "alice-sub"})
+		require.NoError(t, err)
+		assert.Nil(t, tokens)
+		assert.NotNil(t, challenge)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	rds := testRedis(t)
+	hashed, _ := domain.HashPassword("pw")
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(&models.User{ID: 1, Email: "alice@example.com", Password: hashed}, nil)
+	repo.On("GetUser", mock.Anything).Return(nil, nil)
+	audit := &memoryAudit{}
+	cfg := testAuth
+	cfg.Lockout = config.Lockout{AccountLimit: 4, IPLimit: 6, Window: time.Hour, Duration: time.Hour}
+	svc := service.NewUserService(repo, rds, mailer.NewMemoryMailer(), cfg, audit)
+	clock := time.Now()
+	service.SetClock(svc, func() time.Time { return clock })
+
+	login := func(email, password, ip string) error {
+		_, _, err := svc.Login(ctx, &models.Login{Email: email, Password: password, Client: models.Client{IP: ip}})
+		return err
+	}
+
+	require.ErrorIs(t, login("alice@example.com", "wrong", "198.51.100.1"), service.ErrInvalidCredentials)
+	require.ErrorIs(t, login("alice@example.com", "wrong", "198.51.100.1"), service.ErrInvalidCredentials)
+	require.ErrorIs(t, login("alice@example.com", "wrong", "198.51.100.1"), service.ErrInvalidCredentials)
+	assert.ErrorIs(t, login("alice@example.com", "pw", "198.51.100.1"), service.ErrTooManyRequests, "the third failure delays the next attempt")
+	clock = clock.Add(time.Second)
+	require.ErrorIs(t, login("alice@example.com", "wrong", "198.51.100.2"), service.ErrInvalidCredentials)
+
+	err := login("alice@example.com", "pw", "198.51.100.3")
+	require.ErrorIs(t, err, service.ErrTooManyRequests, "the fourth failure locks the account, even for the right password")
+	assert.Contains(t, err.Error(), "try again in 1h0m0s")
+	require.Len(t, audit.entries, 1)
+	assert.Equal(t, models.AuditLoginLockout, audit.entries[0].Action)
+	assert.Equal(t, "account:alice@example.com", audit.entries[0].Target)
+	assert.Equal(t, uint(1), *audit.entries[0].UserID)
+
+	// Unknown emails are counted and locked the same way, and the address
+	// trying them runs into its own limit.
+	for n := 0; n < 2; n++ {
+		assert.ErrorIs(t, login(fmt.Sprintf("user%d@example.com", n), "guess", "198.51.100.1"), service.ErrInvalidCredentials)
+	}
+	assert.ErrorIs(t, login("bob@example.com", "guess", "198.51.100.1"), service.ErrInvalidCredentials)
+	require.Len(t, audit.entries, 2)
+	assert.Equal(t, "ip:198.51.100.1", audit.entries[1].Target)
+	assert.Nil(t, audit.entries[1].UserID)
+	assert.ErrorIs(t, login("carol@example.com", "guess", "198.51.100.1"), service.ErrTooManyRequests)
+	assert.ErrorIs(t, login("carol@example.com", "guess", "198.51.100.9"), service.ErrInvalidCredentials, "other addresses are unaffected")
+}
+
+func TestLoginLockoutParallel(t *testing.T) {
+	ctx := context.Background()
+	hashed, _ := domain.HashPassword("pw")
+	repo := new(MockUserRepository)
+	repo.On("GetUser", "alice@example.com").Return(&models.User{ID: 1, Email: "alice@example.com", Password: hashed}, nil)
+	cfg := testAuth
+	cfg.Lockout = config.Lockout{AccountLimit: 4, Window: time.Hour, Duration: time.Hour}
+	svc := service.NewUserService(repo, testRedis(t), mailer.NewMemoryMailer(), cfg, &memoryAudit{})
+	clock := time.Now()
+	service.SetClock(svc, func() time.Time { return clock })
+
+	errs := make([]error, 10)
+	var wg sync.WaitGroup
+	for n := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[n] = svc.Login(ctx, &models.Login{Email: "alice@example.com", Password: "wrong"})
+		}()
+	}
+	wg.Wait()
+
+	var invalid, refused int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, service.ErrInvalidCredentials):
+			invalid++
+		case errors.Is(err, service.ErrTooManyRequests):
+			refused++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 3, invalid, "parallel guesses cannot skip the delay")
+	assert.Equal(t, 7, refused)
+}
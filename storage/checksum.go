@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrChecksumMismatch means a stored file no longer matches the checksum
+// recorded when it was written: it was truncated or corrupted at rest.
+var ErrChecksumMismatch = errors.New("stored file is corrupt: checksum mismatch")
+
+const checksumPrefix = "sha256:"
+
+// Checksum returns the checksum Put would record for data.
+func Checksum(data []byte) string {
+	h := sha256.New()
+	h.Write(data)
+	return formatChecksum(h)
+}
+
+func formatChecksum(h hash.Hash) string {
+	return checksumPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// OpenVerified opens path like Open, but the reader hashes what it returns
+// and, instead of io.EOF, fails with ErrChecksumMismatch when the bytes do
+// not match checksum. An empty checksum, as on files written before
+// checksums were recorded, disables the check.
+func OpenVerified(ctx context.Context, s StorageRepository, path string, userID string, checksum string) (io.ReadCloser, error) {
+	r, err := s.Open(ctx, path, userID)
+	if err != nil || checksum == "" {
+		return r, err
+	}
+	return &verifyingReader{ReadCloser: r, hash: sha256.New(), want: checksum}, nil
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && formatChecksum(v.hash) != v.want {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
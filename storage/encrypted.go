@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// KeyProvider returns the AES-256 data key for a user's files.
+type KeyProvider interface {
+	DataKey(ctx context.Context, userID string) ([]byte, error)
+}
+
+// Encrypted files start with a header of encMagic and a random nonce prefix,
+// followed by chunks of at most encChunkSize plaintext bytes, each sealed
+// with AES-GCM. A chunk's nonce is the prefix, its index, and a flag set
+// only on the last chunk, so reordering, dropping or truncating chunks all
+// fail authentication.
+const (
+	encMagic     = "PFE1"
+	encPrefixLen = 7
+	encHeaderLen = len(encMagic) + encPrefixLen
+	encChunkSize = 64 << 10
+)
+
+// encryptedStorage encrypts everything written to inner with the owner's
+// data key and decrypts it again on Open. Files written before encryption
+// was enabled lack the header and are read back unchanged. Sizes reported
+// by Stat and List are the encrypted sizes at rest.
+type encryptedStorage struct {
+	StorageRepository
+	keys KeyProvider
+}
+
+func NewEncryptedStorage(inner StorageRepository, keys KeyProvider) StorageRepository {
+	return &encryptedStorage{StorageRepository: inner, keys: keys}
+}
+
+func (s *encryptedStorage) Backend() string {
+	return BackendOf(s.StorageRepository)
+}
+
+func (s *encryptedStorage) aead(ctx context.Context, userID string) (cipher.AEAD, error) {
+	key, err := s.keys.DataKey(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the data key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *encryptedStorage) Create(ctx context.Context, path string, userID string) (io.WriteCloser, error) {
+	aead, err := s.aead(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encHeaderLen)
+	copy(header, encMagic)
+	if _, err := rand.Read(header[len(encMagic):]); err != nil {
+		return nil, err
+	}
+
+	w, err := s.StorageRepository.Create(ctx, path, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (s *encryptedStorage) Open(ctx context.Context, path string, userID string) (io.ReadCloser, error) {
+	r, err := s.StorageRepository.Open(ctx, path, userID)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(r, encChunkSize+encHeaderLen+64)
+	header, err := br.Peek(encHeaderLen)
+	if err != nil || string(header[:len(encMagic)]) != encMagic {
+		// Short or unmarked: stored before encryption was turned on.
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, r}, nil
+	}
+	aead, err := s.aead(ctx, userID)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	header = append([]byte(nil), header...)
+	br.Discard(encHeaderLen)
+	return &decryptReader{src: br, closer: r, aead: aead, header: header}, nil
+}
+
+func chunkNonce(header []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(encMagic):])
+	binary.BigEndian.PutUint32(nonce[encPrefixLen:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w      io.WriteCloser
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint32
+	err    error
+}
+
+// Write holds back a full chunk until more data arrives, because only then
+// is it known not to be the last one.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == encChunkSize {
+			if e.err = e.seal(false); e.err != nil {
+				return n, e.err
+			}
+		}
+		k := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	out := e.aead.Seal(nil, chunkNonce(e.header, e.index, last), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	if e.err == nil {
+		e.err = e.seal(true)
+	}
+	closeErr := e.w.Close()
+	if e.err != nil {
+		return e.err
+	}
+	return closeErr
+}
+
+type decryptReader struct {
+	src    *bufio.Reader
+	closer io.Closer
+	aead   cipher.AEAD
+	header []byte
+	index  uint32
+	plain  []byte
+	done   bool
+	err    error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts one chunk. A full-size chunk is the last one only if nothing
+// follows it.
+func (d *decryptReader) next() error {
+	sealed := make([]byte, encChunkSize+d.aead.Overhead())
+	n, err := io.ReadFull(d.src, sealed)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.src.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	plain, err := d.aead.Open(nil, chunkNonce(d.header, d.index, last), sealed[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed to decrypt", ErrChecksumMismatch, d.index)
+	}
+	d.index++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.closer.Close()
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticKeys map[string][]byte
+
+func (k staticKeys) DataKey(ctx context.Context, userID string) ([]byte, error) {
+	key, ok := k[userID]
+	if !ok {
+		key = make([]byte, 32)
+		rand.Read(key)
+		k[userID] = key
+	}
+	return key, nil
+}
+
+func readAll(t *testing.T, store StorageRepository, path, userID string) ([]byte, error) {
+	r, err := store.Open(context.Background(), path, userID)
+	require.NoError(t, err)
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	store := NewEncryptedStorage(NewStorageRepository(root), staticKeys{})
+
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		checksum, err := Put(context.Background(), store, "f.bin", "1", bytes.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, Checksum(content), checksum, "size %d", size)
+
+		data, err := readAll(t, store, "f.bin", "1")
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, content, data, "size %d", size)
+
+		raw, err := os.ReadFile(filepath.Join(root, "1", "f.bin"))
+		require.NoError(t, err)
+		// Shorter random plaintext could turn up in the ciphertext by chance.
+		if size >= 16 {
+			assert.NotContains(t, string(raw), string(content[:16]), "plaintext at rest, size %d", size)
+		}
+	}
+}
+
+func TestEncryptedStorageDetectsTampering(t *testing.T) {
+	root := t.TempDir()
+	keys := staticKeys{}
+	store := NewEncryptedStorage(NewStorageRepository(root), keys)
+	content := bytes.Repeat([]byte("secret "), encChunkSize/3)
+	_, err := Put(context.Background(), store, "doc.bin", "1", bytes.NewReader(content))
+	require.NoError(t, err)
+	path := filepath.Join(root, "1", "doc.bin")
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		mutate func(b []byte) []byte
+	}{
+		{"flipped bit", func(b []byte) []byte { b[len(b)/2] ^= 1; return b }},
+		{"truncated at chunk boundary", func(b []byte) []byte { return b[:encHeaderLen+encChunkSize+16] }},
+		{"truncated mid chunk", func(b []byte) []byte { return b[:len(b)-10] }},
+		{"altered header", func(b []byte) []byte { b[len(encMagic)] ^= 1; return b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, tt.mutate(append([]byte(nil), raw...)), 0o644))
+			_, err := readAll(t, store, "doc.bin", "1")
+			assert.ErrorIs(t, err, ErrChecksumMismatch)
+		})
+	}
+
+	t.Run("another user's key", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, raw, 0o644))
+		keys["2"] = keys["1"]
+		keys["1"] = make([]byte, 32)
+		_, err := readAll(t, store, "doc.bin", "1")
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+}
+
+func TestEncryptedStorageReadsPlaintextFiles(t *testing.T) {
+	inner := NewStorageRepository(t.TempDir())
+	put(t, inner, "old.bin", "1", "written before encryption")
+	put(t, inner, "s", "1", "ab")
+
+	store := NewEncryptedStorage(inner, staticKeys{})
+	data, err := readAll(t, store, "old.bin", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "written before encryption", string(data))
+	data, err = readAll(t, store, "s", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(data))
+}
+
+func TestEncryptedStorageDiscardsCancelledWrite(t *testing.T) {
+	store := NewEncryptedStorage(NewStorageRepository(t.TempDir()), staticKeys{})
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := store.Create(ctx, "partial.bin", "1")
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("p"), 1024))
+	require.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, w.Close(), context.Canceled)
+
+	ok, err := store.Exists(context.Background(), "partial.bin", "1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
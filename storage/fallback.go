@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// fallbackStorage writes to primary and reads from the first backend that
+// has the file, so images keep loading while they are migrated off the
+// fallbacks. List and Owners cover every backend, the way reads do.
+type fallbackStorage struct {
+	primary   StorageRepository
+	fallbacks []StorageRepository
+}
+
+func NewFallbackStorage(primary StorageRepository, fallbacks ...StorageRepository) StorageRepository {
+	return &fallbackStorage{primary: primary, fallbacks: fallbacks}
+}
+
+func (s *fallbackStorage) Backend() string {
+	return BackendOf(s.primary)
+}
+
+func (s *fallbackStorage) all() []StorageRepository {
+	return append([]StorageRepository{s.primary}, s.fallbacks...)
+}
+
+func (s *fallbackStorage) Create(ctx context.Context, path string, userID string) (io.WriteCloser, error) {
+	return s.primary.Create(ctx, path, userID)
+}
+
+func (s *fallbackStorage) Open(ctx context.Context, path string, userID string) (io.ReadCloser, error) {
+	for _, store := range s.all() {
+		r, err := store.Open(ctx, path, userID)
+		if !errors.Is(err, ErrNotExist) {
+			return r, err
+		}
+	}
+	return nil, ErrNotExist
+}
+
+func (s *fallbackStorage) Stat(ctx context.Context, path string, userID string) (FileInfo, error) {
+	for _, store := range s.all() {
+		info, err := store.Stat(ctx, path, userID)
+		if !errors.Is(err, ErrNotExist) {
+			return info, err
+		}
+	}
+	return FileInfo{}, ErrNotExist
+}
+
+func (s *fallbackStorage) Exists(ctx context.Context, path string, userID string) (bool, error) {
+	for _, store := range s.all() {
+		ok, err := store.Exists(ctx, path, userID)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Delete removes the file everywhere, so a file deleted mid-migration does
+// not linger on the old backend.
+func (s *fallbackStorage) Delete(ctx context.Context, path string, userID string) error {
+	for _, store := range s.all() {
+		if err := store.Delete(ctx, path, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List reports each path once, as the backend Open would read it from.
+func (s *fallbackStorage) List(ctx context.Context, prefix string, userID string) ([]FileInfo, error) {
+	var files []FileInfo
+	seen := map[string]bool{}
+	for _, store := range s.all() {
+		list, err := store.List(ctx, prefix, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+func (s *fallbackStorage) Owners(ctx context.Context) ([]string, error) {
+	var owners []string
+	seen := map[string]bool{}
+	for _, store := range s.all() {
+		list, err := store.Owners(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, owner := range list {
+			if !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Storage keeps images in one bucket of an S3-compatible store, keyed by
+// "<userID>/<path>" like the local layout, so every API replica sees the
+// same files.
+type s3Storage struct {
+	client   *minio.Client
+	bucket   string
+	partSize uint64
+}
+
+// NewS3StorageRepository connects to the store and creates the bucket if it
+// does not exist yet.
+func NewS3StorageRepository(ctx context.Context, cfg config.S3) (StorageRepository, error) {
+	return newS3Storage(ctx, cfg, nil)
+}
+
+// newS3Storage lets tests supply the HTTP transport; nil uses the default.
+func newS3Storage(ctx context.Context, cfg config.S3, transport http.RoundTripper) (*s3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 storage backend")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:    cfg.UseSSL,
+		Region:    cfg.Region,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the S3 client %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the S3 bucket %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create the S3 bucket %w", err)
+		}
+	}
+	return &s3Storage{client: client, bucket: cfg.Bucket, partSize: cfg.PartSize}, nil
+}
+
+func objectKey(userID, p string) string {
+	return path.Join(userID, p)
+}
+
+// s3Writer feeds a PutObject running in the background through a pipe, so
+// the caller's writes stream to the store as they happen.
+type s3Writer struct {
+	ctx  context.Context
+	pw   *io.PipeWriter
+	done chan error
+	once sync.Once
+	err  error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	w.once.Do(func() {
+		if err := w.ctx.Err(); err != nil {
+			w.pw.CloseWithError(err)
+		} else {
+			w.pw.Close()
+		}
+		w.err = <-w.done
+	})
+	return w.err
+}
+
+func (s *s3Storage) Backend() string {
+	return "s3"
+}
+
+func (s *s3Storage) Create(ctx context.Context, p string, userID string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	w := &s3Writer{ctx: ctx, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := s.put(ctx, objectKey(userID, p), pr)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// put uploads src. Anything that fits in one part goes up in a single PUT;
+// larger sources switch to a multipart upload that sends one part at a time
+// instead of holding the whole file.
+func (s *s3Storage) put(ctx context.Context, key string, src io.Reader) error {
+	src = ctxReader{ctx, src}
+	head, err := io.ReadAll(io.LimitReader(src, int64(s.partSize)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("failed to write file content")
+	}
+
+	body, size := io.Reader(bytes.NewReader(head)), int64(len(head))
+	if uint64(size) == s.partSize {
+		body, size = io.MultiReader(body, src), -1
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{PartSize: s.partSize})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("failed to save the image in the bucket")
+	}
+	return nil
+}
+
+// Open streams the object from the store as it is consumed, rather than
+// downloading it first.
+func (s *s3Storage) Open(ctx context.Context, p string, userID string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, objectKey(userID, p), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat issues the request and surfaces a missing key.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s.mapErr(ctx, err)
+	}
+	return obj, nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, p string, userID string) (FileInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, objectKey(userID, p), minio.StatObjectOptions{})
+	if err != nil {
+		return FileInfo{}, s.mapErr(ctx, err)
+	}
+	return FileInfo{Path: p, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *s3Storage) Exists(ctx context.Context, p string, userID string) (bool, error) {
+	_, err := s.Stat(ctx, p, userID)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the object; S3 treats deleting a missing key as success.
+func (s *s3Storage) Delete(ctx context.Context, p string, userID string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, objectKey(userID, p), minio.RemoveObjectOptions{}); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("failed to delete the image")
+	}
+	return nil
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string, userID string) ([]FileInfo, error) {
+	root := userID + "/"
+	files := []FileInfo{}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: root + prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, s.mapErr(ctx, obj.Err)
+		}
+		files = append(files, FileInfo{Path: strings.TrimPrefix(obj.Key, root), Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return files, nil
+}
+
+// Owners lists the top-level prefixes, which are the user IDs of objectKey.
+func (s *s3Storage) Owners(ctx context.Context) ([]string, error) {
+	owners := []string{}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{}) {
+		if obj.Err != nil {
+			return nil, s.mapErr(ctx, obj.Err)
+		}
+		if owner, ok := strings.CutSuffix(obj.Key, "/"); ok {
+			owners = append(owners, owner)
+		}
+	}
+	return owners, nil
+}
+
+func (s *s3Storage) mapErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return ErrNotExist
+	}
+	return err
+}
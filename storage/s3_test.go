@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves an in-memory S3 API and counts multipart part uploads. It
+// listens on TLS because the fake does not decode the chunk-signed bodies
+// clients send over plain HTTP.
+func fakeS3(t *testing.T) (config.S3, http.RoundTripper, *atomic.Int32) {
+	faker := gofakes3.New(s3mem.New())
+	parts := new(atomic.Int32)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Query().Has("uploadId") {
+			parts.Add(1)
+		}
+		faker.Server().ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return config.S3{
+		Endpoint:        strings.TrimPrefix(srv.URL, "https://"),
+		Region:          "us-east-1",
+		Bucket:          "pixelforge",
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		UseSSL:          true,
+		PartSize:        5 << 20, // the S3 minimum
+	}, srv.Client().Transport, parts
+}
+
+func TestS3Storage(t *testing.T) {
+	cfg, transport, _ := fakeS3(t)
+	store, err := newS3Storage(context.Background(), cfg, transport)
+	require.NoError(t, err)
+	testBackend(t, store)
+
+	// a second repository over the same bucket sees the same files
+	other, err := newS3Storage(context.Background(), cfg, transport)
+	require.NoError(t, err)
+	ok, err := other.Exists(context.Background(), "a/one.bin", "1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestS3StorageMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{name: "small file is a single put", size: 1 << 20},
+		{name: "large file uses multipart", size: 12 << 20, multipart: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, transport, parts := fakeS3(t)
+			ctx := context.Background()
+			store, err := newS3Storage(ctx, cfg, transport)
+			require.NoError(t, err)
+
+			data := make([]byte, tt.size)
+			_, err = rand.Read(data)
+			require.NoError(t, err)
+			_, err = Put(ctx, store, "big.bin", "1", bytes.NewReader(data))
+			require.NoError(t, err)
+
+			info, err := store.client.StatObject(ctx, cfg.Bucket, "1/big.bin", minio.StatObjectOptions{})
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.size, info.Size)
+
+			if tt.multipart {
+				assert.EqualValues(t, 3, parts.Load())
+			} else {
+				assert.Zero(t, parts.Load())
+			}
+		})
+	}
+}
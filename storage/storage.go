@@ -2,21 +2,84 @@
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
-	"mime/multipart"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
 )
 
+// ErrNotExist means no file is stored at the path.
+var ErrNotExist = errors.New("file not found")
+
+// tempSuffix marks in-flight writes; List never reports them.
+const tempSuffix = ".tmp-"
+
+// StorageRepository stores opaque bytes per user. Paths are relative to the
+// user's space and always use forward slashes. Backends know nothing about
+// image formats; decoding lives in the processor.
 type StorageRepository interface {
-	Save(path string, userID string, file *multipart.FileHeader) error
-	Read(path string, userID string) (image.Image, string, error)
-	SaveTransformedImage(userID string, path string, data []byte) error
+	// Create returns a writer for path. The file becomes visible when Close
+	// succeeds; cancelling ctx before Close discards it.
+	Create(ctx context.Context, path string, userID string) (io.WriteCloser, error)
+	// Open streams the file at path. It returns ErrNotExist for a missing file.
+	Open(ctx context.Context, path string, userID string) (io.ReadCloser, error)
+	Stat(ctx context.Context, path string, userID string) (FileInfo, error)
+	Exists(ctx context.Context, path string, userID string) (bool, error)
+	// Delete removes the file. A file that is already gone is not an error.
+	Delete(ctx context.Context, path string, userID string) error
+	// List returns every file of the user whose path starts with prefix.
+	List(ctx context.Context, prefix string, userID string) ([]FileInfo, error)
+	// Owners returns the user ID of every user space holding files, whether
+	// or not the user still exists.
+	Owners(ctx context.Context) ([]string, error)
+}
+
+// BackendOf names the backend s writes to, such as "local" or "s3", so
+// records can say where their file lives. It is "" if s does not say.
+func BackendOf(s StorageRepository) string {
+	if named, ok := s.(interface{ Backend() string }); ok {
+		return named.Backend()
+	}
+	return ""
+}
+
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Put writes all of src to path, the way os.WriteFile wraps os.Create, and
+// returns the checksum of the bytes written for OpenVerified. If the copy
+// fails, the write is cancelled before Close so nothing is stored.
+func Put(ctx context.Context, s StorageRepository, path string, userID string, src io.Reader) (string, error) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.Create(wctx, path, userID)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), ctxReader{ctx, src}); err != nil {
+		cancel()
+		w.Close()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", errors.New("failed to write file content")
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return formatChecksum(h), nil
 }
 
 type storageRepository struct {
@@ -27,52 +90,229 @@ func NewStorageRepository(rootDir string) StorageRepository {
 	return &storageRepository{RootDir: rootDir}
 }
 
-func (s *storageRepository) Save(path string, userID string, file *multipart.FileHeader) error {
-	fullpath := filepath.Join(s.RootDir, userID, path)
-	// if err := os.MkdirAll(filepath.Dir(fullpath), os.ModePerm); err != nil {
-	if err := os.MkdirAll(filepath.Dir(fullpath), 0o755); err != nil {
-		return err
+// New returns the backend cfg selects, reading through to cfg.Fallbacks for
+// files it does not have.
+func New(ctx context.Context, cfg config.Storage) (StorageRepository, error) {
+	primary, err := Open(ctx, cfg, cfg.Backend)
+	if err != nil || len(cfg.Fallbacks) == 0 {
+		return primary, err
 	}
-	src, err := file.Open()
-	if err != nil {
-		return errors.New("failed to open source file")
+	fallbacks := make([]StorageRepository, 0, len(cfg.Fallbacks))
+	for _, name := range cfg.Fallbacks {
+		store, err := Open(ctx, cfg, name)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %w", err)
+		}
+		fallbacks = append(fallbacks, store)
+	}
+	return NewFallbackStorage(primary, fallbacks...), nil
+}
+
+// Open returns the backend called name, configured from cfg.
+func Open(ctx context.Context, cfg config.Storage, name string) (StorageRepository, error) {
+	switch name {
+	case "local":
+		return NewStorageRepository(cfg.RootDir), nil
+	case "s3":
+		return NewS3StorageRepository(ctx, cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", name)
+}
+
+// ctxReader fails reads once ctx is done, so long copies and decodes stop
+// when the request is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (s *storageRepository) Backend() string {
+	return "local"
+}
+
+func (s *storageRepository) fullPath(path, userID string) string {
+	return filepath.Join(s.RootDir, userID, filepath.FromSlash(path))
+}
+
+// localWriter writes to a temporary file next to the destination and only
+// renames it into place on Close, after an fsync, so a crash never leaves a
+// truncated file at the final path. After a failed Write the temporary file
+// is incomplete, so Close discards it too.
+type localWriter struct {
+	ctx    context.Context
+	file   *os.File
+	dest   string
+	failed bool
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
 	}
-	defer src.Close()
-	dst, err := os.Create(fullpath)
+	n, err := w.file.Write(p)
 	if err != nil {
-		return errors.New("failed to save the image in the folder")
+		w.failed = true
 	}
-	defer dst.Close()
+	return n, err
+}
 
-	if _, err = io.Copy(dst, src); err != nil {
+func (w *localWriter) Close() error {
+	tmp := w.file.Name()
+	syncErr := w.file.Sync()
+	closeErr := w.file.Close()
+	if err := w.ctx.Err(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if w.failed || syncErr != nil || closeErr != nil {
+		os.Remove(tmp)
+		return errors.New("failed to write file content")
+	}
+	if err := os.Rename(tmp, w.dest); err != nil {
+		os.Remove(tmp)
 		return errors.New("failed to write file content")
 	}
+	return syncDir(filepath.Dir(w.dest))
+}
 
-	return nil
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempSuffix)
 }
 
-func (s *storageRepository) Read(path string, userID string) (image.Image, string, error) {
-	fullpath := filepath.Join(s.RootDir, userID, path)
-	file, err := os.Open(fullpath)
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		return nil, "", errors.New("file not found ")
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *storageRepository) Create(ctx context.Context, path string, userID string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	defer file.Close()
-	file.Seek(0, 0)
-	img, format, err := image.Decode(file)
+	fullpath := s.fullPath(path, userID)
+	if err := os.MkdirAll(filepath.Dir(fullpath), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(fullpath), "."+filepath.Base(fullpath)+tempSuffix+"*")
 	if err != nil {
-		return nil, "", fmt.Errorf("Format error in decoding %w", err)
+		return nil, errors.New("failed to save the image in the folder")
 	}
-	return img, format, nil
+	return &localWriter{ctx: ctx, file: file, dest: fullpath}, nil
 }
 
-func (s *storageRepository) SaveTransformedImage(userID string, path string, data []byte) error {
-	fullpath := filepath.Join(s.RootDir, userID, path)
-	dst, err := os.Create(fullpath)
+type localReader struct {
+	ctxReader
+	io.Closer
+}
+
+func (s *storageRepository) Open(ctx context.Context, path string, userID string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.fullPath(path, userID))
 	if err != nil {
-		return errors.New("failed to save the transformed Image")
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+		return nil, err
 	}
+	return localReader{ctxReader{ctx, file}, file}, nil
+}
 
-	defer dst.Close()
+func (s *storageRepository) Stat(ctx context.Context, path string, userID string) (FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return FileInfo{}, err
+	}
+	info, err := os.Stat(s.fullPath(path, userID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return FileInfo{}, ErrNotExist
+		}
+		return FileInfo{}, err
+	}
+	return FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *storageRepository) Exists(ctx context.Context, path string, userID string) (bool, error) {
+	_, err := s.Stat(ctx, path, userID)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *storageRepository) Delete(ctx context.Context, path string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(s.fullPath(path, userID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.New("failed to delete the image")
+	}
 	return nil
 }
+
+func (s *storageRepository) List(ctx context.Context, prefix string, userID string) ([]FileInfo, error) {
+	root := filepath.Join(s.RootDir, userID)
+	files := []FileInfo{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || isTemp(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{Path: rel, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *storageRepository) Owners(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.RootDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	owners := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			owners = append(owners, e.Name())
+		}
+	}
+	return owners, nil
+}
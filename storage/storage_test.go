@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/HarshithRajesh/PixelForge/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, store StorageRepository, path, userID, content string) string {
+	checksum, err := Put(context.Background(), store, path, userID, strings.NewReader(content))
+	require.NoError(t, err)
+	return checksum
+}
+
+// testBackend checks the behaviour every StorageRepository must share.
+func testBackend(t *testing.T, store StorageRepository) {
+	ctx := context.Background()
+
+	t.Run("round trip", func(t *testing.T) {
+		checksum := put(t, store, "a/one.bin", "1", "hello")
+		assert.Equal(t, Checksum([]byte("hello")), checksum)
+
+		r, err := store.Open(ctx, "a/one.bin", "1")
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, "hello", string(data))
+
+		info, err := store.Stat(ctx, "a/one.bin", "1")
+		require.NoError(t, err)
+		assert.Equal(t, FileInfo{Path: "a/one.bin", Size: 5, ModTime: info.ModTime}, info)
+		assert.False(t, info.ModTime.IsZero())
+	})
+
+	t.Run("missing files", func(t *testing.T) {
+		_, err := store.Open(ctx, "a/one.bin", "2")
+		assert.ErrorIs(t, err, ErrNotExist, "paths are scoped by user")
+		_, err = store.Stat(ctx, "nope.bin", "1")
+		assert.ErrorIs(t, err, ErrNotExist)
+		ok, err := store.Exists(ctx, "nope.bin", "1")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("list by prefix", func(t *testing.T) {
+		put(t, store, "a/two.bin", "1", "x")
+		put(t, store, "b/three.bin", "1", "y")
+		put(t, store, "a/other-user.bin", "2", "z")
+
+		files, err := store.List(ctx, "a/", "1")
+		require.NoError(t, err)
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, f.Path)
+		}
+		sort.Strings(paths)
+		assert.Equal(t, []string{"a/one.bin", "a/two.bin"}, paths)
+
+		files, err = store.List(ctx, "", "3")
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("owners", func(t *testing.T) {
+		owners, err := store.Owners(ctx)
+		require.NoError(t, err)
+		sort.Strings(owners)
+		assert.Equal(t, []string{"1", "2"}, owners)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, store.Delete(ctx, "a/two.bin", "1"))
+		ok, err := store.Exists(ctx, "a/two.bin", "1")
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.NoError(t, store.Delete(ctx, "a/two.bin", "1"), "deleting twice is fine")
+	})
+
+	t.Run("verified reads", func(t *testing.T) {
+		checksum := put(t, store, "v.bin", "1", "original")
+
+		r, err := OpenVerified(ctx, store, "v.bin", "1", checksum)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(data))
+
+		put(t, store, "v.bin", "1", "tampered")
+		r, err = OpenVerified(ctx, store, "v.bin", "1", checksum)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		r.Close()
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("unfinished write is invisible", func(t *testing.T) {
+		w, err := store.Create(ctx, "pending.bin", "1")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("data"))
+		require.NoError(t, err)
+
+		ok, err := store.Exists(ctx, "pending.bin", "1")
+		require.NoError(t, err)
+		assert.False(t, ok, "nothing at the final path before Close")
+		files, err := store.List(ctx, "pending", "1")
+		require.NoError(t, err)
+		assert.Empty(t, files)
+
+		require.NoError(t, w.Close())
+		ok, err = store.Exists(ctx, "pending.bin", "1")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("cancelled write is discarded", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		w, err := store.Create(cctx, "partial.bin", "1")
+		require.NoError(t, err)
+		_, err = w.Write(bytes.Repeat([]byte("p"), 1024))
+		require.NoError(t, err)
+		cancel()
+		assert.ErrorIs(t, w.Close(), context.Canceled)
+
+		ok, err := store.Exists(ctx, "partial.bin", "1")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("failed copy is discarded", func(t *testing.T) {
+		src := io.MultiReader(strings.NewReader("the first part"), iotest.ErrReader(errors.New("connection reset")))
+		_, err := Put(ctx, store, "broken.bin", "1", src)
+		assert.Error(t, err)
+
+		ok, err := store.Exists(ctx, "broken.bin", "1")
+		require.NoError(t, err)
+		assert.False(t, ok)
+		files, err := store.List(ctx, "broken", "1")
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	testBackend(t, NewStorageRepository(root))
+
+	entries, err := os.ReadDir(filepath.Join(root, "1"))
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, isTemp(e.Name()), "temporary file %s left behind", e.Name())
+	}
+}
+
+func TestNewSelectsBackend(t *testing.T) {
+	store, err := New(context.Background(), config.Storage{Backend: "local", RootDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.NotNil(t, store)
+
+	store, err = New(context.Background(), config.Storage{Backend: "local", Fallbacks: []string{"local"}, RootDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.IsType(t, &fallbackStorage{}, store)
+
+	_, err = New(context.Background(), config.Storage{Backend: "ftp"})
+	assert.EqualError(t, err, `unknown storage backend "ftp"`)
+
+	_, err = New(context.Background(), config.Storage{Backend: "s3"})
+	assert.Error(t, err)
+}
+
+func TestFallbackStorage(t *testing.T) {
+	ctx := context.Background()
+	primary, old := NewStorageRepository(t.TempDir()), NewStorageRepository(t.TempDir())
+	put(t, old, "old.bin", "1", "old")
+	store := NewFallbackStorage(primary, old)
+
+	testBackend(t, store)
+
+	r, err := store.Open(ctx, "old.bin", "1")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data), "read through to the fallback")
+	files, err := store.List(ctx, "old", "1")
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "listing covers the fallback")
+
+	put(t, store, "new.bin", "1", "new")
+	ok, err := old.Exists(ctx, "new.bin", "1")
+	require.NoError(t, err)
+	assert.False(t, ok, "writes only go to the primary")
+
+	require.NoError(t, store.Delete(ctx, "old.bin", "1"))
+	ok, err = old.Exists(ctx, "old.bin", "1")
+	require.NoError(t, err)
+	assert.False(t, ok, "deletes reach the fallback")
+	assert.Equal(t, "local", BackendOf(store))
+}